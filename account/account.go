@@ -19,39 +19,14 @@ package main
 import (
 	"fmt"
 
+	"encoding/json"
+	"github.com/chaincode/common"
+	"github.com/chaincode/decimal"
 	"github.com/hyperledger/fabric/core/chaincode/shim"
 	pb "github.com/hyperledger/fabric/protos/peer"
 	"strconv"
-	"encoding/json"
-	"bytes"
-	"github.com/golang/protobuf/proto"
 )
 
-type assetIssue struct {
-	Owner      string `json:"owner"`
-	Balance	   int    `json:"balance"`
-	Name       string `json:"name"`
-}
-
-type UserAsset struct {
-	Expire     int    `json:"expire"`
-	Amount	   int    `json:"amount"`
-}
-
-// This struct represents an Identity
-// (with its MSP identifier) to be used
-// to serialize it and deserialize it
-type SerializedIdentity struct {
-	// The identifier of the associated membership service provider
-	Mspid string `protobuf:"bytes,1,opt,name=Mspid" json:"Mspid,omitempty"`
-	// the Identity, serialized according to the rules of its MPS
-	IdBytes []byte `protobuf:"bytes,2,opt,name=IdBytes,proto3" json:"IdBytes,omitempty"`
-}
-
-func (m *SerializedIdentity) Reset()                    { *m = SerializedIdentity{} }
-func (m *SerializedIdentity) String() string            { return proto.CompactTextString(m) }
-func (*SerializedIdentity) ProtoMessage()               {}
-
 // BonusManagementChaincode is simple chaincode implementing a basic Asset Management system
 // with access control enforcement at chaincode level.
 // Look here for more information on how to implement access control at chaincode level:
@@ -60,56 +35,49 @@ func (*SerializedIdentity) ProtoMessage()               {}
 type AccountManagementChaincode struct {
 }
 
+const (
+	// EventAccountIssued is emitted by issue, with the common.AssetIssue
+	EventAccountIssued = "AccountIssued"
+	// EventAccountAssigned is emitted by assign, with the Assignment
+	EventAccountAssigned = "AccountAssigned"
+)
+
+// Assignment is the payload of the event emitted by assign
+type Assignment struct {
+	Asset   string             `json:"asset"`
+	User    string             `json:"user"`
+	Details []common.UserAsset `json:"details"`
+}
+
 // Init method will be called during deployment.
 // The deploy transaction metadata is supposed to contain the administrator cert
-//func (t *BonusManagementChaincode) Init(stub *shim.ChaincodeStub, function string, args []string) ([]byte, error) {
+// func (t *BonusManagementChaincode) Init(stub *shim.ChaincodeStub, function string, args []string) ([]byte, error) {
 func (t *AccountManagementChaincode) Init(stub shim.ChaincodeStubInterface) pb.Response {
 	fmt.Println("Init Chaincode...")
 
-	// Set the role of the users that are allowed to assign assets
-	// The metadata will contain the role of the users that are allowed to assign assets
-	adminCert, err := stub.GetCreator()
-	if err != nil {
-		return shim.Error("Failed getting createor" + err.Error())
-	}
-
-	fmt.Println("admin's cert is " + string(adminCert))
-
-	if len(adminCert) == 0 {
-		return shim.Error("Invalid assigner role. Empty.")
-	}
-
-	stub.PutState("admin", adminCert)
-
-	return shim.Success(nil)
+	// The creator of the deploy transaction is the administrator, who
+	// issues the assets
+	return common.InitAdmin(stub)
 }
 
+// issue creates an asset with its balance
+// args: assetName, organizationCert, balance, [scale]
 func (t *AccountManagementChaincode) issue(stub shim.ChaincodeStubInterface, args []string) pb.Response {
 	fmt.Println("Issue...")
 
-	if len(args) != 3 {
-		return shim.Error("Incorrect number of arguments. Expecting 3")
-	}
-	admin, err := stub.GetState("admin")
-	if err != nil {
-		return shim.Error("Failed to get admin's cert, error: " + err.Error())
-	}
-
-	creator, err := stub.GetCreator()
-	if err != nil {
-		fmt.Println(err.Error())
-		return shim.Error("Failed to get creator's cert, error: " + err.Error())
-	}
-	if bytes.Compare(admin, creator) != 0 {
-		fmt.Println("admin cert:" + string(admin))
-		fmt.Println("creator cert:" + string(creator))
-		return shim.Error("Failed, the cert of creator and caller is not same")
-	}
 	assetName := args[0]
 	organizationCert := args[1]
-	balance, err := strconv.Atoi(args[2])
+	scale := 0
+	if len(args) == 4 {
+		var err error
+		scale, err = strconv.Atoi(args[3])
+		if err != nil || scale < 0 || scale > decimal.MaxScale {
+			return shim.Error(fmt.Sprintf("scale must be an integer between 0 and %d", decimal.MaxScale))
+		}
+	}
+	balance, err := common.ParseAmount(args[2], scale)
 	if err != nil {
-		return shim.Error("Expecting integer value for asset holding")
+		return shim.Error("balance argument is incorrect: " + err.Error())
 	}
 	oldAsset, err := stub.GetState(assetName)
 	if err != nil {
@@ -119,125 +87,123 @@ func (t *AccountManagementChaincode) issue(stub shim.ChaincodeStubInterface, arg
 		return shim.Error("asset already issue, please try other name")
 	}
 
-	asset := &assetIssue{organizationCert, balance, assetName}
+	asset := &common.AssetIssue{Owner: organizationCert, Balance: balance, Name: assetName, Scale: scale}
 	assetJSONasBytes, err := json.Marshal(asset)
 	if err != nil {
 		return shim.Error(err.Error())
 	}
-	fmt.Println("Issue... asset name: " + assetName + ", detail: " + assetName, string(assetJSONasBytes))
+	fmt.Println("Issue... asset name: "+assetName+", detail: "+assetName, string(assetJSONasBytes))
 	err = stub.PutState(assetName, assetJSONasBytes)
 	if err != nil {
 		return shim.Error(err.Error())
 	}
+	err = common.SetEvent(stub, EventAccountIssued, asset)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
 
 	fmt.Println("Issue...done!")
 
 	return shim.Success(nil)
 }
 
+// assign sets the detail of the asset held by a user
+// args: assetName, user, detail
 func (t *AccountManagementChaincode) assign(stub shim.ChaincodeStubInterface, args []string) pb.Response {
-	fmt.Println("Assign... arg length: %d", len(args))
+	fmt.Printf("Assign... arg length: %d\n", len(args))
 
-	if len(args) != 3 {
-		return shim.Error("Incorrect number of arguments. Expecting 3")
-	}
 	assetName := args[0]
-	fmt.Println("get Issue... asset name: %s" + assetName)
-	assetJSONasBytes, err := stub.GetState(assetName)
-	if err != nil {
-		return shim.Error("asset state get failed, have not issued")
-	}
-	if assetJSONasBytes == nil {
-		return shim.Error("asset have not issued:" + assetName)
-	}
-
-	var assetJSON assetIssue
-	err = json.Unmarshal(assetJSONasBytes, &assetJSON)
-	fmt.Println("asset json string:", assetJSONasBytes)
+	fmt.Printf("get Issue... asset name: %s\n", assetName)
+	assetJSON, err := common.GetIssuedAsset(stub, assetName)
 	if err != nil {
-		return shim.Error("Error Failed to decode JSON of: " + assetName + " resason " + err.Error() + " json:" + string(assetJSONasBytes))
+		return shim.Error(err.Error())
 	}
-	organizationCert := []byte(assetJSON.Owner)
-	serializedID, err := stub.GetCreator()
+	creator, err := common.GetCaller(stub)
 	if err != nil {
-		return shim.Error("can not get creator, error:" + err.Error())
+		return shim.Error(err.Error())
 	}
-
-	sid := &SerializedIdentity{}
-	err = proto.Unmarshal(serializedID, sid)
-	creator := sid.IdBytes
-
-	fmt.Println("admin cert length, %d", len(organizationCert))
-	fmt.Println("creator cert length, %d", len(creator))
-
-	fmt.Println("admin cert:%x", organizationCert)
-	fmt.Println("creator cert:x", creator)
-
-	if bytes.Compare(organizationCert, creator) != 0 {
+	if assetJSON.Owner != creator {
 		return shim.Error("the caller is not the asset's owner")
 	}
 	user := args[1]
 	detail := args[2]
+	var userAssets []common.UserAsset
+	err = json.Unmarshal([]byte(detail), &userAssets)
+	if err != nil {
+		return shim.Error("detail argument is incorrect: " + err.Error())
+	}
+	for i := range userAssets {
+		if userAssets[i].Amount.Sign() < 0 {
+			return shim.Error("the amount must not negative")
+		}
+		userAssets[i].Amount, err = userAssets[i].Amount.Rescale(assetJSON.Scale)
+		if err != nil {
+			return shim.Error("detail amount is incorrect: " + err.Error())
+		}
+	}
+	userAssetsJSONasBytes, err := json.Marshal(userAssets)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
 
 	ownerKey := assetName + user
-	err = stub.PutState(ownerKey, []byte(detail))
+	err = stub.PutState(ownerKey, userAssetsJSONasBytes)
 	if err != nil {
 		return shim.Error("store user's asset failed")
 	}
+	err = common.SetEvent(stub, EventAccountAssigned, Assignment{Asset: assetName, User: user, Details: userAssets})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
 
 	return shim.Success(nil)
 }
 
-// Invoke will be called for every transaction.
-// Supported functions are the following:
-// "assign(asset, owner)": to assign ownership of assets. An asset can be owned by a single entity.
-// Only an administrator can call this function.
-// "transfer(asset, newOwner)": to transfer the ownership of an asset. Only the owner of the specific
-// asset can call this function.
-// An asset is any string to identify it. An owner is representated by one of his ECert/TCert.
-func (t *AccountManagementChaincode) Invoke(stub shim.ChaincodeStubInterface) pb.Response {
-	function, args := stub.GetFunctionAndParameters()
+// router declares the functions of the chaincode and the roles allowed to call them:
+// "issue": to issue an asset, by an admin or an issuer.
+// "assign": to set the asset held by a user, by the issuer owning the asset.
+// "query", "queryOrg": to query the balance of a user or the issue of an asset.
+// Anyone can invoke these functions.
+// "grantRole", "revokeRole", "queryRoles": manage the roles, by an admin.
+// "proposeAdmin", "approveAdmin", "queryAdmins", "queryProposal": change the admin set
+// once enough admins approved.
+func (t *AccountManagementChaincode) router() *common.Router {
+	r := common.NewRouter()
+	r.Handle("issue", t.issue, common.StringArg("assetName"), common.StringArg("owner"),
+		common.StringArg("balance"), common.Optional(common.IntArg("scale"))).
+		Require(common.RoleAdmin, common.RoleIssuer)
+	r.Handle("assign", t.assign, common.StringArg("assetName"), common.StringArg("user"),
+		common.JSONArg("detail")).
+		Require(common.RoleIssuer)
+	r.Handle("query", t.queryUserBalance, common.StringArg("user"), common.StringArg("assetName"))
+	r.Handle("queryOrg", t.queryOrganizationBalance, common.StringArg("assetName"))
+	common.HandleRoles(r)
+	common.HandleAdmins(r)
+	return r
+}
 
-	// Handle different functions
-	if function == "issue" {
-		// Assign ownership
-		return t.issue(stub, args)
-	} else if function == "assign" {
-		// Assign ownership
-		return t.assign(stub, args)
-	} else if function == "query" {
-		// Query owner
-		return t.query(stub, "user", args)
-	} else if function == "queryOrg" {
-		// Query owner
-		return t.query(stub, "organization", args)
-	}
-	//return nil, nil
-	return shim.Error("Received unknown function invocation:" + function)
+// Invoke will be called for every transaction, and dispatches it to the
+// function declared by router.
+func (t *AccountManagementChaincode) Invoke(stub shim.ChaincodeStubInterface) pb.Response {
+	return t.router().Invoke(stub)
 }
 
+// queryUserBalance returns the asset held by a user
+// args: user, assetName
 func (t *AccountManagementChaincode) queryUserBalance(stub shim.ChaincodeStubInterface, args []string) pb.Response {
-	var err error
-
-	if len(args) != 2 {
-		fmt.Println("Incorrect number of arguments. Expecting name of an asset to query")
-		return shim.Error("Incorrect number of arguments. Expecting name of an asset to query")
-	}
-
-	// Who is the owner of the asset?
-
 	owner := args[0]
-	if err != nil {
-		return shim.Error("Failed decoding owner")
-	}
-
 	assetName := args[1]
 	fmt.Println("Arg [%s]" + assetName)
 	ownerKey := assetName + owner
 	userAssetString, err := stub.GetState(ownerKey)
+	if err != nil {
+		return shim.Error("Failed to get user's asset: " + err.Error())
+	}
 	return shim.Success(userAssetString)
 }
 
+// queryOrganizationBalance returns the issue of an asset
+// args: assetName
 func (t *AccountManagementChaincode) queryOrganizationBalance(stub shim.ChaincodeStubInterface, args []string) pb.Response {
 	assetName := args[0]
 	userAssetString, err := stub.GetState(assetName)
@@ -248,27 +214,9 @@ func (t *AccountManagementChaincode) queryOrganizationBalance(stub shim.Chaincod
 	return shim.Success(userAssetString)
 }
 
-
-// Query callback representing the query of a chaincode
-// Supported functions are the following:
-// "query(asset)": returns the owner of the asset.
-// Anyone can invoke this function.
-func (t *AccountManagementChaincode) query(stub shim.ChaincodeStubInterface, function string, args []string) pb.Response {
-	fmt.Println("Query " + function)
-
-	if function == "user" {
-		return t.queryUserBalance(stub, args)
-	} else if function == "organization" {
-		return t.queryOrganizationBalance(stub, args)
-	} else {
-		return shim.Error("Invalid query function name. Expecting \"query\"")
-
-	}
-}
-
 func main() {
 	err := shim.Start(new(AccountManagementChaincode))
 	if err != nil {
 		fmt.Println("Error starting Simple chaincode: " + err.Error())
 	}
-}
\ No newline at end of file
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/chaincode/mockstub"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+func TestAssignRescalesTheDetail(t *testing.T) {
+	stub := mockstub.NewMockStub("account", new(AccountManagementChaincode))
+	stub.SetCreator("OrgMSP", []byte("org"))
+	if response := stub.MockInit("init", []string{"init"}); response.Status != shim.OK {
+		t.Fatal(response.Message)
+	}
+	if response := stub.MockInvoke("tx0", []string{"grantRole", "issuer", "OrgMSP", "", "", ""}); response.Status != shim.OK {
+		t.Fatal(response.Message)
+	}
+	if response := stub.MockInvoke("tx1", []string{"issue", "cash", "org", "1000", "2"}); response.Status != shim.OK {
+		t.Fatal(response.Message)
+	}
+	if response := stub.MockInvoke("tx2", []string{"assign", "cash", "alice", `[{"expire":20991231,"amount":"1.505"}]`}); response.Status == shim.OK {
+		t.Fatal("assigned more fractional digits than the scale")
+	}
+	if response := stub.MockInvoke("tx3", []string{"assign", "cash", "alice", `[{"expire":20991231,"amount":"-1"}]`}); response.Status == shim.OK {
+		t.Fatal("assigned a negative amount")
+	}
+	if response := stub.MockInvoke("tx4", []string{"assign", "cash", "alice", `[{"expire":20991231,"amount":1.5}]`}); response.Status != shim.OK {
+		t.Fatal(response.Message)
+	}
+	response := stub.MockInvoke("tx5", []string{"query", "alice", "cash"})
+	if string(response.Payload) != `[{"expire":20991231,"amount":"1.50"}]` {
+		t.Fatalf("balance of alice %s", response.Payload)
+	}
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/chaincode/services"
+	"github.com/hyperledger/fabric/peer/common"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+func deployCmd() *cobra.Command {
+	spec := &services.DeploySpec{}
+	var ctor string
+	var upgrade bool
+	cmd := &cobra.Command{
+		Use:   "deploy",
+		Short: "Deploy a chaincode, or upgrade it with --upgrade",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			input := struct{ Args []string }{}
+			if ctor != "" {
+				if err := json.Unmarshal([]byte(ctor), &input); err != nil {
+					return fmt.Errorf("Chaincode argument error: %s", err)
+				}
+			}
+			spec.Args = input.Args
+			spec.Channel = viper.GetString("chaincode.channel")
+
+			peer, err := newPeerServices()
+			if err != nil {
+				return err
+			}
+			defer peer.Close()
+			deploy := peer.Deploy
+			if upgrade {
+				deploy = peer.Upgrade
+			}
+			txID, err := deploy(spec)
+			if err != nil {
+				return err
+			}
+			fmt.Printf("deployed %s %s, transaction %s\n", spec.Name, spec.Version, txID)
+			return nil
+		},
+	}
+	flags := cmd.Flags()
+	flags.StringVarP(&spec.Name, "name", "n", "", "the name of the chaincode")
+	flags.StringVarP(&spec.Path, "path", "p", "", "the import path of the chaincode")
+	flags.StringVarP(&spec.Version, "version", "v", "", "the version of the chaincode")
+	flags.StringVarP(&ctor, "ctor", "c", "", `the arguments of Init, as {"Args":["init","a"]}`)
+	flags.StringVarP(&spec.Policy, "policy", "P", "", "the endorsement policy, as AND('Org1MSP.member', 'Org2MSP.member')")
+	flags.StringVar(&spec.Escc, "escc", "", "the endorsement system chaincode")
+	flags.StringVar(&spec.Vscc, "vscc", "", "the validation system chaincode")
+	flags.BoolVar(&upgrade, "upgrade", false, "upgrade the deployed chaincode to the version")
+	peerFlags(cmd)
+	return cmd
+}
+
+func invokeCmd() *cobra.Command {
+	var name string
+	cmd := &cobra.Command{
+		Use:   "invoke <function> [args...]",
+		Short: "Invoke a function of a chaincode and wait for the commit",
+		Args:  cobra.MinimumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			peer, err := newPeerServices()
+			if err != nil {
+				return err
+			}
+			defer peer.Close()
+			response, err := peer.Invoke(viper.GetString("chaincode.channel"), name, args[0], args[1:])
+			if err != nil {
+				return err
+			}
+			fmt.Printf("transaction %s committed in block %d\n", response.TxID, response.BlockNumber)
+			if len(response.Payload) > 0 {
+				fmt.Println(string(response.Payload))
+			}
+			return nil
+		},
+	}
+	cmd.Flags().StringVarP(&name, "name", "n", "", "the name of the chaincode")
+	cmd.MarkFlagRequired("name")
+	peerFlags(cmd)
+	return cmd
+}
+
+func queryCmd() *cobra.Command {
+	var name string
+	cmd := &cobra.Command{
+		Use:   "query <function> [args...]",
+		Short: "Query a chaincode and print the response",
+		Args:  cobra.MinimumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			peer, err := newPeerServices()
+			if err != nil {
+				return err
+			}
+			defer peer.Close()
+			response, err := peer.Query(viper.GetString("chaincode.channel"), name, args[0], args[1:])
+			if err != nil {
+				return err
+			}
+			fmt.Println(string(response.Payload))
+			return nil
+		},
+	}
+	cmd.Flags().StringVarP(&name, "name", "n", "", "the name of the chaincode")
+	cmd.MarkFlagRequired("name")
+	peerFlags(cmd)
+	return cmd
+}
+
+// peerFlags adds the flags of the peers and of the orderer
+func peerFlags(cmd *cobra.Command) {
+	flags := cmd.Flags()
+	flags.StringP("channel", "C", "", "the channel of the chaincode")
+	flags.StringSlice("peer", nil, "the endorsing peers")
+	flags.String("events", "", "the event endpoint of the peer")
+	flags.StringP("orderer", "o", "", "the orderer")
+}
+
+// newPeerServices loads the MSP and connects to the peers
+func newPeerServices() (*services.PeerServices, error) {
+	var mspMgrConfigDir = viper.GetString("peer.mspConfigPath")
+	if mspMgrConfigDir == "" {
+		return nil, errors.New("The MSP config path is not configured")
+	}
+	err := common.InitCrypto(mspMgrConfigDir)
+	if err != nil {
+		return nil, fmt.Errorf("Error initializing the MSP %s: %s", mspMgrConfigDir, err)
+	}
+	return services.NewPeerServices()
+}
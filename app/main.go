@@ -0,0 +1,91 @@
+// The app command registers and enrolls the users with the COP server, and
+// deploys, invokes and queries the chaincodes.
+//
+// Its settings are read from core.yaml, in the current directory or in
+// PEER_CFG_PATH. A setting is overridden by the environment, as
+// CORE_PEER_ADDRESS for peer.address, and by the flags.
+//
+//	app register alice --type client --group bank_a
+//	app enroll alice <secret>
+//	app keys show alice
+//	app deploy -n bonus -p github.com/chaincode/bonus -v 1.0 -P "AND('Org1MSP.member')"
+//	app invoke -n bonus transfer <to> bonus 10
+//	app query -n bonus query <owner>
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+// Constants go here.
+const cmdRoot = "core"
+
+// flagKeys are the settings overridden by the flags, by flag name
+var flagKeys = map[string]string{
+	"cop":       "member.serverURL",
+	"certs":     "member.certPath",
+	"registrar": "member.registrar",
+	"peer":      "peer.endorsers",
+	"events":    "peer.events.address",
+	"orderer":   "orderer.address",
+	"channel":   "chaincode.channel",
+}
+
+var mainCmd = &cobra.Command{
+	Use:   "app",
+	Short: "Register users and operate the chaincodes",
+	PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+		for name, key := range flagKeys {
+			if flag := cmd.Flags().Lookup(name); flag != nil {
+				viper.BindPFlag(key, flag)
+			}
+		}
+		return InitConfig(cmdRoot)
+	},
+	SilenceUsage: true,
+}
+
+// InitConfig initializes viper config
+func InitConfig(cmdRoot string) error {
+	viper.SetEnvPrefix(cmdRoot)
+	viper.AutomaticEnv()
+	replacer := strings.NewReplacer(".", "_")
+	viper.SetEnvKeyReplacer(replacer)
+
+	var alternativeCfgPath = os.Getenv("PEER_CFG_PATH")
+	if alternativeCfgPath != "" {
+		viper.AddConfigPath(alternativeCfgPath) // Path to look for the config file in
+	} else {
+		viper.AddConfigPath("./") // Path to look for the config file in
+		// Path to look for the config file in based on GOPATH
+		gopath := os.Getenv("GOPATH")
+		for _, p := range filepath.SplitList(gopath) {
+			peerpath := filepath.Join(p, "src/github.com/chaincode")
+			viper.AddConfigPath(peerpath)
+		}
+	}
+
+	// Now set the configuration file.
+	viper.SetConfigName(cmdRoot) // Name of config file (without extension)
+
+	err := viper.ReadInConfig() // Find and read the config file
+	if err != nil {             // Handle errors reading the config file
+		return fmt.Errorf("Fatal error when reading %s config file: %s\n", cmdRoot, err)
+	}
+
+	return nil
+}
+
+func main() {
+	mainCmd.AddCommand(registerCmd(), enrollCmd(), keysCmd())
+	mainCmd.AddCommand(deployCmd(), invokeCmd(), queryCmd())
+	if err := mainCmd.Execute(); err != nil {
+		os.Exit(1)
+	}
+}
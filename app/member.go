@@ -0,0 +1,163 @@
+package main
+
+import (
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+
+	"github.com/chaincode/services"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+const (
+	keySuffix  = "_key.pem"
+	certSuffix = "_cert.pem"
+)
+
+func registerCmd() *cobra.Command {
+	var clientType, group string
+	cmd := &cobra.Command{
+		Use:   "register <name>",
+		Short: "Register a user with the COP server and print its secret",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			registrar := viper.GetString("member.registrar")
+			if registrar == "" {
+				return errors.New("The registrar is not configured")
+			}
+			memberService, err := newMemberServices(registrar)
+			if err != nil {
+				return err
+			}
+			secret, err := memberService.Register(args[0], clientType, group)
+			if err != nil {
+				return fmt.Errorf("Error registering %s: %s", args[0], err)
+			}
+			fmt.Println(secret)
+			return nil
+		},
+	}
+	flags := cmd.Flags()
+	flags.StringVar(&clientType, "type", "client", "the type of the user")
+	flags.StringVar(&group, "group", "", "the group of the user")
+	flags.String("registrar", "", "the enrolled identity registering the user")
+	memberFlags(cmd)
+	return cmd
+}
+
+func enrollCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "enroll <name> <secret>",
+		Short: "Enroll a registered user and store its key and certificate",
+		Args:  cobra.ExactArgs(2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			memberService, err := newMemberServices("")
+			if err != nil {
+				return err
+			}
+			identity, err := memberService.Enroll(args[0], args[1])
+			if err != nil {
+				return fmt.Errorf("Error enrolling %s: %s", args[0], err)
+			}
+			err = identity.Store(certPath() + string(filepath.Separator))
+			if err != nil {
+				return fmt.Errorf("Error storing %s: %s", args[0], err)
+			}
+			fmt.Printf("enrolled %s in %s\n", args[0], certPath())
+			return nil
+		},
+	}
+	memberFlags(cmd)
+	return cmd
+}
+
+func keysCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "keys",
+		Short: "List and show the enrolled identities",
+	}
+	list := &cobra.Command{
+		Use:   "list",
+		Short: "List the enrolled identities",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			certFiles, err := filepath.Glob(filepath.Join(certPath(), "*"+certSuffix))
+			if err != nil {
+				return err
+			}
+			for _, certFile := range certFiles {
+				fmt.Println(strings.TrimSuffix(filepath.Base(certFile), certSuffix))
+			}
+			return nil
+		},
+	}
+	show := &cobra.Command{
+		Use:   "show <name>",
+		Short: "Show the certificate of an enrolled identity, and its base64 form taken by the chaincodes",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			certPEM, err := ioutil.ReadFile(filepath.Join(certPath(), args[0]+certSuffix))
+			if err != nil {
+				return fmt.Errorf("Error reading the certificate of %s: %s", args[0], err)
+			}
+			block, _ := pem.Decode(certPEM)
+			if block == nil {
+				return fmt.Errorf("Invalid certificate of %s", args[0])
+			}
+			cert, err := x509.ParseCertificate(block.Bytes)
+			if err != nil {
+				return fmt.Errorf("Invalid certificate of %s: %s", args[0], err)
+			}
+			fmt.Printf("subject:   %s\n", cert.Subject.CommonName)
+			fmt.Printf("issuer:    %s\n", cert.Issuer.CommonName)
+			fmt.Printf("not after: %s\n", cert.NotAfter)
+			fmt.Printf("base64:    %s\n", base64.StdEncoding.EncodeToString(certPEM))
+			return nil
+		},
+	}
+	cmd.AddCommand(list, show)
+	cmd.PersistentFlags().String("certs", "", "the directory of the enrolled identities")
+	return cmd
+}
+
+// memberFlags adds the flags of the COP server
+func memberFlags(cmd *cobra.Command) {
+	flags := cmd.Flags()
+	flags.String("cop", "", "the URL of the COP server")
+	flags.String("certs", "", "the directory of the enrolled identities")
+}
+
+func certPath() string {
+	return viper.GetString("member.certPath")
+}
+
+// newMemberServices creates the member services of the COP server, signing
+// the requests as the enrolled identity name when not empty
+func newMemberServices(name string) (*services.MemberServices, error) {
+	c := new(services.Client)
+	c.ServerURL = viper.GetString("member.serverURL")
+	c.HomeDir = viper.GetString("member.homeDir")
+	if c.ServerURL == "" {
+		return nil, errors.New("The COP server is not configured")
+	}
+
+	var ecert *services.Signer
+	if name != "" {
+		key, err := ioutil.ReadFile(filepath.Join(certPath(), name+keySuffix))
+		if err != nil {
+			return nil, fmt.Errorf("Error reading the key of %s: %s", name, err)
+		}
+		cert, err := ioutil.ReadFile(filepath.Join(certPath(), name+certSuffix))
+		if err != nil {
+			return nil, fmt.Errorf("Error reading the certificate of %s: %s", name, err)
+		}
+		ecert = services.NewSigner(key, cert, new(services.Identity))
+	}
+	return services.NewMemberSErvice(c, ecert), nil
+}
@@ -20,40 +20,14 @@ import (
 	"errors"
 	"fmt"
 
+	"encoding/json"
+	"github.com/chaincode/common"
+	"github.com/chaincode/decimal"
 	"github.com/hyperledger/fabric/core/chaincode/shim"
 	pb "github.com/hyperledger/fabric/protos/peer"
 	"strconv"
-	"encoding/json"
-	"bytes"
-	"github.com/golang/protobuf/proto"
-	"encoding/base64"
 )
 
-type assetIssue struct {
-	Owner      string `json:"owner"`
-	Balance	   int    `json:"balance"`
-	Name       string `json:"name"`
-}
-
-type UserAsset struct {
-	Expire     int    `json:"expire"`
-	Amount	   int    `json:"amount"`
-}
-
-// This struct represents an Identity
-// (with its MSP identifier) to be used
-// to serialize it and deserialize it
-type SerializedIdentity struct {
-	// The identifier of the associated membership service provider
-	Mspid string `protobuf:"bytes,1,opt,name=Mspid" json:"Mspid,omitempty"`
-	// the Identity, serialized according to the rules of its MPS
-	IdBytes []byte `protobuf:"bytes,2,opt,name=IdBytes,proto3" json:"IdBytes,omitempty"`
-}
-
-func (m *SerializedIdentity) Reset()                    { *m = SerializedIdentity{} }
-func (m *SerializedIdentity) String() string            { return proto.CompactTextString(m) }
-func (*SerializedIdentity) ProtoMessage()               {}
-
 // BonusManagementChaincode is simple chaincode implementing a basic Asset Management system
 // with access control enforcement at chaincode level.
 // Look here for more information on how to implement access control at chaincode level:
@@ -64,54 +38,46 @@ type BonusManagementChaincode struct {
 
 // Init method will be called during deployment.
 // The deploy transaction metadata is supposed to contain the administrator cert
-//func (t *BonusManagementChaincode) Init(stub *shim.ChaincodeStub, function string, args []string) ([]byte, error) {
+// func (t *BonusManagementChaincode) Init(stub *shim.ChaincodeStub, function string, args []string) ([]byte, error) {
 func (t *BonusManagementChaincode) Init(stub shim.ChaincodeStubInterface) pb.Response {
 	fmt.Println("Init Chaincode...")
 
-	// Set the role of the users that are allowed to assign assets
-	// The metadata will contain the role of the users that are allowed to assign assets
-	adminCert, err := stub.GetCreator()
-	if err != nil {
-		return shim.Error("Failed getting createor" + err.Error())
+	// The creator of the deploy transaction is the administrator, who
+	// issues the assets
+	response := common.InitAdmin(stub)
+	if response.Status != shim.OK {
+		return response
 	}
 
-	fmt.Printf("admin's cert is %v\n", string(adminCert))
-
-	if len(adminCert) == 0 {
-		return shim.Error("Invalid assigner role. Empty.")
+	// An upgrade moves the buckets of the previous layouts to their keys
+	holders, err := migrateLegacy(stub)
+	if err != nil {
+		return shim.Error("migrate buckets failed: " + err.Error())
 	}
-
-	stub.PutState("admin", adminCert)
-
-	return shim.Success(nil)
+	fmt.Printf("Init Chaincode... %d holders migrated\n", holders)
+	return response
 }
 
 func (t *BonusManagementChaincode) issue(stub shim.ChaincodeStubInterface, args []string) pb.Response {
 	fmt.Println("Issue...")
 
-	if len(args) != 3 {
-		return shim.Error("Incorrect number of arguments. Expecting 3")
-	}
-	admin, err := stub.GetState("admin")
-	if err != nil {
-		return shim.Error("Failed to get admin's cert, error: " + err.Error())
-	}
-
-	creator, err := stub.GetCreator()
+	assetName := args[0]
+	organizationCert := args[1]
+	err := checkUser(stub, organizationCert)
 	if err != nil {
-		fmt.Println(err.Error())
-		return shim.Error("Failed to get creator's cert, error: " + err.Error())
+		return shim.Error(err.Error())
 	}
-	if bytes.Compare(admin, creator) != 0 {
-		fmt.Printf("admin cert:%x", admin)
-		fmt.Printf("creator cert:%x", creator)
-		return shim.Error("Failed, the cert of creator and caller is not same")
+	scale := 0
+	if len(args) == 4 {
+		var err error
+		scale, err = strconv.Atoi(args[3])
+		if err != nil || scale < 0 || scale > decimal.MaxScale {
+			return shim.Error(fmt.Sprintf("scale must be an integer between 0 and %d", decimal.MaxScale))
+		}
 	}
-	assetName := args[0]
-	organizationCert := args[1]
-	balance, err := strconv.Atoi(args[2])
+	balance, err := common.ParseAmount(args[2], scale)
 	if err != nil {
-		return shim.Error("Expecting integer value for asset holding")
+		return shim.Error("balance argument is incorrect: " + err.Error())
 	}
 	oldAsset, err := stub.GetState(assetName)
 	if err != nil {
@@ -121,7 +87,7 @@ func (t *BonusManagementChaincode) issue(stub shim.ChaincodeStubInterface, args
 		return shim.Error("asset already issue, please try other name")
 	}
 
-	asset := &assetIssue{organizationCert, balance, assetName}
+	asset := &common.AssetIssue{Owner: organizationCert, Balance: balance, Name: assetName, Scale: scale}
 	assetJSONasBytes, err := json.Marshal(asset)
 	if err != nil {
 		return shim.Error(err.Error())
@@ -131,6 +97,14 @@ func (t *BonusManagementChaincode) issue(stub shim.ChaincodeStubInterface, args
 	if err != nil {
 		return shim.Error(err.Error())
 	}
+	record, err := recordTransfer(stub, 0, "issue", assetName, "", organizationCert, []common.UserAsset{common.UserAsset{Expire: 0, Amount: balance}})
+	if err != nil {
+		return shim.Error("record issue failed: " + err.Error())
+	}
+	err = common.SetEvent(stub, EventBonusIssued, record)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
 
 	fmt.Println("Issue...done!")
 
@@ -138,283 +112,187 @@ func (t *BonusManagementChaincode) issue(stub shim.ChaincodeStubInterface, args
 }
 
 func (t *BonusManagementChaincode) assign(stub shim.ChaincodeStubInterface, args []string) pb.Response {
-	fmt.Println("Assign... arg length: %d", len(args))
+	fmt.Printf("Assign... arg length: %d\n", len(args))
 
-	if len(args) != 4 {
-		return shim.Error("Incorrect number of arguments. Expecting 4")
-	}
 	assetName := args[0]
-	assetJSONasBytes, err := stub.GetState(assetName)
+	assetJSON, err := common.GetIssuedAsset(stub, assetName)
 	if err != nil {
-		return shim.Error("asset state get failed, have not issued")
-	}
-	if assetJSONasBytes == nil {
-		return shim.Error("asset have not issued")
-	}
-
-	var assetJSON assetIssue
-	err = json.Unmarshal(assetJSONasBytes, &assetJSON)
-	fmt.Println("asset json string:", assetJSONasBytes)
-	if err != nil {
-		return shim.Error("Error Failed to decode JSON of: " + assetName + " resason " + err.Error() + " json:" + string(assetJSONasBytes))
+		return shim.Error(err.Error())
 	}
-	organizationCert := []byte(assetJSON.Owner)
-	serializedID, err := stub.GetCreator()
+	creator, err := common.GetCaller(stub)
 	if err != nil {
-		return shim.Error("can not get creator, error:" + err.Error())
+		return shim.Error(err.Error())
 	}
-
-	sid := &SerializedIdentity{}
-	err = proto.Unmarshal(serializedID, sid)
-	creator := sid.IdBytes
-
-	fmt.Println("admin cert length, %d", len(organizationCert))
-	fmt.Println("creator cert length, %d", len(creator))
-
-	fmt.Println("admin cert:%x", organizationCert)
-	fmt.Println("creator cert:x", creator)
-
-	if bytes.Compare(organizationCert, creator) != 0 {
+	if assetJSON.Owner != creator {
 		return shim.Error("the caller is not the asset's owner")
 	}
 	user := args[1]
-	amount, err := strconv.Atoi(args[2])
+	err = checkUser(stub, user)
 	if err != nil {
-		return shim.Error("amount argument is incorrect")
+		return shim.Error(err.Error())
 	}
-	if amount < 0  {
-		return shim.Error("the amount must not negative")
+	amount, err := common.ParseAmount(args[2], assetJSON.Scale)
+	if err != nil {
+		return shim.Error("amount argument is incorrect: " + err.Error())
 	}
-	if  amount > assetJSON.Balance {
+	if amount.Cmp(assetJSON.Balance) > 0 {
 		return shim.Error("the issue balance is small than assign amount")
 	}
 	expire, err := strconv.Atoi(args[3])
 	if err != nil {
 		return shim.Error("expire argument is incorrect")
 	}
+	err = checkExpire(expire)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
 
-	assetJSON.Balance -= amount
-	key := assetName + user
-	var userAssets []UserAsset
-	userAssetString, err := stub.GetState(key)
-	if err != nil || userAssetString == nil {
-		userAssets = []UserAsset{UserAsset{expire, amount}}
-	} else {
-		fmt.Println("set key: %s , detail: %s", base64.URLEncoding.EncodeToString([]byte(user)), string(userAssetString))
-		err = json.Unmarshal(userAssetString, &userAssets)
-		if err != nil {
-			return shim.Error("unmarshal user balance failed " + err.Error())
-		}
-		var find = false
-		var index = 0
-		for i, userAsset := range userAssets {
-			if userAsset.Expire == expire {
-				userAssets[i].Amount += amount
-				find = true
-				break
-			}
-			if userAsset.Expire < expire {
-				index = i + 1
-			}
-		}
-		if !find {
-			asset := UserAsset{expire, amount}
-			userAssets = append(userAssets[:index], append([]UserAsset{asset}, userAssets[index:]...)...)
-		}
+	assetJSON.Balance, err = assetJSON.Balance.Sub(amount)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	err = addUserAssets(stub, assetName, user, []common.UserAsset{common.UserAsset{Expire: expire, Amount: amount}})
+	if err != nil {
+		return shim.Error(err.Error())
 	}
-	userAssetResult, err := json.Marshal(userAssets)
+	err = common.PutIssuedAsset(stub, assetJSON)
 	if err != nil {
-		return shim.Error("marshal user's asset failed")
+		return shim.Error("store issue balance failed")
 	}
-
-	userBalance := 0
-	for _, userAsset := range userAssets {
-		userBalance += userAsset.Amount
+	record, err := recordTransfer(stub, 0, "assign", assetName, assetJSON.Owner, user, []common.UserAsset{common.UserAsset{Expire: expire, Amount: amount}})
+	if err != nil {
+		return shim.Error("record assign failed: " + err.Error())
 	}
-
-	fmt.Println("set key: %s , detail: %s", base64.URLEncoding.EncodeToString([]byte(user)), string(userAssetResult))
-	fmt.Println("set key: %s , detail: %d", base64.URLEncoding.EncodeToString([]byte(user)), userBalance)
-	err = stub.PutState(key, userAssetResult)
+	err = common.SetEvent(stub, EventBonusAssigned, record)
 	if err != nil {
-		return shim.Error("store user's asset failed")
+		return shim.Error(err.Error())
 	}
 
 	return shim.Success(nil)
 }
 
-func calculateTransferArray(userAssets []UserAsset, expire, amount int) ([]UserAsset, []UserAsset, error) {
+func calculateTransferArray(userAssets []common.UserAsset, expire int, amount decimal.Amount) ([]common.UserAsset, []common.UserAsset, error) {
 	var startIndex = 0
 	var index = 0
 	var remainAmount = amount
-	var lastAmount = 0
+	var lastAmount decimal.Amount
+	var err error
 	for i, sourceAsset := range userAssets {
-		fmt.Printf("expire: %d, balance: %d, target expire: %d\n", sourceAsset.Expire, sourceAsset.Amount, expire)
+		fmt.Printf("expire: %d, balance: %s, target expire: %d\n", sourceAsset.Expire, sourceAsset.Amount, expire)
 		if sourceAsset.Expire < expire {
 			startIndex = i + 1
 			index = startIndex
 		} else if sourceAsset.Expire >= expire {
-			if sourceAsset.Amount <= remainAmount {
-				remainAmount -= sourceAsset.Amount
+			if sourceAsset.Amount.Cmp(remainAmount) <= 0 {
+				remainAmount, err = remainAmount.Sub(sourceAsset.Amount)
+				if err != nil {
+					return nil, nil, err
+				}
 				index = i + 1
 			} else {
-				fmt.Printf("remain amount: %d\n", remainAmount)
+				fmt.Printf("remain amount: %s\n", remainAmount)
 				lastAmount = remainAmount
-				remainAmount = 0
+				remainAmount = decimal.Zero(remainAmount.Scale())
 				break
 			}
 		}
 	}
-	if remainAmount > 0 {
+	if remainAmount.Sign() > 0 {
 		return nil, nil, errors.New("balance is less then transfer amount")
 	}
-	var startArray = make([]UserAsset, 0)
+	var startArray = make([]common.UserAsset, 0)
 	if startIndex > 0 {
 		startArray = userAssets[:startIndex]
 	}
 	transferArray := userAssets[startIndex:index]
 	fmt.Printf("start index: %d\n", startIndex)
 	remainArray := userAssets[index:]
-	fmt.Printf("last amount: %d\n", lastAmount)
-	dest := make([]UserAsset, len(transferArray));
-	copy(dest, transferArray);
-	if lastAmount > 0 {
-		remainArray[0].Amount -= lastAmount
-		asset := UserAsset{remainArray[0].Expire, lastAmount}
+	fmt.Printf("last amount: %s\n", lastAmount)
+	dest := make([]common.UserAsset, len(transferArray))
+	copy(dest, transferArray)
+	if lastAmount.Sign() > 0 {
+		remainArray[0].Amount, err = remainArray[0].Amount.Sub(lastAmount)
+		if err != nil {
+			return nil, nil, err
+		}
+		asset := common.UserAsset{Expire: remainArray[0].Expire, Amount: lastAmount}
 		dest = append(dest, asset)
 	}
 	for _, asset := range dest {
-		fmt.Printf("11transfer array asset: %d, %d\n", asset.Expire, asset.Amount)
+		fmt.Printf("11transfer array asset: %d, %s\n", asset.Expire, asset.Amount)
 	}
 	remainArray = append(startArray, remainArray...)
 	for _, asset := range dest {
-		fmt.Printf("22transfer array asset: %d, %d\n", asset.Expire, asset.Amount)
+		fmt.Printf("22transfer array asset: %d, %s\n", asset.Expire, asset.Amount)
 	}
 
 	return remainArray, dest, nil
 }
 
-func calculateInsert(userAssets []UserAsset, inserts []UserAsset) ([]UserAsset, error) {
-	dest := make([]UserAsset, len(userAssets));
-	copy(dest, userAssets);
-	var index = 0
-	for _, insert := range inserts {
-		fmt.Println("1111")
-		inserted := false
-		for j, targetAsset := range dest[index:] {
-			fmt.Println("1111")
-			if insert.Expire == targetAsset.Expire {
-				fmt.Printf("target asset balance: %d,  amount: %d, index: %d, j: %d, last amout: %d\n",
-					targetAsset.Amount, insert.Amount, index, j, dest[index + j].Amount)
-				dest[index + j].Amount += insert.Amount
-				index = j + 1
-				inserted = true
-				break
-			} else if insert.Expire < targetAsset.Expire {
-				asset := UserAsset{insert.Expire, insert.Amount}
-				startIndex := index + j
-				fmt.Printf("start index: %d\n", startIndex)
-				dest = append(dest[:startIndex], append([]UserAsset{asset}, dest[startIndex:]...)...)
-				index = startIndex + 1
-				inserted = true
-				break
-			}
-		}
-		if !inserted {
-			asset := UserAsset{insert.Expire, insert.Amount}
-			dest = append(dest, asset)
-			index = len(dest)
-		}
-	}
-
-	return dest, nil
-}
-
 func (t *BonusManagementChaincode) transfer(stub shim.ChaincodeStubInterface, args []string) pb.Response {
 	fmt.Println("Transfer...")
 
-	if len(args) != 4 {
-		return shim.Error("Incorrect number of arguments. Expecting 4")
-	}
-
-	serializedID, err := stub.GetCreator()
-	sid := &SerializedIdentity{}
-	err = proto.Unmarshal(serializedID, sid)
-	owner := sid.IdBytes
-
-
+	owner, err := common.GetCaller(stub)
 	if err != nil {
 		return shim.Error("Failed decodinf owner")
 	}
 	assetName := args[0]
-	ownerKey := assetName + string(owner)
 
 	targetUser := args[1]
+	// the buckets of the caller are credited then rewritten, a transfer to
+	// the caller would lose the points
+	if targetUser == owner {
+		return shim.Error("can not transfer to the caller")
+	}
+	err = checkUser(stub, targetUser)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
 
-	amount, err := strconv.Atoi(args[2])
+	assetJSON, err := common.GetIssuedAsset(stub, assetName)
 	if err != nil {
-		return shim.Error("amount argument is incorrect")
+		return shim.Error(err.Error())
 	}
-	if amount < 0  {
-		return shim.Error("the amount must not negative")
+	amount, err := common.ParseAmount(args[2], assetJSON.Scale)
+	if err != nil {
+		return shim.Error("amount argument is incorrect: " + err.Error())
 	}
 	lastExpire, err := strconv.Atoi(args[3])
 	if err != nil {
 		return shim.Error("last expire argument is incorrect")
 	}
-	if lastExpire < 0  {
+	if lastExpire < 0 {
 		return shim.Error("the last expire must not negative")
 	}
 
-	fmt.Println("get asset for key: %s", ownerKey)
 	// Verify ownership
-	userAssetString, err := stub.GetState(ownerKey)
-	if err != nil || userAssetString == nil {
-		return shim.Error("the user did not have the asset:" + assetName)
-	}
-
-	var userAssets []UserAsset
-	err = json.Unmarshal(userAssetString, &userAssets)
+	userAssets, err := getUserAssets(stub, assetName, owner)
 	if err != nil {
-		return shim.Error("Failed decod inf owner")
+		return shim.Error(err.Error())
+	}
+	if len(userAssets) == 0 {
+		return shim.Error("the user did not have the asset:" + assetName)
 	}
 	remainArray, transferArray, err := calculateTransferArray(userAssets, lastExpire, amount)
 	if err != nil {
 		return shim.Error("calculate transfer error:" + err.Error())
 	}
-	targetKey := assetName + targetUser
-	var targetUserAsset []UserAsset
-	targetUserAssetString, err := stub.GetState(targetKey)
-	if err != nil || targetUserAssetString ==  nil {
-		targetUserAsset = transferArray
-	} else {
-		var targetUserAssets []UserAsset
-		err := json.Unmarshal(targetUserAssetString, &targetUserAssets)
-		if err != nil {
-			return shim.Error("Failed decodinf owner")
-		}
-		targetUserAsset, err = calculateInsert(targetUserAssets, transferArray)
-	}
-
+	err = addUserAssets(stub, assetName, targetUser, transferArray)
 	if err != nil {
-		return shim.Error("store user's asset failed")
+		return shim.Error("store target user's asset failed: " + err.Error())
 	}
 
-	targetUserAssetResult, err := json.Marshal(targetUserAsset)
+	err = putUserAssets(stub, assetName, owner, remainArray)
 	if err != nil {
-		return shim.Error("marshal target user's asset failed")
+		return shim.Error("store user's asset failed: " + err.Error())
 	}
-	err = stub.PutState(targetKey, targetUserAssetResult)
+	record, err := recordTransfer(stub, 0, "transfer", assetName, owner, targetUser, transferArray)
 	if err != nil {
-		return shim.Error("store target user's asset failed")
+		return shim.Error("record transfer failed: " + err.Error())
 	}
-
-	userAssetResult, err := json.Marshal(remainArray)
+	err = common.SetEvent(stub, EventBonusTransferred, record)
 	if err != nil {
-		return shim.Error("marshal user's asset failed")
-	}
-	err = stub.PutState(ownerKey, userAssetResult)
-	if err != nil {
-		return shim.Error("store target user's asset failed")
+		return shim.Error(err.Error())
 	}
 
 	fmt.Println("Transfer...done")
@@ -425,143 +303,178 @@ func (t *BonusManagementChaincode) transfer(stub shim.ChaincodeStubInterface, ar
 func (t *BonusManagementChaincode) transferWithDetail(stub shim.ChaincodeStubInterface, args []string) pb.Response {
 	fmt.Println("Transfer...")
 
-	if len(args) != 3 {
-		return shim.Error("Incorrect number of arguments. Expecting 4")
-	}
-
-	serializedID, err := stub.GetCreator()
-	sid := &SerializedIdentity{}
-	err = proto.Unmarshal(serializedID, sid)
-	owner := sid.IdBytes
+	owner, err := common.GetCaller(stub)
 	if err != nil {
 		return shim.Error("Failed decodinf owner")
 	}
 	assetName := args[0]
-	ownerKey := assetName + string(owner)
 
 	targetUser := args[1]
+	// the buckets of the caller are credited then rewritten, a transfer to
+	// the caller would lose the points
+	if targetUser == owner {
+		return shim.Error("can not transfer to the caller")
+	}
+	err = checkUser(stub, targetUser)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
 
-	var details []UserAsset
-	fmt.Println("receive josn: %s", args[2])
+	var details []common.UserAsset
+	fmt.Printf("receive josn: %s\n", args[2])
 	err = json.Unmarshal([]byte(args[2]), &details)
 	if err != nil {
 		return shim.Error("Failed decod transfer detail")
 	}
-
-	fmt.Println("get asset for key: %s", ownerKey)
-	// Verify ownership
-	userAssetString, err := stub.GetState(ownerKey)
-	if err != nil || userAssetString == nil {
-		return shim.Error("the user did not have the asset:" + assetName)
-	}
-	var userAssets []UserAsset
-	err = json.Unmarshal(userAssetString, &userAssets)
+	assetJSON, err := common.GetIssuedAsset(stub, assetName)
 	if err != nil {
-		return shim.Error("Failed decod inf owner")
+		return shim.Error(err.Error())
 	}
-
-	targetKey := assetName + targetUser
-	var targetUserAssets []UserAsset
-	targetUserAssetString, err := stub.GetState(targetKey)
-	if err == nil && targetUserAssetString !=  nil {
-		err = json.Unmarshal(targetUserAssetString, &targetUserAssets)
+	for i := range details {
+		if details[i].Amount.Sign() < 0 {
+			return shim.Error("the amount must not negative")
+		}
+		err = checkExpire(details[i].Expire)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		details[i].Amount, err = details[i].Amount.Rescale(assetJSON.Scale)
 		if err != nil {
-			return shim.Error("Failed decod target's asset detail:" + err.Error())
+			return shim.Error("transfer detail amount is incorrect: " + err.Error())
 		}
 	}
 
+	// Verify ownership
+	userAssets, err := getUserAssets(stub, assetName, owner)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if len(userAssets) == 0 {
+		return shim.Error("the user did not have the asset:" + assetName)
+	}
+
 	remainArray := userAssets
-	var transferArray []UserAsset
+	var transferArray []common.UserAsset
+	var transferred []common.UserAsset
 	for _, detail := range details {
-		fmt.Println("1111")
 		remainArray, transferArray, err = calculateTransferArray(remainArray, detail.Expire, detail.Amount)
 		if err != nil {
 			return shim.Error("calculate transfer array failed: " + err.Error())
 		}
-		if targetUserAssets ==  nil {
-			targetUserAssets = transferArray
-		} else {
-			targetUserAssets, err = calculateInsert(targetUserAssets, transferArray)
-		}
+		transferred = append(transferred, transferArray...)
 	}
-	targetUserAssetJson, err := json.Marshal(targetUserAssets)
+	err = addUserAssets(stub, assetName, targetUser, transferred)
 	if err != nil {
-		return shim.Error("marshal target user's asset failed")
+		return shim.Error("store target user's asset failed: " + err.Error())
 	}
-	err = stub.PutState(targetKey, targetUserAssetJson)
+
+	err = putUserAssets(stub, assetName, owner, remainArray)
 	if err != nil {
-		return shim.Error("store target user's asset failed")
+		return shim.Error("store user's asset failed: " + err.Error())
 	}
-
-	userAssetResult, err := json.Marshal(remainArray)
+	record, err := recordTransfer(stub, 0, "transfer", assetName, owner, targetUser, transferred)
 	if err != nil {
-		return shim.Error("marshal user's asset failed")
+		return shim.Error("record transfer failed: " + err.Error())
 	}
-	err = stub.PutState(ownerKey, userAssetResult)
+	err = common.SetEvent(stub, EventBonusTransferred, record)
 	if err != nil {
-		return shim.Error("store target user's asset failed")
+		return shim.Error(err.Error())
 	}
 
 	fmt.Println("Transfer...done")
 
 	return shim.Success(nil)
 }
-// Invoke will be called for every transaction.
-// Supported functions are the following:
-// "assign(asset, owner)": to assign ownership of assets. An asset can be owned by a single entity.
-// Only an administrator can call this function.
-// "transfer(asset, newOwner)": to transfer the ownership of an asset. Only the owner of the specific
-// asset can call this function.
-// An asset is any string to identify it. An owner is representated by one of his ECert/TCert.
+
+// router declares the functions of the chaincode and the roles allowed to call them:
+// "issue": to issue an asset, by an admin or an issuer.
+// "assign": to assign points to users, by the issuer owning the asset.
+// "transfer", "transferWithDetail": to transfer points of the caller to another user.
+// "query", "queryOrg": to query the balance of a user or the issue of an asset.
+// "history": pages through the transfer records of an asset, or of one of its users.
+// "expire": forfeits the expired points of all the holders of an asset, by an admin.
+// "setSink": sets the account receiving the expired points, by the issuer owning the asset.
+// "registerMerchant", "settle": register a merchant and mark its redemptions as paid,
+// by the issuer owning the asset.
+// "queryMerchant": returns the payable of a merchant, to merchants, issuers and auditors.
+// "redeem": burns points of the caller at a merchant.
+// "setRate": sets the exchange rate between assets, by the issuer owning the target asset.
+// "swap": exchanges points of the caller at the configured rate.
+// "migrate": moves the buckets of the holders of an asset to the per bucket layout, by an admin.
+// "grantRole", "revokeRole", "queryRoles": manage the roles, by an admin.
+// "proposeAdmin", "approveAdmin", "queryAdmins", "queryProposal": change the admin set
+// once enough admins approved.
+func (t *BonusManagementChaincode) router() *common.Router {
+	r := common.NewRouter()
+	r.Handle("issue", t.issue, common.StringArg("assetName"), common.StringArg("owner"),
+		common.StringArg("balance"), common.Optional(common.IntArg("scale"))).
+		Require(common.RoleAdmin, common.RoleIssuer)
+	r.Handle("assign", t.assign, common.StringArg("assetName"), common.StringArg("user"),
+		common.StringArg("amount"), common.IntArg("expire")).
+		Require(common.RoleIssuer)
+	r.Handle("transfer", t.transfer, common.StringArg("assetName"), common.StringArg("targetUser"),
+		common.StringArg("amount"), common.IntArg("lastExpire"))
+	r.Handle("transferWithDetail", t.transferWithDetail, common.StringArg("assetName"),
+		common.StringArg("targetUser"), common.JSONArg("details"))
+	r.Handle("query", t.queryUserBalance, common.StringArg("user"), common.StringArg("assetName"))
+	r.Handle("queryOrg", t.queryOrganizationBalance, common.StringArg("assetName"))
+	r.Handle("history", t.history, common.StringArg("assetName"), common.StringArg("user"),
+		common.Optional(common.StringArg("pageSize")), common.Optional(common.StringArg("bookmark")))
+	r.Handle("expire", t.expire, common.StringArg("assetName")).
+		Require(common.RoleAdmin)
+	r.Handle("setSink", t.setSink, common.StringArg("assetName"), common.StringArg("sink")).
+		Require(common.RoleIssuer)
+	r.Handle("registerMerchant", t.registerMerchant, common.StringArg("assetName"), common.StringArg("merchant")).
+		Require(common.RoleIssuer)
+	r.Handle("redeem", t.redeem, common.StringArg("assetName"), common.StringArg("merchant"),
+		common.StringArg("amount"), common.IntArg("lastExpire"))
+	r.Handle("settle", t.settle, common.StringArg("assetName"), common.StringArg("merchant"),
+		common.Variadic(common.StringArg("receipt"))).
+		Require(common.RoleIssuer)
+	r.Handle("queryMerchant", t.queryMerchant, common.StringArg("assetName"), common.StringArg("merchant")).
+		Require(common.RoleMerchant, common.RoleIssuer, common.RoleAuditor)
+	r.Handle("setRate", t.setRate, common.StringArg("from"), common.StringArg("to"),
+		common.IntArg("numerator"), common.IntArg("denominator"), common.StringArg("rounding"),
+		common.StringArg("dailyLimit")).
+		Require(common.RoleIssuer)
+	r.Handle("queryRate", t.queryRate, common.StringArg("from"), common.StringArg("to"))
+	r.Handle("swap", t.swap, common.StringArg("from"), common.StringArg("to"),
+		common.StringArg("amount"), common.IntArg("lastExpire"))
+	r.Handle("migrate", t.migrate, common.StringArg("assetName"), common.Variadic(common.StringArg("user"))).
+		Require(common.RoleAdmin)
+	common.HandleRoles(r)
+	common.HandleAdmins(r)
+	return r
+}
+
+// Invoke will be called for every transaction, and dispatches it to the
+// function declared by router.
 func (t *BonusManagementChaincode) Invoke(stub shim.ChaincodeStubInterface) pb.Response {
-	function, args := stub.GetFunctionAndParameters()
-
-	// Handle different functions
-	if function == "issue" {
-		// Assign ownership
-		return t.issue(stub, args)
-	} else if function == "assign" {
-		// Assign ownership
-		return t.assign(stub, args)
-	} else if function == "transfer" {
-		// Transfer ownership
-		return t.transfer(stub, args)
-	} else if function == "transferWithDetail" {
-		// Transfer ownership
-		return t.transferWithDetail(stub, args)
-	}else if function == "query" {
-		// Query owner
-		return t.query(stub, "user", args)
-	} else if function == "queryOrg" {
-		// Query owner
-		return t.query(stub, "organization", args)
-	}
-	//return nil, nil
-	return shim.Error("Received unknown function invocation:" + function)
+	return t.router().Invoke(stub)
 }
 
+// queryUserBalance returns the expiry buckets of a user
+// args: user, assetName
 func (t *BonusManagementChaincode) queryUserBalance(stub shim.ChaincodeStubInterface, args []string) pb.Response {
-	var err error
-
-	if len(args) != 2 {
-		fmt.Println("Incorrect number of arguments. Expecting name of an asset to query")
-		return shim.Error("Incorrect number of arguments. Expecting name of an asset to query")
-	}
-
-	// Who is the owner of the asset?
-
 	owner := args[0]
-	if err != nil {
-		return shim.Error("Failed decoding owner")
-	}
-
 	assetName := args[1]
 	fmt.Printf("Arg [%s]\n", assetName)
-	ownerKey := assetName + owner
-	userAssetString, err := stub.GetState(ownerKey)
+	userAssets, err := getUserAssets(stub, assetName, owner)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if userAssets == nil {
+		return shim.Success(nil)
+	}
+	userAssetString, err := json.Marshal(userAssets)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
 	return shim.Success(userAssetString)
 }
 
+// queryOrganizationBalance returns the issue of an asset
+// args: assetName
 func (t *BonusManagementChaincode) queryOrganizationBalance(stub shim.ChaincodeStubInterface, args []string) pb.Response {
 	assetName := args[0]
 	userAssetString, err := stub.GetState(assetName)
@@ -572,27 +485,9 @@ func (t *BonusManagementChaincode) queryOrganizationBalance(stub shim.ChaincodeS
 	return shim.Success(userAssetString)
 }
 
-
-// Query callback representing the query of a chaincode
-// Supported functions are the following:
-// "query(asset)": returns the owner of the asset.
-// Anyone can invoke this function.
-func (t *BonusManagementChaincode) query(stub shim.ChaincodeStubInterface, function string, args []string) pb.Response {
-	fmt.Printf("Query [%s]", function)
-
-	if function == "user" {
-		return t.queryUserBalance(stub, args)
-	} else if function == "organization" {
-		return t.queryOrganizationBalance(stub, args)
-	} else {
-		return shim.Error("Invalid query function name. Expecting \"query\"")
-
-	}
-}
-
 func main() {
 	err := shim.Start(new(BonusManagementChaincode))
 	if err != nil {
 		fmt.Printf("Error starting Simple chaincode: %s", err)
 	}
-}
\ No newline at end of file
+}
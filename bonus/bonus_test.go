@@ -0,0 +1,123 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/chaincode/common"
+	"github.com/chaincode/mockstub"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+// newBonusStub deploys the chaincode with "admin" as the admin, grants the
+// issuer role to OrgMSP and lets "org" of OrgMSP issue 1000 pts on
+// 2017-11-15. It returns with "org" as the creator.
+func newBonusStub(t *testing.T) *mockstub.MockStub {
+	t.Helper()
+	stub := mockstub.NewMockStub("bonus", new(BonusManagementChaincode))
+	stub.SetCreator("AdminMSP", []byte("admin"))
+	stub.SetTxTimestamp(time.Date(2017, 11, 15, 0, 0, 0, 0, time.UTC))
+	if response := stub.MockInit("init", []string{"init"}); response.Status != shim.OK {
+		t.Fatal(response.Message)
+	}
+	invoke(t, stub, "grant", "grantRole", "issuer", "OrgMSP", "", "", "")
+	stub.SetCreator("OrgMSP", []byte("org"))
+	invoke(t, stub, "issue", "issue", "pts", "org", "1000")
+	return stub
+}
+
+func invoke(t *testing.T, stub *mockstub.MockStub, txID string, args ...string) []byte {
+	t.Helper()
+	response := stub.MockInvoke(txID, args)
+	if response.Status != shim.OK {
+		t.Fatalf("%s %v: %s", txID, args, response.Message)
+	}
+	return response.Payload
+}
+
+// invokeFails invokes a transaction which must fail, and returns its message
+func invokeFails(t *testing.T, stub *mockstub.MockStub, txID string, args ...string) string {
+	t.Helper()
+	response := stub.MockInvoke(txID, args)
+	if response.Status == shim.OK {
+		t.Fatalf("%s %v succeeded", txID, args)
+	}
+	return response.Message
+}
+
+func assertBalance(t *testing.T, stub *mockstub.MockStub, user, expected string) {
+	t.Helper()
+	if balance := invoke(t, stub, "query", "query", user, "pts"); string(balance) != expected {
+		t.Fatalf("balance of %s = %s, expected %s", user, balance, expected)
+	}
+}
+
+func TestTransferSpendsTheEarliestBuckets(t *testing.T) {
+	stub := newBonusStub(t)
+	invoke(t, stub, "tx1", "assign", "pts", "alice", "100", "20171201")
+	invoke(t, stub, "tx2", "assign", "pts", "alice", "50", "20180101")
+	if balance := invoke(t, stub, "tx3", "queryOrg", "pts"); len(balance) == 0 {
+		t.Fatal("no issue")
+	}
+	invokeFails(t, stub, "tx4", "assign", "pts", "alice", "851", "20180101")
+
+	stub.SetCreator("Org1MSP", []byte("alice"))
+	invokeFails(t, stub, "tx5", "assign", "pts", "alice", "1", "20180101")
+	invokeFails(t, stub, "tx6", "transfer", "pts", "bob", "151", "0")
+	invoke(t, stub, "tx7", "transfer", "pts", "bob", "120", "0")
+	var record TransferRecord
+	event, err := common.DecodeEvent(stub.LastEvent().Payload, &record)
+	if err != nil || event.Type != EventBonusTransferred || event.TxID != "tx7" || record.To != "bob" || record.Amount.String() != "120" {
+		t.Fatalf("event %+v %+v, %v", event, record, err)
+	}
+	assertBalance(t, stub, "alice", `[{"expire":20180101,"amount":"30"}]`)
+	assertBalance(t, stub, "bob", `[{"expire":20171201,"amount":"100"},{"expire":20180101,"amount":"20"}]`)
+
+	invoke(t, stub, "tx8", "transferWithDetail", "pts", "bob", `[{"expire":20180101,"amount":"10"}]`)
+	assertBalance(t, stub, "alice", `[{"expire":20180101,"amount":"20"}]`)
+	assertBalance(t, stub, "bob", `[{"expire":20171201,"amount":"100"},{"expire":20180101,"amount":"30"}]`)
+	invokeFails(t, stub, "tx9", "transferWithDetail", "pts", "bob", `[{"expire":0,"amount":"21"}]`)
+}
+
+func TestTransferWithDetailRescalesTheAmounts(t *testing.T) {
+	stub := newBonusStub(t)
+	invoke(t, stub, "tx1", "issue", "miles", "org", "1000", "2")
+	invoke(t, stub, "tx2", "assign", "miles", "alice", "10", "20180101")
+
+	stub.SetCreator("Org1MSP", []byte("alice"))
+	invokeFails(t, stub, "tx3", "transferWithDetail", "miles", "bob", `[{"expire":0,"amount":"1.505"}]`)
+	invoke(t, stub, "tx4", "transferWithDetail", "miles", "bob", `[{"expire":0,"amount":"1.5"}]`)
+	if balance := invoke(t, stub, "tx5", "query", "bob", "miles"); string(balance) != `[{"expire":20180101,"amount":"1.50"}]` {
+		t.Fatalf("balance of bob %s", balance)
+	}
+	if balance := invoke(t, stub, "tx6", "query", "alice", "miles"); string(balance) != `[{"expire":20180101,"amount":"8.50"}]` {
+		t.Fatalf("balance of alice %s", balance)
+	}
+}
+
+func TestTransferToTheCallerFails(t *testing.T) {
+	stub := newBonusStub(t)
+	invoke(t, stub, "tx1", "assign", "pts", "alice", "100", "20171201")
+
+	stub.SetCreator("Org1MSP", []byte("alice"))
+	if message := invokeFails(t, stub, "tx2", "transfer", "pts", "alice", "40", "0"); message != "can not transfer to the caller" {
+		t.Fatal(message)
+	}
+	invokeFails(t, stub, "tx3", "transferWithDetail", "pts", "alice", `[{"expire":0,"amount":"40"}]`)
+	assertBalance(t, stub, "alice", `[{"expire":20171201,"amount":"100"}]`)
+}
+
+func TestExpireOutOfTheBucketKeysFails(t *testing.T) {
+	stub := newBonusStub(t)
+	for _, expire := range []string{"-1", "100000000"} {
+		if message := invokeFails(t, stub, "tx1", "assign", "pts", "alice", "10", expire); message != "the expire must be between 0 and 99999999" {
+			t.Fatal(message)
+		}
+	}
+	invoke(t, stub, "tx2", "assign", "pts", "alice", "10", "99999999")
+	invoke(t, stub, "tx3", "assign", "pts", "alice", "10", "20171201")
+	assertBalance(t, stub, "alice", `[{"expire":20171201,"amount":"10"},{"expire":99999999,"amount":"10"}]`)
+
+	stub.SetCreator("Org1MSP", []byte("alice"))
+	invokeFails(t, stub, "tx4", "transferWithDetail", "pts", "bob", `[{"expire":100000000,"amount":"1"}]`)
+}
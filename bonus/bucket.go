@@ -0,0 +1,327 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/chaincode/common"
+	"github.com/chaincode/decimal"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+const (
+	// bucketIndex stores each expiry bucket of a user under its own key:
+	// asset~holder~expire, the expire padded so the buckets of a user are
+	// read in the order of their expire
+	bucketIndex = "asset~holder~expire"
+	// holderIndex listed the users holding an asset in the previous layout,
+	// where the buckets of a user were a list: asset~holder
+	holderIndex = "asset~holder"
+	// maxExpire is the largest expire the 8 digits of the bucket keys keep in
+	// order, any date as yyyymmdd included
+	maxExpire = 99999999
+)
+
+// MigrateSummary is the payload of the event emitted by migrate
+type MigrateSummary struct {
+	Asset   string   `json:"asset"`
+	Holders []string `json:"holders"`
+	Buckets int      `json:"buckets"`
+}
+
+// bucketKey returns the key of the bucket of a user expiring at expire
+func bucketKey(stub shim.ChaincodeStubInterface, assetName, user string, expire int) (string, error) {
+	return stub.CreateCompositeKey(bucketIndex, []string{assetName, user, fmt.Sprintf("%08d", expire)})
+}
+
+// checkExpire fails when an expire can not be stored in a bucket key
+func checkExpire(expire int) error {
+	if expire < 0 || expire > maxExpire {
+		return fmt.Errorf("the expire must be between 0 and %d", maxExpire)
+	}
+	return nil
+}
+
+// getUserAssets reads the expiry buckets of a user, sorted by expire
+func getUserAssets(stub shim.ChaincodeStubInterface, assetName, user string) ([]common.UserAsset, error) {
+	bucketsIterator, err := stub.GetStateByPartialCompositeKey(bucketIndex, []string{assetName, user})
+	if err != nil {
+		return nil, fmt.Errorf("Failed to get user's asset: %s", err)
+	}
+	defer bucketsIterator.Close()
+
+	var userAssets []common.UserAsset
+	for bucketsIterator.HasNext() {
+		_, value, err := bucketsIterator.Next()
+		if err != nil {
+			return nil, fmt.Errorf("Failed to get user's asset: %s", err)
+		}
+		var userAsset common.UserAsset
+		err = json.Unmarshal(value, &userAsset)
+		if err != nil {
+			return nil, fmt.Errorf("unmarshal user balance failed %s", err)
+		}
+		userAssets = append(userAssets, userAsset)
+	}
+	return userAssets, nil
+}
+
+// putUserAssets stores the expiry buckets of a user, writing only the buckets
+// which changed and deleting the ones which are not in userAssets anymore
+func putUserAssets(stub shim.ChaincodeStubInterface, assetName, user string, userAssets []common.UserAsset) error {
+	oldAssets, err := getUserAssets(stub, assetName, user)
+	if err != nil {
+		return err
+	}
+	old := make(map[int]decimal.Amount, len(oldAssets))
+	for _, oldAsset := range oldAssets {
+		old[oldAsset.Expire] = oldAsset.Amount
+	}
+
+	for _, userAsset := range userAssets {
+		amount, found := old[userAsset.Expire]
+		delete(old, userAsset.Expire)
+		if found && amount.Cmp(userAsset.Amount) == 0 {
+			continue
+		}
+		err = putBucket(stub, assetName, user, userAsset)
+		if err != nil {
+			return err
+		}
+	}
+	for expire := range old {
+		key, err := bucketKey(stub, assetName, user, expire)
+		if err != nil {
+			return err
+		}
+		err = stub.DelState(key)
+		if err != nil {
+			return fmt.Errorf("delete user's asset failed: %s", err)
+		}
+	}
+	return nil
+}
+
+// addUserAssets credits buckets to a user. It only reads and writes the keys
+// of the expires credited, so that transactions crediting the same user at
+// other expires do not conflict.
+func addUserAssets(stub shim.ChaincodeStubInterface, assetName, user string, inserts []common.UserAsset) error {
+	// the writes of a transaction are not visible to its reads, so sum the
+	// inserts of the same expire first
+	var merged []common.UserAsset
+	positions := make(map[int]int, len(inserts))
+	for _, insert := range inserts {
+		position, found := positions[insert.Expire]
+		if !found {
+			positions[insert.Expire] = len(merged)
+			merged = append(merged, insert)
+			continue
+		}
+		sum, err := merged[position].Amount.Add(insert.Amount)
+		if err != nil {
+			return err
+		}
+		merged[position].Amount = sum
+	}
+
+	for _, insert := range merged {
+		key, err := bucketKey(stub, assetName, user, insert.Expire)
+		if err != nil {
+			return err
+		}
+		bucketJSONasBytes, err := stub.GetState(key)
+		if err != nil {
+			return fmt.Errorf("Failed to get user's asset: %s", err)
+		}
+		userAsset := common.UserAsset{Expire: insert.Expire, Amount: insert.Amount}
+		if bucketJSONasBytes != nil {
+			var bucket common.UserAsset
+			err = json.Unmarshal(bucketJSONasBytes, &bucket)
+			if err != nil {
+				return fmt.Errorf("unmarshal user balance failed %s", err)
+			}
+			userAsset.Amount, err = bucket.Amount.Add(insert.Amount)
+			if err != nil {
+				return err
+			}
+		}
+		err = putBucket(stub, assetName, user, userAsset)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// putBucket stores one expiry bucket of a user
+func putBucket(stub shim.ChaincodeStubInterface, assetName, user string, userAsset common.UserAsset) error {
+	key, err := bucketKey(stub, assetName, user, userAsset.Expire)
+	if err != nil {
+		return err
+	}
+	bucketJSONasBytes, err := json.Marshal(userAsset)
+	if err != nil {
+		return fmt.Errorf("marshal user's asset failed: %s", err)
+	}
+	err = stub.PutState(key, bucketJSONasBytes)
+	if err != nil {
+		return fmt.Errorf("store user's asset failed: %s", err)
+	}
+	return nil
+}
+
+// migrate moves the buckets of the holders of an asset from the list stored
+// under the asset name followed by the user, to one key per bucket, and
+// deletes the list and the holder index. The holders are the users given, or
+// the holders in the index of the previous layout when none is given.
+// Init already migrates all the holders when the chaincode is upgraded, this
+// function migrates the holders of one asset again. Only an admin can call it.
+// args: assetName, [user...]
+func (t *BonusManagementChaincode) migrate(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	fmt.Println("Migrate...")
+
+	assetName := args[0]
+	_, err := common.GetIssuedAsset(stub, assetName)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	holders := args[1:]
+	if len(holders) == 0 {
+		holdersIterator, err := stub.GetStateByPartialCompositeKey(holderIndex, []string{assetName})
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		for holdersIterator.HasNext() {
+			holderKey, _, err := holdersIterator.Next()
+			if err != nil {
+				holdersIterator.Close()
+				return shim.Error(err.Error())
+			}
+			_, keyParts, err := stub.SplitCompositeKey(holderKey)
+			if err != nil {
+				holdersIterator.Close()
+				return shim.Error(err.Error())
+			}
+			holders = append(holders, keyParts[1])
+		}
+		holdersIterator.Close()
+	}
+
+	summary := MigrateSummary{Asset: assetName, Holders: []string{}}
+	for _, holder := range holders {
+		buckets, err := migrateHolder(stub, assetName, holder)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		if buckets > 0 {
+			summary.Holders = append(summary.Holders, holder)
+			summary.Buckets += buckets
+		}
+	}
+
+	summaryJSONasBytes, err := json.Marshal(summary)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	err = common.SetEvent(stub, EventBonusMigrated, summary)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	fmt.Printf("Migrate... %d holders done\n", len(summary.Holders))
+	return shim.Success(summaryJSONasBytes)
+}
+
+// migrateHolder moves the list of buckets of a holder to one key per bucket,
+// and deletes the list and the holder index. It returns the number of
+// buckets moved.
+func migrateHolder(stub shim.ChaincodeStubInterface, assetName, holder string) (int, error) {
+	userAssetString, err := stub.GetState(assetName + holder)
+	if err != nil {
+		return 0, fmt.Errorf("Failed to get holder's asset: %s", err)
+	}
+	var userAssets []common.UserAsset
+	if userAssetString != nil {
+		err = json.Unmarshal(userAssetString, &userAssets)
+		if err != nil {
+			return 0, fmt.Errorf("unmarshal user balance failed %s", err)
+		}
+		err = addUserAssets(stub, assetName, holder, userAssets)
+		if err != nil {
+			return 0, err
+		}
+		err = stub.DelState(assetName + holder)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	holderKey, err := stub.CreateCompositeKey(holderIndex, []string{assetName, holder})
+	if err != nil {
+		return 0, err
+	}
+	err = stub.DelState(holderKey)
+	if err != nil {
+		return 0, err
+	}
+	return len(userAssets), nil
+}
+
+// migrateLegacy moves the lists of buckets of all the holders to one key per
+// bucket, when the chaincode is upgraded. The holders are found by scanning
+// the simple keys rather than the holder index, which misses the users who
+// held an asset before it existed: a list is a JSON array stored under the
+// name of an issued asset followed by the holder.
+func migrateLegacy(stub shim.ChaincodeStubInterface) (int, error) {
+	resultsIterator, err := stub.GetStateByRange("", "")
+	if err != nil {
+		return 0, err
+	}
+	var assetNames []string
+	var listKeys []string
+	for resultsIterator.HasNext() {
+		key, value, err := resultsIterator.Next()
+		if err != nil {
+			resultsIterator.Close()
+			return 0, err
+		}
+		if strings.ContainsRune(key, 0) {
+			// a composite key
+			continue
+		}
+		value = bytes.TrimSpace(value)
+		if bytes.HasPrefix(value, []byte("[")) {
+			listKeys = append(listKeys, key)
+			continue
+		}
+		var asset common.AssetIssue
+		if json.Unmarshal(value, &asset) == nil && asset.Name == key && asset.Owner != "" {
+			assetNames = append(assetNames, key)
+		}
+	}
+	resultsIterator.Close()
+
+	holders := 0
+	for _, key := range listKeys {
+		// the longest name wins when the name of an asset starts with another
+		assetName := ""
+		for _, name := range assetNames {
+			if len(name) < len(key) && strings.HasPrefix(key, name) && len(name) > len(assetName) {
+				assetName = name
+			}
+		}
+		if assetName == "" {
+			continue
+		}
+		_, err = migrateHolder(stub, assetName, key[len(assetName):])
+		if err != nil {
+			return 0, err
+		}
+		holders++
+	}
+	return holders, nil
+}
@@ -0,0 +1,29 @@
+package main
+
+// The types of the events emitted by the functions of the chaincode, each
+// with the payload it carries as the data of a common.Event.
+const (
+	// EventBonusIssued is emitted by issue, with the TransferRecord of the issue
+	EventBonusIssued = "BonusIssued"
+	// EventBonusAssigned is emitted by assign, with its TransferRecord
+	EventBonusAssigned = "BonusAssigned"
+	// EventBonusTransferred is emitted by transfer and transferWithDetail,
+	// with the TransferRecord
+	EventBonusTransferred = "BonusTransferred"
+	// EventBonusExpired is emitted by expire, with the ExpireSummary
+	EventBonusExpired = "BonusExpired"
+	// EventBonusSinkSet is emitted by setSink, with the common.AssetIssue
+	EventBonusSinkSet = "BonusSinkSet"
+	// EventMerchantRegistered is emitted by registerMerchant, with the Merchant
+	EventMerchantRegistered = "MerchantRegistered"
+	// EventBonusRedeemed is emitted by redeem, with the Receipt
+	EventBonusRedeemed = "BonusRedeemed"
+	// EventBonusSettled is emitted by settle, with the Settlement
+	EventBonusSettled = "BonusSettled"
+	// EventExchangeRateSet is emitted by setRate, with the ExchangeRate
+	EventExchangeRateSet = "ExchangeRateSet"
+	// EventBonusSwapped is emitted by swap, with the Swap
+	EventBonusSwapped = "BonusSwapped"
+	// EventBonusMigrated is emitted by migrate, with the MigrateSummary
+	EventBonusMigrated = "BonusMigrated"
+)
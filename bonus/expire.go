@@ -0,0 +1,194 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/chaincode/common"
+	"github.com/chaincode/decimal"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+const (
+	// neverExpire is the expire of the points credited to a sink account
+	neverExpire = 99991231
+)
+
+// ExpireSummary is the payload of the event emitted by an expire sweep
+type ExpireSummary struct {
+	Asset     string         `json:"asset"`
+	Date      int            `json:"date"`
+	Holders   int            `json:"holders"`
+	Forfeited decimal.Amount `json:"forfeited"`
+	Sink      string         `json:"sink,omitempty"`
+}
+
+// txDate returns the date of the transaction as yyyymmdd, the format of
+// common.UserAsset.Expire
+func txDate(stub shim.ChaincodeStubInterface) (int, error) {
+	txTimestamp, err := stub.GetTxTimestamp()
+	if err != nil {
+		return 0, fmt.Errorf("Failed getting transaction timestamp: %s", err)
+	}
+	year, month, day := time.Unix(txTimestamp.Seconds, int64(txTimestamp.Nanos)).UTC().Date()
+	return year*10000 + int(month)*100 + day, nil
+}
+
+// setSink configures the account which receives the expired points of an
+// asset instead of the issue balance. An empty sink restores the default.
+// Only the owner of the asset can call this function.
+// args: assetName, sink
+func (t *BonusManagementChaincode) setSink(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	assetJSON, err := common.GetIssuedAsset(stub, args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	caller, err := common.GetCaller(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if assetJSON.Owner != caller {
+		return shim.Error("the caller is not the asset's owner")
+	}
+
+	if args[1] != "" {
+		err = checkUser(stub, args[1])
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+	}
+	assetJSON.Sink = args[1]
+	err = common.PutIssuedAsset(stub, assetJSON)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	err = common.SetEvent(stub, EventBonusSinkSet, assetJSON)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(nil)
+}
+
+// expire removes the buckets expired at the date of the transaction from all
+// the holders of an asset, and returns the forfeited points to the issue
+// balance, or to the sink account of the asset when one is configured.
+// Only an admin can call this function.
+// args: assetName
+func (t *BonusManagementChaincode) expire(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	fmt.Println("Expire...")
+
+	assetName := args[0]
+	assetJSON, err := common.GetIssuedAsset(stub, assetName)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	today, err := txDate(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	// the buckets of an asset are sorted by holder then expire, so the
+	// expired buckets of a holder are read one after the other
+	bucketsIterator, err := stub.GetStateByPartialCompositeKey(bucketIndex, []string{assetName})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	defer bucketsIterator.Close()
+
+	receiver := assetJSON.Owner
+	if assetJSON.Sink != "" {
+		receiver = assetJSON.Sink
+	}
+	summary := ExpireSummary{Asset: assetName, Date: today, Forfeited: decimal.Zero(assetJSON.Scale), Sink: assetJSON.Sink}
+	var holder string
+	var expiredArray []common.UserAsset
+	forfeit := func() error {
+		if len(expiredArray) == 0 {
+			return nil
+		}
+		_, err := recordTransfer(stub, summary.Holders, "expire", assetName, holder, receiver, expiredArray)
+		if err != nil {
+			return fmt.Errorf("record expire failed: %s", err)
+		}
+		forfeited, err := common.SumAssets(expiredArray)
+		if err != nil {
+			return err
+		}
+		summary.Forfeited, err = summary.Forfeited.Add(forfeited)
+		if err != nil {
+			return err
+		}
+		summary.Holders++
+		expiredArray = nil
+		return nil
+	}
+	for bucketsIterator.HasNext() {
+		key, value, err := bucketsIterator.Next()
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		_, keyParts, err := stub.SplitCompositeKey(key)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		if keyParts[1] != holder {
+			if err = forfeit(); err != nil {
+				return shim.Error(err.Error())
+			}
+			holder = keyParts[1]
+		}
+		if assetJSON.Sink != "" && holder == assetJSON.Sink {
+			continue
+		}
+
+		var userAsset common.UserAsset
+		err = json.Unmarshal(value, &userAsset)
+		if err != nil {
+			return shim.Error("unmarshal user balance failed " + err.Error())
+		}
+		if userAsset.Expire >= today {
+			continue
+		}
+		err = stub.DelState(key)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		expiredArray = append(expiredArray, userAsset)
+	}
+	if err = forfeit(); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	if summary.Forfeited.Sign() > 0 {
+		if assetJSON.Sink == "" {
+			assetJSON.Balance, err = assetJSON.Balance.Add(summary.Forfeited)
+			if err != nil {
+				return shim.Error(err.Error())
+			}
+			err = common.PutIssuedAsset(stub, assetJSON)
+			if err != nil {
+				return shim.Error(err.Error())
+			}
+		} else {
+			err = addUserAssets(stub, assetName, assetJSON.Sink, []common.UserAsset{common.UserAsset{Expire: neverExpire, Amount: summary.Forfeited}})
+			if err != nil {
+				return shim.Error(err.Error())
+			}
+		}
+	}
+
+	summaryJSONasBytes, err := json.Marshal(summary)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	err = common.SetEvent(stub, EventBonusExpired, summary)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	fmt.Println("Expire...done")
+	return shim.Success(summaryJSONasBytes)
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/chaincode/common"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+func TestExpireForfeitsTheExpiredBuckets(t *testing.T) {
+	stub := newBonusStub(t)
+	invoke(t, stub, "tx1", "assign", "pts", "alice", "100", "20171101")
+	invoke(t, stub, "tx2", "assign", "pts", "alice", "50", "20171201")
+	invoke(t, stub, "tx3", "assign", "pts", "bob", "30", "20171001")
+
+	invokeFails(t, stub, "tx4", "expire", "pts")
+	stub.SetCreator("AdminMSP", []byte("admin"))
+	var summary ExpireSummary
+	if err := json.Unmarshal(invoke(t, stub, "tx5", "expire", "pts"), &summary); err != nil {
+		t.Fatal(err)
+	}
+	if summary.Date != 20171115 || summary.Holders != 2 || summary.Forfeited.String() != "130" {
+		t.Fatalf("summary %+v", summary)
+	}
+	assertBalance(t, stub, "alice", `[{"expire":20171201,"amount":"50"}]`)
+	assertBalance(t, stub, "bob", "")
+	asset, _ := common.GetIssuedAsset(stub, "pts")
+	if asset.Balance.String() != "950" {
+		t.Fatalf("issue balance %s", asset.Balance)
+	}
+
+	stub.SetCreator("OrgMSP", []byte("org"))
+	invoke(t, stub, "tx6", "setSink", "pts", "sink")
+	stub.SetTxTimestamp(time.Date(2017, 12, 2, 0, 0, 0, 0, time.UTC))
+	stub.SetCreator("AdminMSP", []byte("admin"))
+	invoke(t, stub, "tx7", "expire", "pts")
+	assertBalance(t, stub, "alice", "")
+	assertBalance(t, stub, "sink", `[{"expire":99991231,"amount":"50"}]`)
+}
+
+func TestUpgradeMigratesTheHoldersBeforeTheUpgrade(t *testing.T) {
+	stub := newBonusStub(t)
+	invoke(t, stub, "tx1", "assign", "pts", "alice", "100", "20171201")
+	// the layout before the upgrade: a list under the asset name followed by
+	// the user, and no holder index
+	stub.State["ptscarol"] = []byte(`[{"expire":20171001,"amount":7},{"expire":20991231,"amount":3}]`)
+	stub.State["ptsx"] = []byte(`{"owner":"org","balance":"10","name":"ptsx"}`)
+	stub.State["ptsxdave"] = []byte(`[{"expire":20171001,"amount":"4"}]`)
+
+	stub.SetCreator("AdminMSP", []byte("upgrader"))
+	if response := stub.MockInit("upgrade", []string{"init"}); response.Status != shim.OK {
+		t.Fatal(response.Message)
+	}
+	if stub.State["ptscarol"] != nil || stub.State["ptsxdave"] != nil {
+		t.Fatal("lists not migrated")
+	}
+	assertBalance(t, stub, "carol", `[{"expire":20171001,"amount":"7"},{"expire":20991231,"amount":"3"}]`)
+	if balance := invoke(t, stub, "q1", "query", "dave", "ptsx"); string(balance) != `[{"expire":20171001,"amount":"4"}]` {
+		t.Fatalf("balance of dave %s", balance)
+	}
+
+	// the admin set is kept by the upgrade
+	invokeFails(t, stub, "tx2", "expire", "pts")
+	stub.SetCreator("AdminMSP", []byte("admin"))
+	invoke(t, stub, "tx3", "expire", "pts")
+	assertBalance(t, stub, "carol", `[{"expire":20991231,"amount":"3"}]`)
+	assertBalance(t, stub, "alice", `[{"expire":20171201,"amount":"100"}]`)
+
+	// the migrated holder spends the points of the list
+	stub.SetCreator("Org1MSP", []byte("carol"))
+	invoke(t, stub, "tx4", "transfer", "pts", "alice", "2", "0")
+	assertBalance(t, stub, "carol", `[{"expire":20991231,"amount":"1"}]`)
+}
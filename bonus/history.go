@@ -0,0 +1,208 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/chaincode/common"
+	"github.com/chaincode/decimal"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+const (
+	// assetHistoryIndex keys the transfer records: asset~timestamp~txId~seq,
+	// seq numbering the records of a transaction
+	assetHistoryIndex = "asset~timestamp~txId~seq"
+	// userHistoryIndex points a user to the records of the transfers the
+	// user took part in: user~asset~timestamp~txId~seq
+	userHistoryIndex = "user~asset~timestamp~txId~seq"
+
+	defaultHistoryPageSize = 20
+	maxHistoryPageSize     = 200
+)
+
+// TransferRecord is the immutable record of a movement of points
+type TransferRecord struct {
+	TxID      string             `json:"txId"`
+	Type      string             `json:"type"`
+	Asset     string             `json:"asset"`
+	From      string             `json:"from,omitempty"`
+	To        string             `json:"to,omitempty"`
+	Amount    decimal.Amount     `json:"amount"`
+	Details   []common.UserAsset `json:"details,omitempty"`
+	Timestamp int64              `json:"timestamp"`
+}
+
+// HistoryPage is one page of the history query. Bookmark is empty on the
+// last page, otherwise it is passed back to get the next page.
+type HistoryPage struct {
+	Records  []TransferRecord `json:"records"`
+	Bookmark string           `json:"bookmark,omitempty"`
+}
+
+// recordTransfer appends a record of the current transaction to the
+// history of the asset and of both parties. A transaction writing several
+// records numbers them with seq. It returns the record written.
+func recordTransfer(stub shim.ChaincodeStubInterface, seq int, recordType, assetName, from, to string, details []common.UserAsset) (*TransferRecord, error) {
+	txTimestamp, err := stub.GetTxTimestamp()
+	if err != nil {
+		return nil, fmt.Errorf("Failed getting transaction timestamp: %s", err)
+	}
+	amount, err := common.SumAssets(details)
+	if err != nil {
+		return nil, err
+	}
+	record := TransferRecord{
+		TxID:      stub.GetTxID(),
+		Type:      recordType,
+		Asset:     assetName,
+		From:      from,
+		To:        to,
+		Amount:    amount,
+		Details:   details,
+		Timestamp: txTimestamp.Seconds,
+	}
+	recordJSONasBytes, err := json.Marshal(record)
+	if err != nil {
+		return nil, fmt.Errorf("Failed marshalling transfer record: %s", err)
+	}
+
+	// zero padded so that the keys sort in time order
+	timestamp := fmt.Sprintf("%020d", record.Timestamp)
+	sequence := fmt.Sprintf("%06d", seq)
+	recordKey, err := stub.CreateCompositeKey(assetHistoryIndex, []string{assetName, timestamp, record.TxID, sequence})
+	if err != nil {
+		return nil, err
+	}
+	oldRecord, err := stub.GetState(recordKey)
+	if err != nil {
+		return nil, err
+	}
+	if oldRecord != nil {
+		return nil, fmt.Errorf("transfer record of %s already exists", record.TxID)
+	}
+	err = stub.PutState(recordKey, recordJSONasBytes)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, user := range []string{from, to} {
+		if user == "" {
+			continue
+		}
+		userKey, err := stub.CreateCompositeKey(userHistoryIndex, []string{user, assetName, timestamp, record.TxID, sequence})
+		if err != nil {
+			return nil, err
+		}
+		// Only the key is needed, the record lives under the asset index.
+		err = stub.PutState(userKey, []byte{0x00})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return &record, nil
+}
+
+// checkUser fails when user can not be a party of the history, whose keys
+// need valid UTF-8 without the separators of the composite keys
+func checkUser(stub shim.ChaincodeStubInterface, user string) error {
+	_, err := stub.CreateCompositeKey(userHistoryIndex, []string{user})
+	if err != nil {
+		return fmt.Errorf("the user is incorrect: %s", err)
+	}
+	return nil
+}
+
+// history pages through the movements of an asset, or of one user of the
+// asset when the user is not empty.
+// args: assetName, user, [pageSize], [bookmark]
+func (t *BonusManagementChaincode) history(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	assetName := args[0]
+	user := args[1]
+	pageSize := defaultHistoryPageSize
+	if len(args) > 2 && args[2] != "" {
+		size, err := strconv.Atoi(args[2])
+		if err != nil || size <= 0 {
+			return shim.Error("page size must be a positive integer")
+		}
+		pageSize = size
+	}
+	if pageSize > maxHistoryPageSize {
+		pageSize = maxHistoryPageSize
+	}
+	bookmark := ""
+	if len(args) > 3 {
+		bookmark = args[3]
+	}
+
+	indexName := assetHistoryIndex
+	attributes := []string{assetName}
+	if user != "" {
+		indexName = userHistoryIndex
+		attributes = []string{user, assetName}
+	}
+	prefix, err := stub.CreateCompositeKey(indexName, attributes)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	startKey := prefix
+	if bookmark != "" {
+		if !strings.HasPrefix(bookmark, prefix) {
+			return shim.Error("bookmark does not belong to this history")
+		}
+		startKey = bookmark
+	}
+
+	resultsIterator, err := stub.GetStateByRange(startKey, prefix+string(utf8.MaxRune))
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	defer resultsIterator.Close()
+
+	page := HistoryPage{Records: []TransferRecord{}}
+	lastKey := ""
+	for resultsIterator.HasNext() {
+		key, value, err := resultsIterator.Next()
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		if key == bookmark {
+			continue
+		}
+		if len(page.Records) == pageSize {
+			page.Bookmark = lastKey
+			break
+		}
+		if user != "" {
+			_, keyParts, err := stub.SplitCompositeKey(key)
+			if err != nil {
+				return shim.Error(err.Error())
+			}
+			recordKey, err := stub.CreateCompositeKey(assetHistoryIndex, keyParts[1:])
+			if err != nil {
+				return shim.Error(err.Error())
+			}
+			value, err = stub.GetState(recordKey)
+			if err != nil {
+				return shim.Error("Failed to get transfer record: " + err.Error())
+			}
+		}
+		var record TransferRecord
+		err = json.Unmarshal(value, &record)
+		if err != nil {
+			return shim.Error("Failed decoding transfer record: " + err.Error())
+		}
+		page.Records = append(page.Records, record)
+		lastKey = key
+	}
+
+	pageJSONasBytes, err := json.Marshal(page)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(pageJSONasBytes)
+}
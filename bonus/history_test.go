@@ -0,0 +1,77 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/chaincode/mockstub"
+)
+
+func queryHistory(t *testing.T, stub *mockstub.MockStub, args ...string) HistoryPage {
+	t.Helper()
+	var page HistoryPage
+	payload := invoke(t, stub, "history", append([]string{"history"}, args...)...)
+	if err := json.Unmarshal(payload, &page); err != nil {
+		t.Fatal(err)
+	}
+	return page
+}
+
+func TestHistoryPagesThroughTheRecords(t *testing.T) {
+	stub := newBonusStub(t)
+	stub.SetTxTimestamp(time.Date(2017, 11, 16, 0, 0, 1, 0, time.UTC))
+	invoke(t, stub, "tx1", "assign", "pts", "alice", "100", "20171201")
+	stub.SetTxTimestamp(time.Date(2017, 11, 16, 0, 0, 2, 0, time.UTC))
+	invoke(t, stub, "tx2", "assign", "pts", "alice", "50", "20180101")
+	stub.SetCreator("Org1MSP", []byte("alice"))
+	stub.SetTxTimestamp(time.Date(2017, 11, 16, 0, 0, 3, 0, time.UTC))
+	invoke(t, stub, "tx3", "transfer", "pts", "bob", "120", "0")
+
+	page := queryHistory(t, stub, "pts", "", "2")
+	if len(page.Records) != 2 || page.Bookmark == "" || page.Records[1].TxID != "tx1" || page.Records[1].Type != "assign" {
+		t.Fatalf("first page %+v", page)
+	}
+	page = queryHistory(t, stub, "pts", "", "2", page.Bookmark)
+	if len(page.Records) != 2 || page.Bookmark != "" {
+		t.Fatalf("last page %+v", page)
+	}
+	record := page.Records[1]
+	if record.TxID != "tx3" || record.From != "alice" || record.To != "bob" || record.Amount.String() != "120" ||
+		len(record.Details) != 2 || record.Timestamp != 1510790403 {
+		t.Fatalf("transfer record %+v", record)
+	}
+
+	page = queryHistory(t, stub, "pts", "bob")
+	if len(page.Records) != 1 || page.Records[0].TxID != "tx3" {
+		t.Fatalf("history of bob %+v", page)
+	}
+	page = queryHistory(t, stub, "pts", "alice")
+	if len(page.Records) != 3 {
+		t.Fatalf("history of alice %+v", page)
+	}
+
+	invokeFails(t, stub, "tx4", "history", "pts", "", "0")
+	invokeFails(t, stub, "tx5", "history", "pts", "bob", "2", page.Bookmark+"x")
+}
+
+func TestUserOutOfTheHistoryKeysFails(t *testing.T) {
+	stub := newBonusStub(t)
+	for _, args := range [][]string{
+		{"issue", "miles", "\xff", "1000"},
+		{"assign", "pts", "\xff", "10", "20171201"},
+		{"setSink", "pts", "\xff"},
+	} {
+		if message := invokeFails(t, stub, "tx1", args...); !strings.HasPrefix(message, "the user is incorrect") {
+			t.Fatalf("%v: %s", args, message)
+		}
+	}
+	invoke(t, stub, "tx2", "assign", "pts", "alice", "10", "20171201")
+	stub.SetCreator("Org1MSP", []byte("alice"))
+	invokeFails(t, stub, "tx3", "transfer", "pts", "\xff", "1", "0")
+	invokeFails(t, stub, "tx4", "transferWithDetail", "pts", "\xff", `[{"expire":0,"amount":"1"}]`)
+	if page := queryHistory(t, stub, "pts", "alice"); len(page.Records) != 1 {
+		t.Fatalf("history of alice %+v", page)
+	}
+}
@@ -0,0 +1,394 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+
+	"github.com/chaincode/common"
+	"github.com/chaincode/decimal"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+const (
+	// merchantIndex keys the merchants accepting an asset: asset~merchant
+	merchantIndex = "asset~merchant"
+	// receiptIndex keys the redemption receipts: asset~merchant~txId
+	receiptIndex = "asset~merchant~txId"
+)
+
+// Merchant is a merchant accepting the points of an asset. Payable is owed
+// by the issuer for the unsettled redemptions, Settled has been paid.
+type Merchant struct {
+	Asset   string         `json:"asset"`
+	ID      string         `json:"id"`
+	Payable decimal.Amount `json:"payable"`
+	Settled decimal.Amount `json:"settled"`
+}
+
+// Receipt is the record of points burnt by a holder at a merchant
+type Receipt struct {
+	TxID       string             `json:"txId"`
+	Asset      string             `json:"asset"`
+	Merchant   string             `json:"merchant"`
+	Holder     string             `json:"holder"`
+	Amount     decimal.Amount     `json:"amount"`
+	Details    []common.UserAsset `json:"details"`
+	Timestamp  int64              `json:"timestamp"`
+	Settled    bool               `json:"settled"`
+	SettleTxID string             `json:"settleTxId,omitempty"`
+}
+
+// Settlement is the payload of the event emitted by settle: the merchant
+// after the settlement, the receipts settled and their total amount
+type Settlement struct {
+	Merchant Merchant       `json:"merchant"`
+	Receipts []string       `json:"receipts"`
+	Amount   decimal.Amount `json:"amount"`
+}
+
+// MerchantResult is the result of the queryMerchant function
+type MerchantResult struct {
+	Merchant  Merchant  `json:"merchant"`
+	Unsettled []Receipt `json:"unsettled"`
+}
+
+func getMerchant(stub shim.ChaincodeStubInterface, assetName, merchantID string) (*Merchant, error) {
+	merchantKey, err := stub.CreateCompositeKey(merchantIndex, []string{assetName, merchantID})
+	if err != nil {
+		return nil, err
+	}
+	merchantJSONasBytes, err := stub.GetState(merchantKey)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to get merchant: %s", err)
+	}
+	if merchantJSONasBytes == nil {
+		return nil, nil
+	}
+	var merchant Merchant
+	err = json.Unmarshal(merchantJSONasBytes, &merchant)
+	if err != nil {
+		return nil, fmt.Errorf("Failed decoding merchant: %s", err)
+	}
+	return &merchant, nil
+}
+
+func putMerchant(stub shim.ChaincodeStubInterface, merchant *Merchant) error {
+	merchantKey, err := stub.CreateCompositeKey(merchantIndex, []string{merchant.Asset, merchant.ID})
+	if err != nil {
+		return err
+	}
+	merchantJSONasBytes, err := json.Marshal(merchant)
+	if err != nil {
+		return err
+	}
+	return stub.PutState(merchantKey, merchantJSONasBytes)
+}
+
+func putReceipt(stub shim.ChaincodeStubInterface, receipt *Receipt) error {
+	receiptKey, err := stub.CreateCompositeKey(receiptIndex, []string{receipt.Asset, receipt.Merchant, receipt.TxID})
+	if err != nil {
+		return err
+	}
+	receiptJSONasBytes, err := json.Marshal(receipt)
+	if err != nil {
+		return err
+	}
+	return stub.PutState(receiptKey, receiptJSONasBytes)
+}
+
+// unsettledReceipts lists the receipts of a merchant which have not been settled
+func unsettledReceipts(stub shim.ChaincodeStubInterface, assetName, merchantID string) ([]Receipt, error) {
+	receiptsIterator, err := stub.GetStateByPartialCompositeKey(receiptIndex, []string{assetName, merchantID})
+	if err != nil {
+		return nil, err
+	}
+	defer receiptsIterator.Close()
+
+	receipts := []Receipt{}
+	for receiptsIterator.HasNext() {
+		_, receiptJSONasBytes, err := receiptsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+		var receipt Receipt
+		err = json.Unmarshal(receiptJSONasBytes, &receipt)
+		if err != nil {
+			return nil, errors.New("Failed decoding receipt: " + err.Error())
+		}
+		if !receipt.Settled {
+			receipts = append(receipts, receipt)
+		}
+	}
+	return receipts, nil
+}
+
+// registerMerchant lets a merchant accept the points of an asset. Only the
+// owner of the asset can call this function.
+// args: assetName, merchant
+func (t *BonusManagementChaincode) registerMerchant(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	assetName := args[0]
+	merchantID := args[1]
+	assetJSON, err := common.GetIssuedAsset(stub, assetName)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	caller, err := common.GetCaller(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if assetJSON.Owner != caller {
+		return shim.Error("the caller is not the asset's owner")
+	}
+
+	merchant, err := getMerchant(stub, assetName, merchantID)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if merchant != nil {
+		return shim.Error("merchant already registered: " + merchantID)
+	}
+	zero := decimal.Zero(assetJSON.Scale)
+	merchant = &Merchant{Asset: assetName, ID: merchantID, Payable: zero, Settled: zero}
+	err = putMerchant(stub, merchant)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	err = common.SetEvent(stub, EventMerchantRegistered, merchant)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(nil)
+}
+
+// redeem burns points of the caller at a registered merchant, the earliest
+// expiring first, and accrues the amount to the payable of the issuer to the
+// merchant.
+// args: assetName, merchant, amount, lastExpire
+func (t *BonusManagementChaincode) redeem(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	fmt.Println("Redeem...")
+
+	assetName := args[0]
+	merchantID := args[1]
+	assetJSON, err := common.GetIssuedAsset(stub, assetName)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	amount, err := common.ParseAmount(args[2], assetJSON.Scale)
+	if err != nil {
+		return shim.Error("amount argument is incorrect: " + err.Error())
+	}
+	if amount.IsZero() {
+		return shim.Error("the amount must be positive")
+	}
+	lastExpire, err := strconv.Atoi(args[3])
+	if err != nil {
+		return shim.Error("last expire argument is incorrect")
+	}
+	if lastExpire < 0 {
+		return shim.Error("the last expire must not negative")
+	}
+	// the buckets expired at the date of the transaction are not redeemable,
+	// even when the sweep has not removed them yet
+	today, err := txDate(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if lastExpire < today {
+		lastExpire = today
+	}
+
+	merchant, err := getMerchant(stub, assetName, merchantID)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if merchant == nil {
+		return shim.Error("merchant is not registered for the asset: " + merchantID)
+	}
+
+	holder, err := common.GetCaller(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	userAssets, err := getUserAssets(stub, assetName, holder)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if len(userAssets) == 0 {
+		return shim.Error("the user did not have the asset:" + assetName)
+	}
+	remainArray, burntArray, err := calculateTransferArray(userAssets, lastExpire, amount)
+	if err != nil {
+		return shim.Error("calculate redeem error:" + err.Error())
+	}
+	err = putUserAssets(stub, assetName, holder, remainArray)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	txTimestamp, err := stub.GetTxTimestamp()
+	if err != nil {
+		return shim.Error("Failed getting transaction timestamp: " + err.Error())
+	}
+	receipt := &Receipt{
+		TxID:      stub.GetTxID(),
+		Asset:     assetName,
+		Merchant:  merchantID,
+		Holder:    holder,
+		Amount:    amount,
+		Details:   burntArray,
+		Timestamp: txTimestamp.Seconds,
+	}
+	err = putReceipt(stub, receipt)
+	if err != nil {
+		return shim.Error("store receipt failed: " + err.Error())
+	}
+
+	merchant.Payable, err = merchant.Payable.Add(amount)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	err = putMerchant(stub, merchant)
+	if err != nil {
+		return shim.Error("store merchant failed: " + err.Error())
+	}
+	_, err = recordTransfer(stub, 0, "redeem", assetName, holder, merchantID, burntArray)
+	if err != nil {
+		return shim.Error("record redeem failed: " + err.Error())
+	}
+	err = common.SetEvent(stub, EventBonusRedeemed, receipt)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	receiptJSONasBytes, err := json.Marshal(receipt)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	fmt.Println("Redeem...done")
+	return shim.Success(receiptJSONasBytes)
+}
+
+// settle marks receipts of a merchant as paid by the issuer, all the
+// unsettled ones when no receipt is given. Only the owner of the asset can
+// call this function.
+// args: assetName, merchant, [receipt txId...]
+func (t *BonusManagementChaincode) settle(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	assetName := args[0]
+	merchantID := args[1]
+	assetJSON, err := common.GetIssuedAsset(stub, assetName)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	caller, err := common.GetCaller(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if assetJSON.Owner != caller {
+		return shim.Error("the caller is not the asset's owner")
+	}
+	merchant, err := getMerchant(stub, assetName, merchantID)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if merchant == nil {
+		return shim.Error("merchant is not registered for the asset: " + merchantID)
+	}
+
+	var receipts []Receipt
+	if len(args) == 2 {
+		receipts, err = unsettledReceipts(stub, assetName, merchantID)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+	} else {
+		settling := make(map[string]bool)
+		for _, txID := range args[2:] {
+			if settling[txID] {
+				return shim.Error("receipt listed twice: " + txID)
+			}
+			settling[txID] = true
+			receiptKey, err := stub.CreateCompositeKey(receiptIndex, []string{assetName, merchantID, txID})
+			if err != nil {
+				return shim.Error(err.Error())
+			}
+			receiptJSONasBytes, err := stub.GetState(receiptKey)
+			if err != nil {
+				return shim.Error("Failed to get receipt: " + err.Error())
+			}
+			if receiptJSONasBytes == nil {
+				return shim.Error("receipt not found: " + txID)
+			}
+			var receipt Receipt
+			err = json.Unmarshal(receiptJSONasBytes, &receipt)
+			if err != nil {
+				return shim.Error("Failed decoding receipt: " + err.Error())
+			}
+			if receipt.Settled {
+				return shim.Error("receipt already settled: " + txID)
+			}
+			receipts = append(receipts, receipt)
+		}
+	}
+
+	settlement := Settlement{Receipts: []string{}, Amount: decimal.Zero(assetJSON.Scale)}
+	for i := range receipts {
+		receipts[i].Settled = true
+		receipts[i].SettleTxID = stub.GetTxID()
+		err = putReceipt(stub, &receipts[i])
+		if err != nil {
+			return shim.Error("store receipt failed: " + err.Error())
+		}
+		merchant.Payable, err = merchant.Payable.Sub(receipts[i].Amount)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		merchant.Settled, err = merchant.Settled.Add(receipts[i].Amount)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		settlement.Receipts = append(settlement.Receipts, receipts[i].TxID)
+		settlement.Amount, err = settlement.Amount.Add(receipts[i].Amount)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+	}
+	err = putMerchant(stub, merchant)
+	if err != nil {
+		return shim.Error("store merchant failed: " + err.Error())
+	}
+	settlement.Merchant = *merchant
+	err = common.SetEvent(stub, EventBonusSettled, settlement)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	merchantJSONasBytes, err := json.Marshal(merchant)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(merchantJSONasBytes)
+}
+
+// queryMerchant returns the merchant of an asset with its unsettled receipts
+// args: assetName, merchant
+func (t *BonusManagementChaincode) queryMerchant(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	merchant, err := getMerchant(stub, args[0], args[1])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if merchant == nil {
+		return shim.Error("merchant is not registered for the asset: " + args[1])
+	}
+	receipts, err := unsettledReceipts(stub, args[0], args[1])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	resultJSONasBytes, err := json.Marshal(MerchantResult{*merchant, receipts})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(resultJSONasBytes)
+}
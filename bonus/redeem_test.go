@@ -0,0 +1,49 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestRedeemAndSettle(t *testing.T) {
+	stub := newBonusStub(t)
+	invoke(t, stub, "tx1", "assign", "pts", "alice", "100", "20171101")
+	invoke(t, stub, "tx2", "assign", "pts", "alice", "50", "20171115")
+	invoke(t, stub, "tx3", "assign", "pts", "alice", "30", "20171201")
+	invoke(t, stub, "tx4", "registerMerchant", "pts", "shop")
+
+	stub.SetCreator("Org1MSP", []byte("alice"))
+	invokeFails(t, stub, "tx5", "redeem", "pts", "other", "10", "0")
+	// the bucket expired before the date of the transaction is not spent
+	// although the sweep did not remove it yet
+	invokeFails(t, stub, "tx6", "redeem", "pts", "shop", "81", "0")
+	var receipt Receipt
+	if err := json.Unmarshal(invoke(t, stub, "tx7", "redeem", "pts", "shop", "60", "0"), &receipt); err != nil {
+		t.Fatal(err)
+	}
+	if receipt.TxID != "tx7" || receipt.Amount.String() != "60" || len(receipt.Details) != 2 || receipt.Details[0].Expire != 20171115 {
+		t.Fatalf("receipt %+v", receipt)
+	}
+	assertBalance(t, stub, "alice", `[{"expire":20171101,"amount":"100"},{"expire":20171201,"amount":"20"}]`)
+	invoke(t, stub, "tx8", "redeem", "pts", "shop", "5", "20171201")
+	invokeFails(t, stub, "tx9", "queryMerchant", "pts", "shop")
+
+	stub.SetCreator("OrgMSP", []byte("org"))
+	var result MerchantResult
+	if err := json.Unmarshal(invoke(t, stub, "tx10", "queryMerchant", "pts", "shop"), &result); err != nil {
+		t.Fatal(err)
+	}
+	if result.Merchant.Payable.String() != "65" || len(result.Unsettled) != 2 {
+		t.Fatalf("merchant %+v", result)
+	}
+	invokeFails(t, stub, "tx11", "settle", "pts", "shop", "tx7", "tx7")
+	invoke(t, stub, "tx12", "settle", "pts", "shop", "tx7")
+	invokeFails(t, stub, "tx13", "settle", "pts", "shop", "tx7")
+	var merchant Merchant
+	if err := json.Unmarshal(invoke(t, stub, "tx14", "settle", "pts", "shop"), &merchant); err != nil {
+		t.Fatal(err)
+	}
+	if !merchant.Payable.IsZero() || merchant.Settled.String() != "65" {
+		t.Fatalf("merchant %+v", merchant)
+	}
+}
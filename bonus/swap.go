@@ -0,0 +1,341 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+
+	"github.com/chaincode/common"
+	"github.com/chaincode/decimal"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+const (
+	// rateIndex keys the exchange rates: from~to
+	rateIndex = "rate~from~to"
+	// swapUsageIndex keys the amount swapped per pair and day: from~to~date
+	swapUsageIndex = "swap~from~to~date"
+
+	roundDown   = "down"
+	roundUp     = "up"
+	roundHalfUp = "halfUp"
+)
+
+// ExchangeRate converts points of asset From into points of asset To:
+// Numerator To points for Denominator From points. DailyLimit caps the From
+// points swapped per day, 0 meaning no limit.
+type ExchangeRate struct {
+	From        string         `json:"from"`
+	To          string         `json:"to"`
+	Numerator   int64          `json:"numerator"`
+	Denominator int64          `json:"denominator"`
+	Rounding    string         `json:"rounding"`
+	DailyLimit  decimal.Amount `json:"dailyLimit"`
+}
+
+// Swap is the payload of the event emitted by swap: the records of the
+// points debited from the holder and of the points credited to the holder
+type Swap struct {
+	Debit  *TransferRecord `json:"debit"`
+	Credit *TransferRecord `json:"credit"`
+}
+
+// convert applies the rate to amount at the scale of the target asset,
+// with the rounding rule of the rate
+func (rate *ExchangeRate) convert(amount decimal.Amount, scale int) (decimal.Amount, error) {
+	rounding := decimal.RoundDown
+	switch rate.Rounding {
+	case roundUp:
+		rounding = decimal.RoundUp
+	case roundHalfUp:
+		rounding = decimal.RoundHalfUp
+	}
+	return amount.MulDiv(rate.Numerator, rate.Denominator, scale, rounding)
+}
+
+func getRate(stub shim.ChaincodeStubInterface, from, to string) (*ExchangeRate, error) {
+	rateKey, err := stub.CreateCompositeKey(rateIndex, []string{from, to})
+	if err != nil {
+		return nil, err
+	}
+	rateJSONasBytes, err := stub.GetState(rateKey)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to get exchange rate: %s", err)
+	}
+	if rateJSONasBytes == nil {
+		return nil, nil
+	}
+	var rate ExchangeRate
+	err = json.Unmarshal(rateJSONasBytes, &rate)
+	if err != nil {
+		return nil, fmt.Errorf("Failed decoding exchange rate: %s", err)
+	}
+	return &rate, nil
+}
+
+// setRate sets the exchange rate from an asset to another one. The rate is
+// managed by the owner of the target asset, who funds the swapped points.
+// args: from, to, numerator, denominator, rounding (down, up or halfUp), dailyLimit
+func (t *BonusManagementChaincode) setRate(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	from := args[0]
+	to := args[1]
+	if from == to {
+		return shim.Error("can not exchange an asset with itself")
+	}
+	numerator, err := strconv.ParseInt(args[2], 10, 64)
+	if err != nil || numerator <= 0 {
+		return shim.Error("numerator must be a positive integer")
+	}
+	denominator, err := strconv.ParseInt(args[3], 10, 64)
+	if err != nil || denominator <= 0 {
+		return shim.Error("denominator must be a positive integer")
+	}
+	rounding := args[4]
+	if rounding != roundDown && rounding != roundUp && rounding != roundHalfUp {
+		return shim.Error("rounding must be one of down, up, halfUp")
+	}
+
+	fromAsset, err := common.GetIssuedAsset(stub, from)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	dailyLimit, err := common.ParseAmount(args[5], fromAsset.Scale)
+	if err != nil {
+		return shim.Error("daily limit argument is incorrect: " + err.Error())
+	}
+	toAsset, err := common.GetIssuedAsset(stub, to)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	caller, err := common.GetCaller(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if toAsset.Owner != caller {
+		return shim.Error("the caller is not the owner of the target asset")
+	}
+
+	rate := ExchangeRate{from, to, numerator, denominator, rounding, dailyLimit}
+	rateJSONasBytes, err := json.Marshal(rate)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	rateKey, err := stub.CreateCompositeKey(rateIndex, []string{from, to})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	err = stub.PutState(rateKey, rateJSONasBytes)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	err = common.SetEvent(stub, EventExchangeRateSet, rate)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(nil)
+}
+
+// queryRate returns the exchange rate from an asset to another one
+// args: from, to
+func (t *BonusManagementChaincode) queryRate(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	rate, err := getRate(stub, args[0], args[1])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if rate == nil {
+		return shim.Error("no exchange rate from " + args[0] + " to " + args[1])
+	}
+	rateJSONasBytes, err := json.Marshal(rate)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(rateJSONasBytes)
+}
+
+// swap exchanges points of the caller from an asset to another one at the
+// configured rate. The debited points return to the issue balance of their
+// asset and the credited ones are taken from the issue balance of the target
+// asset, keeping the expire of the debited buckets.
+// args: from, to, amount, lastExpire
+func (t *BonusManagementChaincode) swap(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	fmt.Println("Swap...")
+
+	from := args[0]
+	to := args[1]
+	lastExpire, err := strconv.Atoi(args[3])
+	if err != nil {
+		return shim.Error("last expire argument is incorrect")
+	}
+	if lastExpire < 0 {
+		return shim.Error("the last expire must not negative")
+	}
+
+	rate, err := getRate(stub, from, to)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if rate == nil {
+		return shim.Error("no exchange rate from " + from + " to " + to)
+	}
+	fromAsset, err := common.GetIssuedAsset(stub, from)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	toAsset, err := common.GetIssuedAsset(stub, to)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	amount, err := common.ParseAmount(args[2], fromAsset.Scale)
+	if err != nil {
+		return shim.Error("amount argument is incorrect: " + err.Error())
+	}
+	if amount.IsZero() {
+		return shim.Error("the amount must be positive")
+	}
+
+	// daily limit of the pair
+	today, err := txDate(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	// the buckets expired at the date of the transaction are not swapped,
+	// even when the sweep has not removed them yet
+	if lastExpire < today {
+		lastExpire = today
+	}
+	usageKey, err := stub.CreateCompositeKey(swapUsageIndex, []string{from, to, strconv.Itoa(today)})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	used := decimal.Zero(fromAsset.Scale)
+	usageBytes, err := stub.GetState(usageKey)
+	if err != nil {
+		return shim.Error("Failed to get swap usage: " + err.Error())
+	}
+	if usageBytes != nil {
+		used, err = decimal.Parse(string(usageBytes), fromAsset.Scale)
+		if err != nil {
+			return shim.Error("Failed decoding swap usage: " + err.Error())
+		}
+	}
+	used, err = used.Add(amount)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if rate.DailyLimit.Sign() > 0 && used.Cmp(rate.DailyLimit) > 0 {
+		left, err := rate.DailyLimit.Sub(used)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		left, err = left.Add(amount)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		return shim.Error(fmt.Sprintf("daily swap limit exceeded, %s left", left))
+	}
+
+	holder, err := common.GetCaller(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	userAssets, err := getUserAssets(stub, from, holder)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if len(userAssets) == 0 {
+		return shim.Error("the user did not have the asset:" + from)
+	}
+	remainArray, debitArray, err := calculateTransferArray(userAssets, lastExpire, amount)
+	if err != nil {
+		return shim.Error("calculate swap error:" + err.Error())
+	}
+
+	// convert each bucket rounding down, then put the rounding of the total
+	// on the bucket expiring last
+	credited, err := rate.convert(amount, toAsset.Scale)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if credited.IsZero() {
+		return shim.Error("the amount is too small for the exchange rate")
+	}
+	if credited.Cmp(toAsset.Balance) > 0 {
+		return shim.Error("the issue balance of " + to + " is small than swap amount")
+	}
+	floorRate := ExchangeRate{Numerator: rate.Numerator, Denominator: rate.Denominator, Rounding: roundDown}
+	creditArray := make([]common.UserAsset, 0, len(debitArray))
+	creditSum := decimal.Zero(toAsset.Scale)
+	for _, debit := range debitArray {
+		converted, err := floorRate.convert(debit.Amount, toAsset.Scale)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		creditArray = append(creditArray, common.UserAsset{Expire: debit.Expire, Amount: converted})
+		creditSum, err = creditSum.Add(converted)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+	}
+	rounding, err := credited.Sub(creditSum)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	last := &creditArray[len(creditArray)-1]
+	last.Amount, err = last.Amount.Add(rounding)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	nonEmpty := creditArray[:0]
+	for _, credit := range creditArray {
+		if credit.Amount.Sign() > 0 {
+			nonEmpty = append(nonEmpty, credit)
+		}
+	}
+	creditArray = nonEmpty
+
+	err = putUserAssets(stub, from, holder, remainArray)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	err = addUserAssets(stub, to, holder, creditArray)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	fromAsset.Balance, err = fromAsset.Balance.Add(amount)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	err = common.PutIssuedAsset(stub, fromAsset)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	toAsset.Balance, err = toAsset.Balance.Sub(credited)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	err = common.PutIssuedAsset(stub, toAsset)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	err = stub.PutState(usageKey, []byte(used.String()))
+	if err != nil {
+		return shim.Error("store swap usage failed: " + err.Error())
+	}
+
+	debit, err := recordTransfer(stub, 0, "swap", from, holder, fromAsset.Owner, debitArray)
+	if err != nil {
+		return shim.Error("record swap failed: " + err.Error())
+	}
+	credit, err := recordTransfer(stub, 0, "swap", to, toAsset.Owner, holder, creditArray)
+	if err != nil {
+		return shim.Error("record swap failed: " + err.Error())
+	}
+	err = common.SetEvent(stub, EventBonusSwapped, Swap{Debit: debit, Credit: credit})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	fmt.Println("Swap...done")
+	return shim.Success([]byte(credited.String()))
+}
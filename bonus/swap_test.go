@@ -0,0 +1,31 @@
+package main
+
+import "testing"
+
+func TestSwapConvertsTheUnexpiredBuckets(t *testing.T) {
+	stub := newBonusStub(t)
+	invoke(t, stub, "tx1", "issue", "miles", "org", "1000", "2")
+	invoke(t, stub, "tx2", "assign", "pts", "alice", "100", "20171101")
+	invoke(t, stub, "tx3", "assign", "pts", "alice", "10", "20171201")
+	invoke(t, stub, "tx4", "assign", "pts", "alice", "5", "20180101")
+	invokeFails(t, stub, "tx5", "setRate", "pts", "pts", "1", "3", "halfUp", "12")
+	invokeFails(t, stub, "tx6", "setRate", "pts", "miles", "1", "3", "nearest", "12")
+	invoke(t, stub, "tx7", "setRate", "pts", "miles", "1", "3", "halfUp", "12")
+	if rate := invoke(t, stub, "tx8", "queryRate", "pts", "miles"); len(rate) == 0 {
+		t.Fatal("no rate")
+	}
+
+	stub.SetCreator("Org1MSP", []byte("alice"))
+	invokeFails(t, stub, "tx9", "swap", "miles", "pts", "1", "0")
+	// the bucket expired before the date of the transaction is not swapped
+	// although the sweep did not remove it yet
+	invokeFails(t, stub, "tx10", "swap", "pts", "miles", "16", "0")
+	if credited := invoke(t, stub, "tx11", "swap", "pts", "miles", "11", "0"); string(credited) != "3.67" {
+		t.Fatalf("credited %s", credited)
+	}
+	assertBalance(t, stub, "alice", `[{"expire":20171101,"amount":"100"},{"expire":20180101,"amount":"4"}]`)
+	if balance := invoke(t, stub, "tx12", "query", "alice", "miles"); string(balance) != `[{"expire":20171201,"amount":"3.33"},{"expire":20180101,"amount":"0.34"}]` {
+		t.Fatalf("miles of alice %s", balance)
+	}
+	invokeFails(t, stub, "tx13", "swap", "pts", "miles", "2", "0")
+}
@@ -1,16 +1,15 @@
 package main
 
 import (
-	"fmt"
-	"errors"
 	"encoding/json"
+	"fmt"
 
-	"github.com/hyperledger/fabric/accesscontrol/impl"
 	"github.com/hyperledger/fabric/core/chaincode/shim"
 
-	pb "github.com/hyperledger/fabric/protos/peer"
 	"bytes"
 	"encoding/base64"
+	"github.com/chaincode/common"
+	pb "github.com/hyperledger/fabric/protos/peer"
 )
 
 // SimpleChaincode example simple Chaincode implementation
@@ -18,20 +17,45 @@ type CertificateChaincode struct {
 }
 
 type issuer struct {
-	issuer     string `json:"issuer"`
-	CertType   string `json:"certType"`
+	issuer   string `json:"issuer"`
+	CertType string `json:"certType"`
 }
 
 type certificate struct {
+	CertType string `json:"certType"`
+	ID       string `json:"id"`
+	State    int    `json:"state"` //0:正常， 1:吊销
+	content  string `json:"content"`
+	Owner    string `json:"owner"`
+}
+
+// CertificateType is the payload of the event emitted by issue
+type CertificateType struct {
+	OrganizeID string `json:"organizeId"`
+	CertName   string `json:"certName"`
 	CertType   string `json:"certType"`
-	ID	   string `json:"id"`
-	State      int    `json:"state"`  //0:正常， 1:吊销
-	content    string `json:"content"`
+}
+
+// CertificateAssignment is the payload of the event emitted by assign
+type CertificateAssignment struct {
+	OrganizeID string `json:"organizeId"`
+	CertName   string `json:"certName"`
+	CertType   string `json:"certType"`
+	ID         string `json:"id"`
+	Content    string `json:"content"`
 	Owner      string `json:"owner"`
 }
 
 var indexName = "owner~organize~cert~id"
 
+const (
+	// EventCertificateIssued is emitted by issue, with the CertificateType
+	EventCertificateIssued = "CertificateIssued"
+	// EventCertificateAssigned is emitted by assign, with the
+	// CertificateAssignment
+	EventCertificateAssigned = "CertificateAssigned"
+)
+
 // ===================================================================================
 // Main
 // ===================================================================================
@@ -46,64 +70,42 @@ func main() {
 // ===========================
 func (t *CertificateChaincode) Init(stub shim.ChaincodeStubInterface) pb.Response {
 	// Set the admin
-	// The metadata will contain the certificate of the administrator
-	//adminCert, err := stub.GetCallerCertificate()
-	//if err != nil {
-	//	fmt.Println("Failed getting metadata")
-	//	return shim.Error("Failed getting metadata.")
-	//}
-	//if len(adminCert) == 0 {
-	//	fmt.Println("Invalid admin certificate. Empty.")
-	//	return shim.Error("Invalid admin certificate. Empty.")
-	//}
-	adminCert := []byte{0x00}//stub.GetCallerCertificate()
-
-	fmt.Printf("The administrator is [%x]", adminCert)
-
-	stub.PutState("admin", adminCert)
-
+	// The creator of the deploy transaction is the administrator
 	fmt.Println("Init Chaincode...done")
 
-	return shim.Success(nil)
+	return common.InitAdmin(stub)
 }
 
 // Invoke - Our entry point for Invocations
 // ========================================
+// Functions are declared by router:
+// "issue": to issue a certificate type of an organization, by an admin.
+// "assign": to assign a certificate to an owner, by the organization.
+// "query": to list the certificates of an owner.
+// "proposeAdmin", "approveAdmin", "queryAdmins", "queryProposal": change the admin set
+// once enough admins approved.
 func (t *CertificateChaincode) Invoke(stub shim.ChaincodeStubInterface) pb.Response {
-	function, args := stub.GetFunctionAndParameters()
+	function, _ := stub.GetFunctionAndParameters()
 	fmt.Println("invoke is running " + function)
 
-	// Handle different functions
-	if function == "issue" { //create a new marble
-		return t.issue(stub, args)
-	} else if function == "assign" { //change owner of a specific marble
-		return t.assign(stub, args)
-	} else if function == "append" { //transfer all marbles of a certain color
-		return t.update(stub, args)
-	} else if function == "query" { //transfer all marbles of a certain color
-		return t.query(stub, args)
-	}
+	return t.router().Invoke(stub)
+}
 
-	fmt.Println("invoke did not find func: " + function) //error
-	return shim.Error("Received unknown function invocation")
+func (t *CertificateChaincode) router() *common.Router {
+	r := common.NewRouter()
+	r.Handle("issue", t.issue, common.StringArg("organizeId"), common.StringArg("certName"),
+		common.StringArg("organizeCert")).
+		Require(common.RoleAdmin)
+	r.Handle("assign", t.assign, common.StringArg("organizeId"), common.StringArg("certName"),
+		common.StringArg("id"), common.StringArg("content"), common.StringArg("owner"))
+	r.Handle("append", t.update, common.Variadic(common.StringArg("arg")))
+	r.Handle("query", t.query, common.StringArg("owner"))
+	common.HandleAdmins(r)
+	return r
 }
 
 func (t *CertificateChaincode) issue(stub shim.ChaincodeStubInterface, args []string) pb.Response {
-	// Verify the identity of the caller
-	// Only an administrator can invoker assign
-	adminCertificate, err := stub.GetState("admin")
-	if err != nil {
-		return shim.Error("Failed fetching admin identity")
-	}
-
-	ok, err := t.isCaller(stub, adminCertificate)
-	if err != nil {
-		return shim.Error("Failed checking admin identity")
-	}
-	if !ok {
-		return shim.Error("The caller is not an administrator")
-	}
-
+	// Only an administrator can invoker issue, as required by router
 	organizeId := args[0]
 	certName := args[1]
 	organizeCert := args[2]
@@ -127,6 +129,10 @@ func (t *CertificateChaincode) issue(stub shim.ChaincodeStubInterface, args []st
 	if err != nil {
 		return shim.Error(err.Error())
 	}
+	err = common.SetEvent(stub, EventCertificateIssued, CertificateType{organizeId, certName, certType})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
 
 	fmt.Println("- end issue certificate")
 	return shim.Success(nil)
@@ -158,8 +164,6 @@ func (t *CertificateChaincode) assign(stub shim.ChaincodeStubInterface, args []s
 	cert := &certificate{certType, id, 0, content, owner}
 	certJSONasBytes, _ := json.Marshal(cert)
 
-
-
 	ownerKey := owner + certType + id
 	err = stub.PutState(ownerKey, certJSONasBytes) //rewrite the marble
 	if err != nil {
@@ -174,6 +178,10 @@ func (t *CertificateChaincode) assign(stub shim.ChaincodeStubInterface, args []s
 	////  Note - passing a 'nil' value will effectively delete the key from state, therefore we pass null character as value
 	//value := []byte{0x00}
 	//stub.PutState(colorNameIndexKey, value)
+	err = common.SetEvent(stub, EventCertificateAssigned, CertificateAssignment{organizeId, certName, certType, id, content, owner})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
 
 	fmt.Println("- end assign certificate")
 	return shim.Success(nil)
@@ -234,47 +242,18 @@ func (t *CertificateChaincode) query(stub shim.ChaincodeStubInterface, args []st
 func (t *CertificateChaincode) isCaller(stub shim.ChaincodeStubInterface, certificate []byte) (bool, error) {
 	fmt.Println("Check caller...")
 
-	// In order to enforce access control, we require that the
-	// metadata contains the signature under the signing key corresponding
-	// to the verification key inside certificate of
-	// the payload of the transaction (namely, function name and args) and
-	// the transaction binding (to avoid copying attacks)
-
-	// Verify \sigma=Sign(certificate.sk, tx.Payload||tx.Binding) against certificate.vk
-	// \sigma is in the metadata
-
-	sigma, err := stub.GetCallerMetadata()
-	if err != nil {
-		return false, errors.New("Failed getting metadata")
-	}
-	payload, err := stub.GetPayload()
-	if err != nil {
-		return false, errors.New("Failed getting payload")
-	}
-	binding, err := stub.GetBinding()
+	// The organization certificate must be the identity of the creator
+	identity, err := common.GetIdentity(stub)
 	if err != nil {
-		return false, errors.New("Failed getting binding")
-	}
-
-	fmt.Printf("passed certificate [% x] \n", certificate)
-	fmt.Printf("passed sigma [% x] \n", sigma)
-	fmt.Printf("passed payload [% x] \n", payload)
-	fmt.Printf("passed binding [% x] \n", binding)
-
-	ok, err := impl.NewAccessControlShim(stub).VerifySignature(
-		certificate,
-		sigma,
-		append(payload, binding...),
-	)
-	if err != nil {
-		fmt.Printf("Failed checking signature [%s] \n", err)
-		return ok, err
+		return false, err
 	}
+	ok := bytes.Equal(identity.IDBytes, certificate)
 	if !ok {
-		fmt.Println("Invalid signature")
+		fmt.Println("Invalid caller")
+		return false, nil
 	}
 
 	fmt.Println("Check caller...Verified!")
 
-	return ok, err
-}
\ No newline at end of file
+	return ok, nil
+}
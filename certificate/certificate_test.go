@@ -0,0 +1,55 @@
+package main
+
+import (
+	"encoding/base64"
+	"testing"
+
+	"github.com/chaincode/common"
+	"github.com/chaincode/mockstub"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+func invoke(t *testing.T, stub *mockstub.MockStub, txID string, args ...string) []byte {
+	t.Helper()
+	response := stub.MockInvoke(txID, args)
+	if response.Status != shim.OK {
+		t.Fatalf("%s %v: %s", txID, args, response.Message)
+	}
+	return response.Payload
+}
+
+func TestUpgradeFromTheFirstAdminKey(t *testing.T) {
+	stub := mockstub.NewMockStub("certificate", new(CertificateChaincode))
+	// the first Init stored 0x00 as the admin
+	stub.State["admin"] = []byte{0x00}
+
+	stub.SetCreator("AdminMSP", []byte("upgrader"))
+	if response := stub.MockInit("upgrade", []string{"init"}); response.Status != shim.OK {
+		t.Fatal(response.Message)
+	}
+	organizeCert := base64.StdEncoding.EncodeToString([]byte("org"))
+	invoke(t, stub, "tx1", "issue", "org1", "diploma", organizeCert)
+
+	stub.SetCreator("AdminMSP", []byte("other"))
+	if response := stub.MockInvoke("tx2", []string{"issue", "org1", "license", organizeCert}); response.Status == shim.OK {
+		t.Fatal("issue by an identity out of the admin set")
+	}
+}
+
+func TestAssignEventHasTheContent(t *testing.T) {
+	stub := mockstub.NewMockStub("certificate", new(CertificateChaincode))
+	stub.SetCreator("AdminMSP", []byte("admin"))
+	if response := stub.MockInit("init", []string{"init"}); response.Status != shim.OK {
+		t.Fatal(response.Message)
+	}
+	invoke(t, stub, "tx1", "issue", "org1", "diploma", base64.StdEncoding.EncodeToString([]byte("org")))
+
+	stub.SetCreator("OrgMSP", []byte("org"))
+	invoke(t, stub, "tx2", "assign", "org1", "diploma", "c1", "master of arts", "alice")
+	var assignment CertificateAssignment
+	event, err := common.DecodeEvent(stub.LastEvent().Payload, &assignment)
+	if err != nil || event.Type != EventCertificateAssigned ||
+		assignment != (CertificateAssignment{"org1", "diploma", "org1-diploma", "c1", "master of arts", "alice"}) {
+		t.Fatalf("event %+v %+v, %v", event, assignment, err)
+	}
+}
@@ -0,0 +1,108 @@
+// Package bonus is the typed client of the bonus chaincode
+//
+//	c := bonus.NewClient(peer, "mychannel", "bonus")
+//	amount, _ := decimal.Parse("10.5", 2)
+//	_, err := c.Transfer("points", target, amount, 0)
+//	if client.IsKind(err, bonus.ErrInsufficientBalance) {
+//		...
+//	}
+package bonus
+
+import (
+	"encoding/json"
+	"errors"
+	"strconv"
+
+	"github.com/chaincode/client"
+	"github.com/chaincode/common"
+	"github.com/chaincode/decimal"
+	"github.com/chaincode/services"
+)
+
+// The kinds of the errors of the bonus chaincode
+var (
+	// ErrNotIssued is returned when the asset is not issued
+	ErrNotIssued = errors.New("asset not issued")
+	// ErrAlreadyIssued is returned by Issue for an asset name already issued
+	ErrAlreadyIssued = errors.New("asset already issued")
+	// ErrNotOwner is returned when the caller does not own the issue
+	ErrNotOwner = errors.New("caller is not the owner of the asset")
+	// ErrInsufficientBalance is returned when the balance is less than the
+	// amount
+	ErrInsufficientBalance = errors.New("insufficient balance")
+)
+
+var kinds = []client.Kind{
+	{Substring: "asset have not issued", Err: ErrNotIssued},
+	{Substring: "asset already issue", Err: ErrAlreadyIssued},
+	{Substring: "the caller is not the asset's owner", Err: ErrNotOwner},
+	{Substring: "the issue balance is small than assign amount", Err: ErrInsufficientBalance},
+	{Substring: "balance is less then transfer amount", Err: ErrInsufficientBalance},
+	{Substring: "the user did not have the asset", Err: ErrInsufficientBalance},
+	{Substring: "must not negative", Err: client.ErrInvalidArgument},
+	{Substring: "scale must be an integer", Err: client.ErrInvalidArgument},
+	{Substring: "transfer detail amount is incorrect", Err: client.ErrInvalidArgument},
+	{Substring: "Failed decod transfer detail", Err: client.ErrInvalidArgument},
+}
+
+// Client calls the functions of a bonus chaincode. The errors returned by the
+// chaincode are *client.Error, of the kinds of this package and of client.
+type Client struct {
+	client.Chaincode
+}
+
+// NewClient creates the client of the bonus chaincode name on channel
+func NewClient(peer client.Peer, channel, name string) *Client {
+	return &Client{client.Chaincode{Peer: peer, Channel: channel, Name: name, Kinds: kinds}}
+}
+
+// Issue issues an asset owned by owner, with the scale of the balance
+func (c *Client) Issue(assetName, owner string, balance decimal.Amount) (*services.Response, error) {
+	return c.Invoke("issue", assetName, owner, balance.String(), strconv.Itoa(balance.Scale()))
+}
+
+// Assign assigns points of the issue to user, expiring at expire
+func (c *Client) Assign(assetName, user string, amount decimal.Amount, expire int) (*services.Response, error) {
+	return c.Invoke("assign", assetName, user, amount.String(), strconv.Itoa(expire))
+}
+
+// Transfer transfers points of the caller expiring at or after lastExpire,
+// the earliest expiring first
+func (c *Client) Transfer(assetName, targetUser string, amount decimal.Amount, lastExpire int) (*services.Response, error) {
+	return c.Invoke("transfer", assetName, targetUser, amount.String(), strconv.Itoa(lastExpire))
+}
+
+// TransferWithDetail transfers the amount of each detail from the points of
+// the caller expiring at or after the expire of the detail
+func (c *Client) TransferWithDetail(assetName, targetUser string, details []common.UserAsset) (*services.Response, error) {
+	detailsJSONasBytes, err := json.Marshal(details)
+	if err != nil {
+		return nil, err
+	}
+	return c.Invoke("transferWithDetail", assetName, targetUser, string(detailsJSONasBytes))
+}
+
+// QueryUser returns the points of user by expire, none when the user has
+// no points
+func (c *Client) QueryUser(user, assetName string) ([]common.UserAsset, error) {
+	var userAssets []common.UserAsset
+	_, err := c.QueryJSON(&userAssets, "query", user, assetName)
+	if err != nil {
+		return nil, err
+	}
+	return userAssets, nil
+}
+
+// QueryOrg returns the issue of an asset
+func (c *Client) QueryOrg(assetName string) (*common.AssetIssue, error) {
+	var asset common.AssetIssue
+	found, err := c.QueryJSON(&asset, "queryOrg", assetName)
+	if err != nil {
+		return nil, err
+	}
+	if !found {
+		return nil, &client.Error{Chaincode: c.Name, Function: "queryOrg", Kind: ErrNotIssued,
+			Message: "asset have not issued"}
+	}
+	return &asset, nil
+}
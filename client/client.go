@@ -0,0 +1,133 @@
+// Package client holds what the typed clients of the chaincodes share: the
+// calls of the functions of a chaincode through the peer services, and the
+// classification of the errors returned by the chaincodes.
+package client
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/chaincode/services"
+)
+
+// The kinds of the errors of the functions shared by the chaincodes
+var (
+	// ErrChaincode is the kind of the errors not classified
+	ErrChaincode = errors.New("chaincode error")
+	// ErrInvalidArgument is the kind of the errors on the arguments
+	ErrInvalidArgument = errors.New("invalid argument")
+	// ErrAccessDenied is the kind of the errors on the roles of the caller
+	ErrAccessDenied = errors.New("access denied")
+	// ErrUnknownFunction is the kind of the errors on the function name
+	ErrUnknownFunction = errors.New("unknown function")
+)
+
+// Kind classifies the error messages of a chaincode containing Substring
+type Kind struct {
+	Substring string
+	Err       error
+}
+
+// commonKinds classifies the messages of the router and of the roles
+var commonKinds = []Kind{
+	{"Received unknown function invocation", ErrUnknownFunction},
+	{"Incorrect number of arguments", ErrInvalidArgument},
+	{"argument is incorrect", ErrInvalidArgument},
+	{"the caller does not have the role", ErrAccessDenied},
+}
+
+// Error is the error response of a function of a chaincode
+type Error struct {
+	Chaincode string
+	Function  string
+	// Kind is one of the Err variables of the client packages, ErrChaincode
+	// when the message is not classified
+	Kind    error
+	Message string
+}
+
+func (err *Error) Error() string {
+	return fmt.Sprintf("%s %s failed: %s", err.Chaincode, err.Function, err.Message)
+}
+
+// IsKind reports whether err is an Error of the kind
+func IsKind(err error, kind error) bool {
+	clientErr, ok := err.(*Error)
+	return ok && clientErr.Kind == kind
+}
+
+// Peer sends the proposals, as services.PeerServices
+type Peer interface {
+	Invoke(channel, chaincode, fn string, args []string) (*services.Response, error)
+	Query(channel, chaincode, fn string, args []string) (*services.Response, error)
+}
+
+// Chaincode calls the functions of a chaincode deployed on a channel
+type Chaincode struct {
+	Peer    Peer
+	Channel string
+	Name    string
+	// Kinds classifies the error messages of the chaincode, before the
+	// messages of the router and of the roles
+	Kinds []Kind
+}
+
+// Invoke calls fn and waits for the commit of the transaction
+func (cc *Chaincode) Invoke(fn string, args ...string) (*services.Response, error) {
+	response, err := cc.Peer.Invoke(cc.Channel, cc.Name, fn, args)
+	if err != nil {
+		return response, cc.Errorf(fn, err)
+	}
+	return response, nil
+}
+
+// Query calls fn and returns the payload of the response
+func (cc *Chaincode) Query(fn string, args ...string) ([]byte, error) {
+	response, err := cc.Peer.Query(cc.Channel, cc.Name, fn, args)
+	if err != nil {
+		return nil, cc.Errorf(fn, err)
+	}
+	return response.Payload, nil
+}
+
+// QueryJSON calls fn and decodes the payload of the response into v. It
+// returns false when the payload is empty.
+func (cc *Chaincode) QueryJSON(v interface{}, fn string, args ...string) (bool, error) {
+	payload, err := cc.Query(fn, args...)
+	if err != nil || len(payload) == 0 {
+		return false, err
+	}
+	err = json.Unmarshal(payload, v)
+	if err != nil {
+		return false, fmt.Errorf("Error decoding the response of %s %s: %s", cc.Name, fn, err)
+	}
+	return true, nil
+}
+
+// Errorf converts the rejection of fn by the chaincode into an Error of the
+// kind of its message, and returns the other errors as they are
+func (cc *Chaincode) Errorf(fn string, err error) error {
+	chaincodeErr, ok := err.(*services.ChaincodeError)
+	if !ok {
+		return err
+	}
+	return &Error{
+		Chaincode: cc.Name,
+		Function:  fn,
+		Kind:      cc.classify(chaincodeErr.Message),
+		Message:   chaincodeErr.Message,
+	}
+}
+
+func (cc *Chaincode) classify(message string) error {
+	for _, kinds := range [][]Kind{cc.Kinds, commonKinds} {
+		for _, kind := range kinds {
+			if strings.Contains(message, kind.Substring) {
+				return kind.Err
+			}
+		}
+	}
+	return ErrChaincode
+}
@@ -0,0 +1,416 @@
+// Package insurance is the typed client of the insurance chaincode
+//
+//	c := insurance.NewClient(peer, "mychannel", "insurance")
+//	_, err := c.Apply(company, "policy-1", bank, amount)
+//	if client.IsKind(err, insurance.ErrNoPolicy) {
+//		...
+//	}
+//	policies, err := c.QueryUser(owner)
+package insurance
+
+import (
+	"errors"
+	"strconv"
+
+	"github.com/chaincode/client"
+	"github.com/chaincode/decimal"
+	"github.com/chaincode/services"
+)
+
+// The kinds of the errors of the insurance chaincode
+var (
+	// ErrAlreadyRegistered is returned by Issue and Bank for a company already
+	// registered
+	ErrAlreadyRegistered = errors.New("company already registered")
+	// ErrAlreadyAssigned is returned by Assign when the owner already holds
+	// the policy
+	ErrAlreadyAssigned = errors.New("policy already assigned to the owner")
+	// ErrNoPolicy is returned when the caller does not hold the policy
+	ErrNoPolicy = errors.New("policy not held by the caller")
+	// ErrAlreadyCredited is returned by Credit when the bank already credited
+	// the policy
+	ErrAlreadyCredited = errors.New("policy already credited by the bank")
+	// ErrNoCredit is returned by Apply when the bank does not credit the
+	// policy
+	ErrNoCredit = errors.New("policy not credited by the bank")
+	// ErrPolicyPledged is returned by SetPolicyState and the transfers when
+	// the policy is pledged to a bank
+	ErrPolicyPledged = errors.New("policy pledged to a bank")
+	// ErrPolicyEnded is returned when the policy is not active
+	ErrPolicyEnded = errors.New("policy ended")
+	// ErrTransferPending is returned by RequestTransfer when a transfer of the
+	// policy is pending
+	ErrTransferPending = errors.New("transfer pending")
+	// ErrNoTransfer is returned by ApproveTransfer and RejectTransfer when no
+	// transfer of the policy is pending
+	ErrNoTransfer = errors.New("no transfer pending")
+	// ErrCreditExpired is returned by Apply, Approve and Loan when the credit
+	// of the bank expired
+	ErrCreditExpired = errors.New("credit expired")
+	// ErrCreditExceeded is returned by Apply when the amount is more than the
+	// available credit
+	ErrCreditExceeded = errors.New("credit limit exceeded")
+	// ErrCreditInUse is returned by AmendCredit and RevokeCredit when the
+	// credit is reserved by a pledge
+	ErrCreditInUse = errors.New("credit used by a pledge")
+	// ErrAlreadyPledged is returned by Apply when the policy is pledged
+	ErrAlreadyPledged = errors.New("policy already pledged")
+	// ErrNotPledged is returned by the transitions of a pledge when the
+	// policy is not pledged to the bank
+	ErrNotPledged = errors.New("policy not pledged to the bank")
+	// ErrNotOverdue is returned by Break when no installment of the loan is
+	// overdue
+	ErrNotOverdue = errors.New("no installment overdue")
+	// ErrInvalidTransition is returned by the transitions of a pledge not
+	// allowed from its state
+	ErrInvalidTransition = errors.New("transition not allowed from the state of the pledge")
+)
+
+var kinds = []client.Kind{
+	{Substring: "alreay issue insurance", Err: ErrAlreadyRegistered},
+	{Substring: "user already has this insurance", Err: ErrAlreadyAssigned},
+	{Substring: "user did not have any insurance", Err: ErrNoPolicy},
+	{Substring: "user did not have this insurance", Err: ErrNoPolicy},
+	{Substring: "already credit this insurance", Err: ErrAlreadyCredited},
+	{Substring: "the bank did not credit this insurance", Err: ErrNoCredit},
+	{Substring: "the insurance is pledged to a bank", Err: ErrPolicyPledged},
+	{Substring: "the insurance is ", Err: ErrPolicyEnded},
+	{Substring: "already has a pending transfer", Err: ErrTransferPending},
+	{Substring: "has no pending transfer", Err: ErrNoTransfer},
+	{Substring: "the credit of the bank is expired", Err: ErrCreditExpired},
+	{Substring: "the credit limit is exceeded", Err: ErrCreditExceeded},
+	{Substring: "the credit is used by a pledge", Err: ErrCreditInUse},
+	{Substring: "this insurance is already pledged", Err: ErrAlreadyPledged},
+	{Substring: "this insurance is not pledged", Err: ErrNotPledged},
+	{Substring: "caller is not insurance company", Err: client.ErrAccessDenied},
+	{Substring: "caller is not bank", Err: client.ErrAccessDenied},
+	{Substring: "the caller can not", Err: client.ErrAccessDenied},
+	{Substring: "the pledge is", Err: ErrInvalidTransition},
+	{Substring: "the loan has no overdue installment", Err: ErrNotOverdue},
+	{Substring: "the amount is more than the outstanding", Err: client.ErrInvalidArgument},
+	{Substring: "must not negative", Err: client.ErrInvalidArgument},
+	{Substring: "page size must be a positive integer", Err: client.ErrInvalidArgument},
+	{Substring: "bookmark does not belong to this section", Err: client.ErrInvalidArgument},
+}
+
+// Credit is the credit line offered by a bank on a policy. Used is the part
+// reserved by the pledge of the policy to the bank. Rate is the annual
+// interest rate in basis points.
+type Credit struct {
+	Id      string         `json:"id,omitempty"`
+	Company string         `json:"company,omitempty"`
+	Bank    string         `json:"bank,omitempty"`
+	Expire  int            `json:"expire,omitempty"`
+	Credit  decimal.Amount `json:"credit,omitempty"`
+	Rate    int            `json:"rate,omitempty"`
+	Used    decimal.Amount `json:"used,omitempty"`
+}
+
+// Exposure is the sum of the credit lines of a bank and of their used part
+type Exposure struct {
+	Bank    string         `json:"bank"`
+	Limit   decimal.Amount `json:"limit"`
+	Used    decimal.Amount `json:"used"`
+	Credits []Credit       `json:"credits"`
+}
+
+// The states of a pledge
+const (
+	PledgeApplied   = "applied"
+	PledgeApproved  = "approved"
+	PledgeDisbursed = "disbursed"
+	PledgeRepaid    = "repaid"
+	PledgeDefaulted = "defaulted"
+	PledgeClosed    = "closed"
+)
+
+// Transition is a change of the state of a pledge
+type Transition struct {
+	Action    string `json:"action"`
+	From      string `json:"from,omitempty"`
+	To        string `json:"to"`
+	Caller    string `json:"caller"`
+	TxID      string `json:"txId"`
+	Timestamp int64  `json:"timestamp"`
+}
+
+// Installment is a monthly payment of the principal of a loan
+type Installment struct {
+	Number        int            `json:"number"`
+	Due           int            `json:"due"`
+	Principal     decimal.Amount `json:"principal"`
+	PaidPrincipal decimal.Amount `json:"paidPrincipal"`
+}
+
+// Repayment is a payment of the holder, allocated to the interest accrued
+// then to the principal
+type Repayment struct {
+	TxID      string         `json:"txId"`
+	Timestamp int64          `json:"timestamp"`
+	Amount    decimal.Amount `json:"amount"`
+	Interest  decimal.Amount `json:"interest"`
+	Principal decimal.Amount `json:"principal"`
+}
+
+// Loan is the loan disbursed on a pledge, with its schedule. Interest is the
+// interest accrued on Outstanding until Accrued and not paid yet.
+type Loan struct {
+	Principal   decimal.Amount `json:"principal"`
+	Rate        int            `json:"rate"`
+	Term        int            `json:"term"`
+	Disbursed   int            `json:"disbursed"`
+	Outstanding decimal.Amount `json:"outstanding"`
+	Interest    decimal.Amount `json:"interest"`
+	Accrued     int            `json:"accrued"`
+	Schedule    []Installment  `json:"schedule"`
+	Repayments  []Repayment    `json:"repayments,omitempty"`
+}
+
+// Balance is what remains to be repaid of a loan at Date, and the part of it
+// overdue
+type Balance struct {
+	Date                int            `json:"date"`
+	Principal           decimal.Amount `json:"principal"`
+	Interest            decimal.Amount `json:"interest"`
+	Outstanding         decimal.Amount `json:"outstanding"`
+	Overdue             decimal.Amount `json:"overdue"`
+	OverdueInstallments []int          `json:"overdueInstallments,omitempty"`
+}
+
+// Pledge is the loan of a bank on a policy, with the history of its states
+type Pledge struct {
+	Id      string         `json:"id"`
+	Company string         `json:"company"`
+	Owner   string         `json:"owner,omitempty"`
+	Bank    string         `json:"bank"`
+	Amount  decimal.Amount `json:"amount,omitempty"`
+	State   string         `json:"state"`
+	Loan    *Loan          `json:"loan,omitempty"`
+	History []Transition   `json:"history,omitempty"`
+}
+
+// The states of a policy
+const (
+	PolicyActive = iota
+	PolicySurrendered
+	PolicyMatured
+	PolicyLapsed
+	PolicyClaimed
+)
+
+// The kinds of the transfers of a policy
+const (
+	TransferOwner       = "owner"
+	TransferBeneficiary = "beneficiary"
+)
+
+// Policy is an insurance policy assigned to its owner by the company
+type Policy struct {
+	Owner       string         `json:"owner,omitempty"`
+	Id          string         `json:"id,omitempty"`
+	Company     string         `json:"company,omitempty"`
+	State       int            `json:"state,omitempty"`
+	Balance     decimal.Amount `json:"balance,omitempty"`
+	Beneficiary string         `json:"beneficiary,omitempty"`
+}
+
+// The sections of the portfolio of a bank
+const (
+	SectionCredits      = "credits"
+	SectionApplications = "applications"
+	SectionLoans        = "loans"
+	SectionDefaults     = "defaults"
+)
+
+// PortfolioPledge is a pledge of the portfolio of a bank, with the balance of
+// its loan once disbursed
+type PortfolioPledge struct {
+	Pledge
+	Balance *Balance `json:"balance,omitempty"`
+}
+
+// PortfolioPage is one page of a section of the portfolio of a bank.
+// Bookmark is empty on the last page.
+type PortfolioPage struct {
+	Section  string            `json:"section"`
+	Credits  []Credit          `json:"credits,omitempty"`
+	Pledges  []PortfolioPledge `json:"pledges,omitempty"`
+	Bookmark string            `json:"bookmark,omitempty"`
+}
+
+// PolicyResult is a policy of a user with its credits and its pledge, nil
+// when the policy was never pledged, and the balance of the loan of the
+// pledge at the date of the query, nil before the loan is disbursed
+type PolicyResult struct {
+	Insurance Policy   `json:"insurance,omitempty"`
+	Credits   []Credit `json:"credits,omitempty"`
+	Pledge    *Pledge  `json:"pledge,omitempty"`
+	Balance   *Balance `json:"balance,omitempty"`
+}
+
+// Client calls the functions of an insurance chaincode. The errors returned
+// by the chaincode are *client.Error, of the kinds of this package and of
+// client.
+type Client struct {
+	client.Chaincode
+}
+
+// NewClient creates the client of the insurance chaincode name on channel
+func NewClient(peer client.Peer, channel, name string) *Client {
+	return &Client{client.Chaincode{Peer: peer, Channel: channel, Name: name, Kinds: kinds}}
+}
+
+// Issue registers an insurance company, as the admin. The company is the
+// base64 certificate of the company.
+func (c *Client) Issue(company, detail string) (*services.Response, error) {
+	return c.Invoke("issue", company, detail)
+}
+
+// Bank registers a bank, as the admin. The bank is the base64 certificate of
+// the bank.
+func (c *Client) Bank(bank, detail string) (*services.Response, error) {
+	return c.Invoke("bank", bank, detail)
+}
+
+// Assign assigns the policy id of the calling company to owner
+func (c *Client) Assign(owner, id string, balance decimal.Amount) (*services.Response, error) {
+	return c.Invoke("assign", owner, id, balance.String())
+}
+
+// SetPolicyState ends the policy id of the calling company held by owner,
+// with the state "surrendered", "matured", "lapsed" or "claimed"
+func (c *Client) SetPolicyState(owner, id, state string) (*services.Response, error) {
+	return c.Invoke("setPolicyState", owner, id, state)
+}
+
+// RequestTransfer asks company to transfer the policy id held by the caller
+// to target, as the new owner or beneficiary by kind
+func (c *Client) RequestTransfer(company, id, kind, target string) (*services.Response, error) {
+	return c.Invoke("requestTransfer", company, id, kind, target)
+}
+
+// ApproveTransfer applies the pending transfer of the policy id of the
+// calling company
+func (c *Client) ApproveTransfer(id string) (*services.Response, error) {
+	return c.Invoke("approveTransfer", id)
+}
+
+// RejectTransfer discards the pending transfer of the policy id of the
+// calling company
+func (c *Client) RejectTransfer(id string) (*services.Response, error) {
+	return c.Invoke("rejectTransfer", id)
+}
+
+// Credit offers a credit line of the calling bank on the policy id of
+// company, expiring at expire
+func (c *Client) Credit(company, id string, expire int, limit decimal.Amount, rate int) (*services.Response, error) {
+	return c.Invoke("credit", company, id, strconv.Itoa(expire), limit.String(), strconv.Itoa(rate))
+}
+
+// AmendCredit changes the credit line of the calling bank on the policy id of
+// company
+func (c *Client) AmendCredit(company, id string, expire int, limit decimal.Amount, rate int) (*services.Response, error) {
+	return c.Invoke("amendCredit", company, id, strconv.Itoa(expire), limit.String(), strconv.Itoa(rate))
+}
+
+// RevokeCredit withdraws the credit line of the calling bank on the policy id
+// of company
+func (c *Client) RevokeCredit(company, id string) (*services.Response, error) {
+	return c.Invoke("revokeCredit", company, id)
+}
+
+// Apply applies for a loan of bank crediting the policy id of company held
+// by the caller, reserving amount on the credit, all the available credit
+// when amount is zero
+func (c *Client) Apply(company, id, bank string, amount decimal.Amount) (*services.Response, error) {
+	if amount.IsZero() {
+		return c.Invoke("apply", company, id, bank)
+	}
+	return c.Invoke("apply", company, id, bank, amount.String())
+}
+
+// Approve approves the application of the policy id of company, as the bank
+func (c *Client) Approve(company, id, bank string) (*services.Response, error) {
+	return c.Invoke("approve", company, id, bank)
+}
+
+// Loan disburses the loan on the policy id of company, as the bank, repaid
+// in term monthly installments, 12 when term is 0
+func (c *Client) Loan(company, id, bank string, term int) (*services.Response, error) {
+	if term == 0 {
+		return c.Invoke("loan", company, id, bank)
+	}
+	return c.Invoke("loan", company, id, bank, strconv.Itoa(term))
+}
+
+// Pay repays amount of the loan of bank on the policy id of company, as the
+// holder, all the outstanding balance when amount is zero
+func (c *Client) Pay(company, id, bank string, amount decimal.Amount) (*services.Response, error) {
+	if amount.IsZero() {
+		return c.Invoke("pay", company, id, bank)
+	}
+	return c.Invoke("pay", company, id, bank, amount.String())
+}
+
+// Break defaults the loan on the policy id of company, as the bank
+func (c *Client) Break(company, id, bank string) (*services.Response, error) {
+	return c.Invoke("break", company, id, bank)
+}
+
+// Confirm closes the repaid or defaulted loan on the policy id of company, as
+// the bank
+func (c *Client) Confirm(company, id, bank string) (*services.Response, error) {
+	return c.Invoke("confirm", company, id, bank)
+}
+
+// Cancel closes the pledge of the policy id of company before the loan is
+// disbursed, as the holder or the bank
+func (c *Client) Cancel(company, id, bank string) (*services.Response, error) {
+	return c.Invoke("cancel", company, id, bank)
+}
+
+// Migrate moves the policies of owners, or of every owner when none is
+// given, to the per policy keys, as the admin
+func (c *Client) Migrate(owners ...string) (*services.Response, error) {
+	return c.Invoke("migrate", owners...)
+}
+
+// Exposure returns the exposure of bank, or of every bank when bank is empty
+func (c *Client) Exposure(bank string) ([]Exposure, error) {
+	args := []string{}
+	if bank != "" {
+		args = append(args, bank)
+	}
+	var exposures []Exposure
+	_, err := c.QueryJSON(&exposures, "exposure", args...)
+	if err != nil {
+		return nil, err
+	}
+	return exposures, nil
+}
+
+// QueryBank returns a page of a section of the portfolio of the calling bank,
+// starting after bookmark when it is not empty. A pageSize of 0 is the
+// default size.
+func (c *Client) QueryBank(section string, pageSize int, bookmark string) (*PortfolioPage, error) {
+	size := ""
+	if pageSize > 0 {
+		size = strconv.Itoa(pageSize)
+	}
+	var page PortfolioPage
+	_, err := c.QueryJSON(&page, "queryBank", section, size, bookmark)
+	if err != nil {
+		return nil, err
+	}
+	return &page, nil
+}
+
+// QueryUser returns the policies of owner, none when the owner has no policy
+func (c *Client) QueryUser(owner string) ([]PolicyResult, error) {
+	var policies []PolicyResult
+	_, err := c.QueryJSON(&policies, "user", owner)
+	if err != nil {
+		return nil, err
+	}
+	return policies, nil
+}
@@ -0,0 +1,230 @@
+package common
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+// Role is a set of functions of the chaincodes an identity may call
+type Role string
+
+const (
+	// RoleAdmin manages the roles and runs the maintenance functions. It
+	// belongs to the members of the admin set and can not be granted.
+	RoleAdmin Role = "admin"
+	// RoleIssuer issues assets and manages them
+	RoleIssuer Role = "issuer"
+	// RoleMerchant accepts assets in payment
+	RoleMerchant Role = "merchant"
+	// RoleAuditor reads the records of the assets
+	RoleAuditor Role = "auditor"
+)
+
+// grantIndex keys the grants: role~mspId~subject~attribute~value
+const grantIndex = "role~mspId~subject~attribute~value"
+
+// Roles are the known roles
+var Roles = []Role{RoleAdmin, RoleIssuer, RoleMerchant, RoleAuditor}
+
+// Grant gives a role to the identities of an MSP. When Subject is set, the
+// common name of their certificate must be Subject. When Attribute is set,
+// their certificate must have the attribute, with the value Value unless
+// Value is empty.
+type Grant struct {
+	Role      Role   `json:"role"`
+	MSPID     string `json:"mspId"`
+	Subject   string `json:"subject,omitempty"`
+	Attribute string `json:"attribute,omitempty"`
+	Value     string `json:"value,omitempty"`
+}
+
+// ParseRole checks the name of a role
+func ParseRole(name string) (Role, error) {
+	for _, role := range Roles {
+		if string(role) == name {
+			return role, nil
+		}
+	}
+	return "", fmt.Errorf("unknown role %s", name)
+}
+
+// Matches reports whether the grant applies to the identity
+func (grant *Grant) Matches(identity *Identity) bool {
+	if grant.MSPID != identity.MSPID {
+		return false
+	}
+	if grant.Subject != "" && grant.Subject != identity.Subject().CommonName {
+		return false
+	}
+	if grant.Attribute != "" {
+		value, ok := identity.Attribute(grant.Attribute)
+		if !ok || (grant.Value != "" && grant.Value != value) {
+			return false
+		}
+	}
+	return true
+}
+
+func (grant *Grant) key(stub shim.ChaincodeStubInterface) (string, error) {
+	return stub.CreateCompositeKey(grantIndex, []string{string(grant.Role), grant.MSPID, grant.Subject, grant.Attribute, grant.Value})
+}
+
+// PutGrant stores a grant
+func PutGrant(stub shim.ChaincodeStubInterface, grant *Grant) error {
+	if grant.MSPID == "" {
+		return fmt.Errorf("a grant needs an MSP ID")
+	}
+	if grant.Role == RoleAdmin {
+		return fmt.Errorf("the admin role is changed by the proposals of the admins")
+	}
+	key, err := grant.key(stub)
+	if err != nil {
+		return err
+	}
+	return PutJSON(stub, key, grant)
+}
+
+// DelGrant removes a grant
+func DelGrant(stub shim.ChaincodeStubInterface, grant *Grant) error {
+	key, err := grant.key(stub)
+	if err != nil {
+		return err
+	}
+	return stub.DelState(key)
+}
+
+// GetGrants lists the grants of a role
+func GetGrants(stub shim.ChaincodeStubInterface, role Role) ([]Grant, error) {
+	grantsIterator, err := stub.GetStateByPartialCompositeKey(grantIndex, []string{string(role)})
+	if err != nil {
+		return nil, err
+	}
+	defer grantsIterator.Close()
+
+	grants := []Grant{}
+	for grantsIterator.HasNext() {
+		_, grantJSONasBytes, err := grantsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+		var grant Grant
+		err = json.Unmarshal(grantJSONasBytes, &grant)
+		if err != nil {
+			return nil, fmt.Errorf("Failed decoding grant: %s", err)
+		}
+		grants = append(grants, grant)
+	}
+	return grants, nil
+}
+
+// HasRole reports whether the identity has the role
+func HasRole(stub shim.ChaincodeStubInterface, identity *Identity, role Role) (bool, error) {
+	if role == RoleAdmin {
+		return isAdminMember(stub, identity)
+	}
+	grants, err := GetGrants(stub, role)
+	if err != nil {
+		return false, err
+	}
+	for _, grant := range grants {
+		if grant.Matches(identity) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// CheckRoles fails unless the creator of the transaction has one of the roles
+func CheckRoles(stub shim.ChaincodeStubInterface, roles ...Role) error {
+	identity, err := GetIdentity(stub)
+	if err != nil {
+		return err
+	}
+	for _, role := range roles {
+		ok, err := HasRole(stub, identity, role)
+		if err != nil {
+			return err
+		}
+		if ok {
+			return nil
+		}
+	}
+	names := make([]string, len(roles))
+	for i, role := range roles {
+		names[i] = string(role)
+	}
+	return fmt.Errorf("the caller does not have the role %s", strings.Join(names, " or "))
+}
+
+// HandleRoles registers the functions managing the grants, for the admin
+// role only:
+// "grantRole(role, mspId, subject, attribute, value)" and
+// "revokeRole(role, mspId, subject, attribute, value)": add or remove a grant,
+// subject, attribute and value being empty when not used.
+// "queryRoles(role)": lists the grants of a role.
+func HandleRoles(r *Router) {
+	grantArgs := []Arg{StringArg("role"), StringArg("mspId"), StringArg("subject"), StringArg("attribute"), StringArg("value")}
+	r.Handle("grantRole", grantRole, grantArgs...).Require(RoleAdmin)
+	r.Handle("revokeRole", revokeRole, grantArgs...).Require(RoleAdmin)
+	r.Handle("queryRoles", queryRoles, StringArg("role")).Require(RoleAdmin)
+}
+
+func parseGrant(args []string) (*Grant, error) {
+	role, err := ParseRole(args[0])
+	if err != nil {
+		return nil, err
+	}
+	return &Grant{role, args[1], args[2], args[3], args[4]}, nil
+}
+
+func grantRole(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	grant, err := parseGrant(args)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	err = PutGrant(stub, grant)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	err = SetEvent(stub, EventRoleGranted, grant)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(nil)
+}
+
+func revokeRole(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	grant, err := parseGrant(args)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	err = DelGrant(stub, grant)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	err = SetEvent(stub, EventRoleRevoked, grant)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(nil)
+}
+
+func queryRoles(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	role, err := ParseRole(args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	grants, err := GetGrants(stub, role)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	grantsJSONasBytes, err := json.Marshal(grants)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(grantsJSONasBytes)
+}
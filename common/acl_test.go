@@ -0,0 +1,102 @@
+package common
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"math/big"
+	"testing"
+	"time"
+)
+
+// newCert returns a self-signed PEM certificate of the common name, with
+// the attributes when attrs is not nil
+func newCert(t *testing.T, commonName string, attrs map[string]string) []byte {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: commonName},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	if attrs != nil {
+		value, err := json.Marshal(map[string]interface{}{"attrs": attrs})
+		if err != nil {
+			t.Fatal(err)
+		}
+		template.ExtraExtensions = []pkix.Extension{{Id: attrOID, Value: value}}
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+}
+
+func TestGrantsMatchTheMSPSubjectAndAttributes(t *testing.T) {
+	stub := newTestStub(t)
+	invoke(t, stub, "tx1", "grantRole", "issuer", "Org1MSP", "", "department", "loyalty")
+	invoke(t, stub, "tx2", "grantRole", "issuer", "Org2MSP", "bob", "", "")
+	invokeFails(t, stub, "tx3", "grantRole", "admin", "Org1MSP", "", "", "")
+	invokeFails(t, stub, "tx4", "grantRole", "owner", "Org1MSP", "", "", "")
+	invokeFails(t, stub, "tx5", "grantRole", "issuer", "", "", "", "")
+	var grants []Grant
+	if err := json.Unmarshal(invoke(t, stub, "tx6", "queryRoles", "issuer"), &grants); err != nil || len(grants) != 2 {
+		t.Fatalf("grants %+v, %v", grants, err)
+	}
+
+	for _, c := range []struct {
+		mspID  string
+		cert   []byte
+		issuer bool
+	}{
+		{"Org1MSP", newCert(t, "alice", map[string]string{"department": "loyalty"}), true},
+		{"Org1MSP", newCert(t, "alice", map[string]string{"department": "sales"}), false},
+		{"Org1MSP", newCert(t, "alice", nil), false},
+		{"Org2MSP", newCert(t, "alice", map[string]string{"department": "loyalty"}), false},
+		{"Org2MSP", newCert(t, "bob", nil), true},
+		{"Org3MSP", newCert(t, "bob", nil), false},
+	} {
+		stub.SetCreator(c.mspID, c.cert)
+		response := stub.MockInvoke("tx7", []string{"issue", "pts"})
+		if (response.Status == 200) != c.issuer {
+			t.Errorf("%s %s: %d %s", c.mspID, c.cert[:40], response.Status, response.Message)
+		}
+		// only the admins manage the roles
+		invokeFails(t, stub, "tx8", "queryRoles", "issuer")
+	}
+
+	stub.SetCreator("AdminMSP", []byte("admin"))
+	invoke(t, stub, "tx9", "revokeRole", "issuer", "Org2MSP", "bob", "", "")
+	stub.SetCreator("Org2MSP", newCert(t, "bob", nil))
+	invokeFails(t, stub, "tx10", "issue", "pts")
+}
+
+func TestParseIdentity(t *testing.T) {
+	stub := newTestStub(t)
+	stub.SetCreator("Org1MSP", newCert(t, "alice", map[string]string{"department": "loyalty"}))
+	identity, err := GetIdentity(stub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if identity.MSPID != "Org1MSP" || identity.Subject().CommonName != "alice" || identity.Cert == nil {
+		t.Fatalf("identity %+v", identity)
+	}
+	if value, ok := identity.Attribute("department"); !ok || value != "loyalty" {
+		t.Fatalf("department %q", value)
+	}
+	if _, err = ParseIdentity(nil); err == nil {
+		t.Fatal("parsed an empty creator")
+	}
+	if _, err = ParseIdentity([]byte("not a serialized identity")); err == nil {
+		t.Fatal("parsed an invalid creator")
+	}
+}
@@ -0,0 +1,60 @@
+package common
+
+import (
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+// AdminKey is the key of the serialized identity of the first administrator
+const AdminKey = "admin"
+
+// InitAdmin makes the creator of the deploy transaction the only
+// administrator. An upgrade keeps the current admin set, which only changes
+// through the proposals of HandleAdmins. It is meant to be returned by the
+// Init of the chaincodes.
+func InitAdmin(stub shim.ChaincodeStubInterface) pb.Response {
+	set, err := GetAdminSet(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if set != nil {
+		return shim.Success(nil)
+	}
+	return SeedAdmin(stub)
+}
+
+// SeedAdmin makes the creator of the transaction the only administrator. It
+// is meant for the Init of the chaincodes which recognize the creator of an
+// upgrade as the admin they stored in another format than InitAdmin.
+func SeedAdmin(stub shim.ChaincodeStubInterface) pb.Response {
+	adminCert, err := stub.GetCreator()
+	if err != nil {
+		return shim.Error("Failed getting createor" + err.Error())
+	}
+	if len(adminCert) == 0 {
+		return shim.Error("Invalid assigner role. Empty.")
+	}
+	admin, err := ParseIdentity(adminCert)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	err = stub.PutState(AdminKey, adminCert)
+	if err != nil {
+		return shim.Error("store admin failed: " + err.Error())
+	}
+	err = PutAdminSet(stub, &AdminSet{Threshold: 1, Admins: []Admin{AdminOf(admin)}})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(nil)
+}
+
+// IsAdmin reports whether the creator of the transaction has the admin role
+func IsAdmin(stub shim.ChaincodeStubInterface) (bool, error) {
+	identity, err := GetIdentity(stub)
+	if err != nil {
+		return false, err
+	}
+	return HasRole(stub, identity, RoleAdmin)
+}
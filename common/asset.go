@@ -0,0 +1,80 @@
+package common
+
+import (
+	"encoding/json"
+	"errors"
+
+	"github.com/chaincode/decimal"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+// AssetIssue is an issued asset, stored under its name. Balance is the part
+// of the issue not assigned to users yet.
+type AssetIssue struct {
+	Owner   string         `json:"owner"`
+	Balance decimal.Amount `json:"balance"`
+	Name    string         `json:"name"`
+	// Sink receives the expired points instead of the issue balance
+	Sink string `json:"sink,omitempty"`
+	// Scale is the number of fractional digits of the asset
+	Scale int `json:"scale,omitempty"`
+}
+
+// UserAsset is the amount of an asset a user holds with the same expire, an
+// expiry bucket. The buckets of a user are kept sorted by expire.
+type UserAsset struct {
+	Expire int            `json:"expire"`
+	Amount decimal.Amount `json:"amount"`
+}
+
+// GetIssuedAsset reads the issue of an asset, failing when the asset has not
+// been issued
+func GetIssuedAsset(stub shim.ChaincodeStubInterface, assetName string) (*AssetIssue, error) {
+	assetJSONasBytes, err := stub.GetState(assetName)
+	if err != nil {
+		return nil, errors.New("asset state get failed, have not issued")
+	}
+	if assetJSONasBytes == nil {
+		return nil, errors.New("asset have not issued")
+	}
+	var assetJSON AssetIssue
+	err = json.Unmarshal(assetJSONasBytes, &assetJSON)
+	if err != nil {
+		return nil, errors.New("Error Failed to decode JSON of: " + assetName + " resason " + err.Error())
+	}
+	return &assetJSON, nil
+}
+
+// PutIssuedAsset stores the issue of an asset
+func PutIssuedAsset(stub shim.ChaincodeStubInterface, asset *AssetIssue) error {
+	assetJSONasBytes, err := json.Marshal(asset)
+	if err != nil {
+		return err
+	}
+	return stub.PutState(asset.Name, assetJSONasBytes)
+}
+
+// ParseAmount reads a non negative amount at the scale of an asset
+func ParseAmount(value string, scale int) (decimal.Amount, error) {
+	amount, err := decimal.Parse(value, scale)
+	if err != nil {
+		return amount, err
+	}
+	if amount.Sign() < 0 {
+		return amount, errors.New("the amount must not negative")
+	}
+	return amount, nil
+}
+
+// SumAssets returns the total amount of the buckets
+func SumAssets(userAssets []UserAsset) (decimal.Amount, error) {
+	var total decimal.Amount
+	var err error
+	for _, userAsset := range userAssets {
+		total, err = total.Add(userAsset.Amount)
+		if err != nil {
+			return total, err
+		}
+	}
+	return total, nil
+}
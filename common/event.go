@@ -0,0 +1,80 @@
+package common
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+// EventVersion is the version of the layout of the event payloads. It
+// changes when a field is removed or changes meaning, not when one is added.
+const EventVersion = 1
+
+const (
+	// EventRoleGranted is emitted by grantRole, with the Grant
+	EventRoleGranted = "RoleGranted"
+	// EventRoleRevoked is emitted by revokeRole, with the Grant
+	EventRoleRevoked = "RoleRevoked"
+	// EventAdminProposed is emitted by proposeAdmin, with the pending Proposal
+	EventAdminProposed = "AdminProposed"
+	// EventAdminApproved is emitted by approveAdmin, with the pending Proposal
+	EventAdminApproved = "AdminApproved"
+	// EventAdminSetChanged is emitted when a Proposal is executed
+	EventAdminSetChanged = "AdminSetChanged"
+)
+
+// Event is the payload of the events of the chaincodes. The name of the
+// chaincode event is Type, and Data is the payload specific to the type.
+type Event struct {
+	Type      string      `json:"type"`
+	Version   int         `json:"version"`
+	TxID      string      `json:"txId"`
+	Timestamp int64       `json:"timestamp"`
+	Data      interface{} `json:"data"`
+}
+
+// SetEvent emits the event of the transaction. A transaction carries only
+// one event, the last one set, so a function sets it once, after its writes.
+func SetEvent(stub shim.ChaincodeStubInterface, eventType string, data interface{}) error {
+	txTimestamp, err := stub.GetTxTimestamp()
+	if err != nil {
+		return fmt.Errorf("Failed getting transaction timestamp: %s", err)
+	}
+	event := Event{
+		Type:      eventType,
+		Version:   EventVersion,
+		TxID:      stub.GetTxID(),
+		Timestamp: txTimestamp.Seconds,
+		Data:      data,
+	}
+	eventJSONasBytes, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("Failed encoding event %s: %s", eventType, err)
+	}
+	return stub.SetEvent(eventType, eventJSONasBytes)
+}
+
+// DecodeEvent decodes the payload of an event, and its Data into data when
+// data is not nil
+func DecodeEvent(payload []byte, data interface{}) (*Event, error) {
+	var raw struct {
+		Event
+		Data json.RawMessage `json:"data"`
+	}
+	err := json.Unmarshal(payload, &raw)
+	if err != nil {
+		return nil, fmt.Errorf("Failed decoding event: %s", err)
+	}
+	event := raw.Event
+	if data != nil && len(raw.Data) > 0 {
+		err = json.Unmarshal(raw.Data, data)
+		if err != nil {
+			return nil, fmt.Errorf("Failed decoding data of event %s: %s", event.Type, err)
+		}
+		event.Data = data
+	} else {
+		event.Data = raw.Data
+	}
+	return &event, nil
+}
@@ -0,0 +1,47 @@
+package common
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestEventsCarryTheirTypeVersionAndData(t *testing.T) {
+	stub := newTestStub(t)
+	stub.SetTxTimestamp(time.Unix(1510704000, 0))
+	invoke(t, stub, "tx1", "grantRole", "issuer", "Org1MSP", "", "", "")
+	event := stub.LastEvent()
+	var grant Grant
+	decoded, err := DecodeEvent(event.Payload, &grant)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if event.Name != EventRoleGranted || decoded.Type != EventRoleGranted || decoded.Version != EventVersion ||
+		decoded.TxID != "tx1" || decoded.Timestamp != 1510704000 || decoded.Data != &grant {
+		t.Fatalf("event %s %+v", event.Name, decoded)
+	}
+	if grant.Role != RoleIssuer || grant.MSPID != "Org1MSP" {
+		t.Fatalf("grant %+v", grant)
+	}
+
+	// a transaction which fails emits no event
+	invokeFails(t, stub, "tx2", "revokeRole", "owner", "Org1MSP", "", "", "")
+	if stub.LastEvent().TxID != "tx1" {
+		t.Fatalf("event of a failed transaction %+v", stub.LastEvent())
+	}
+
+	invoke(t, stub, "tx3", "proposeAdmin", "add", "AdminMSP", "admin2")
+	if name := stub.LastEvent().Name; name != EventAdminSetChanged {
+		t.Fatalf("event %s", name)
+	}
+	invoke(t, stub, "tx4", "proposeAdmin", "threshold", "2")
+	invoke(t, stub, "tx5", "proposeAdmin", "add", "AdminMSP", "admin3")
+	decoded, err = DecodeEvent(stub.LastEvent().Payload, nil)
+	if err != nil || decoded.Type != EventAdminProposed || len(decoded.Data.(json.RawMessage)) == 0 {
+		t.Fatalf("event %+v, %v", decoded, err)
+	}
+
+	if _, err = DecodeEvent([]byte("{"), nil); err == nil {
+		t.Fatal("decoded an invalid payload")
+	}
+}
@@ -0,0 +1,340 @@
+package common
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+const (
+	// adminSetKey is the key of the admin set
+	adminSetKey = "admins"
+	// proposalIndex keys the proposals changing the admin set: id
+	proposalIndex = "proposal~id"
+
+	// ActionAdd adds an admin
+	ActionAdd = "add"
+	// ActionRemove removes an admin
+	ActionRemove = "remove"
+	// ActionRotate replaces the identity of an admin with a new one
+	ActionRotate = "rotate"
+	// ActionThreshold changes the number of approvals needed
+	ActionThreshold = "threshold"
+
+	// ProposalPending is the state of a proposal waiting for approvals
+	ProposalPending = "pending"
+	// ProposalExecuted is the state of an applied proposal
+	ProposalExecuted = "executed"
+)
+
+// Admin is an identity of the admin set
+type Admin struct {
+	MSPID string `json:"mspId"`
+	ID    string `json:"id"`
+}
+
+// AdminOf returns the admin matching an identity
+func AdminOf(identity *Identity) Admin {
+	return Admin{identity.MSPID, identity.ID()}
+}
+
+// AdminSet lists the admins. A change of the set needs the approval of
+// Threshold of them.
+type AdminSet struct {
+	Threshold int     `json:"threshold"`
+	Admins    []Admin `json:"admins"`
+}
+
+func (set *AdminSet) index(admin Admin) int {
+	for i, a := range set.Admins {
+		if a == admin {
+			return i
+		}
+	}
+	return -1
+}
+
+// Contains reports whether the admin belongs to the set
+func (set *AdminSet) Contains(admin Admin) bool {
+	return set.index(admin) >= 0
+}
+
+// GetAdminSet reads the admin set. Before any change, the set is the creator
+// of the deploy transaction stored by InitAdmin, alone. It returns nil when
+// there is no admin, or when the admin key holds a value of a chaincode
+// before InitAdmin which is not a serialized identity, like the 0x00 of the
+// first certificate chaincode.
+func GetAdminSet(stub shim.ChaincodeStubInterface) (*AdminSet, error) {
+	var set AdminSet
+	found, err := GetJSON(stub, adminSetKey, &set)
+	if err != nil {
+		return nil, err
+	}
+	if found {
+		return &set, nil
+	}
+	adminCert, err := stub.GetState(AdminKey)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to get admin's cert, error: %s", err)
+	}
+	if len(adminCert) == 0 {
+		return nil, nil
+	}
+	admin, err := ParseIdentity(adminCert)
+	if err != nil || admin.MSPID == "" {
+		return nil, nil
+	}
+	return &AdminSet{Threshold: 1, Admins: []Admin{AdminOf(admin)}}, nil
+}
+
+// PutAdminSet stores the admin set
+func PutAdminSet(stub shim.ChaincodeStubInterface, set *AdminSet) error {
+	return PutJSON(stub, adminSetKey, set)
+}
+
+// isAdminMember reports whether the identity belongs to the admin set
+func isAdminMember(stub shim.ChaincodeStubInterface, identity *Identity) (bool, error) {
+	set, err := GetAdminSet(stub)
+	if err != nil || set == nil {
+		return false, err
+	}
+	return set.Contains(AdminOf(identity)), nil
+}
+
+// Proposal is a pending or executed change of the admin set. Admin is the
+// admin added, removed or rotated, NewAdmin the identity replacing it.
+type Proposal struct {
+	ID          string  `json:"id"`
+	Action      string  `json:"action"`
+	Admin       *Admin  `json:"admin,omitempty"`
+	NewAdmin    *Admin  `json:"newAdmin,omitempty"`
+	Threshold   int     `json:"threshold,omitempty"`
+	Proposer    Admin   `json:"proposer"`
+	Approvals   []Admin `json:"approvals"`
+	State       string  `json:"state"`
+	ExecuteTxID string  `json:"executeTxId,omitempty"`
+}
+
+// apply checks the proposal against the set and changes the set
+func (proposal *Proposal) apply(set *AdminSet) error {
+	switch proposal.Action {
+	case ActionAdd:
+		if set.Contains(*proposal.Admin) {
+			return errors.New("the identity is already an admin")
+		}
+		set.Admins = append(set.Admins, *proposal.Admin)
+	case ActionRemove:
+		i := set.index(*proposal.Admin)
+		if i < 0 {
+			return errors.New("the identity is not an admin")
+		}
+		if len(set.Admins)-1 < set.Threshold {
+			return fmt.Errorf("can not remove an admin, %d approvals are needed", set.Threshold)
+		}
+		set.Admins = append(set.Admins[:i], set.Admins[i+1:]...)
+	case ActionRotate:
+		i := set.index(*proposal.Admin)
+		if i < 0 {
+			return errors.New("the identity is not an admin")
+		}
+		if set.Contains(*proposal.NewAdmin) {
+			return errors.New("the new identity is already an admin")
+		}
+		set.Admins[i] = *proposal.NewAdmin
+	case ActionThreshold:
+		if proposal.Threshold < 1 || proposal.Threshold > len(set.Admins) {
+			return fmt.Errorf("the threshold must be between 1 and %d", len(set.Admins))
+		}
+		set.Threshold = proposal.Threshold
+	default:
+		return fmt.Errorf("unknown action %s", proposal.Action)
+	}
+	return nil
+}
+
+// approvals counts the approvals of the current admins
+func (proposal *Proposal) approvals(set *AdminSet) int {
+	count := 0
+	for _, approval := range proposal.Approvals {
+		if set.Contains(approval) {
+			count++
+		}
+	}
+	return count
+}
+
+func getProposal(stub shim.ChaincodeStubInterface, id string) (*Proposal, error) {
+	key, err := stub.CreateCompositeKey(proposalIndex, []string{id})
+	if err != nil {
+		return nil, err
+	}
+	var proposal Proposal
+	found, err := GetJSON(stub, key, &proposal)
+	if err != nil {
+		return nil, err
+	}
+	if !found {
+		return nil, errors.New("no proposal " + id)
+	}
+	return &proposal, nil
+}
+
+func putProposal(stub shim.ChaincodeStubInterface, proposal *Proposal) error {
+	key, err := stub.CreateCompositeKey(proposalIndex, []string{proposal.ID})
+	if err != nil {
+		return err
+	}
+	return PutJSON(stub, key, proposal)
+}
+
+// execute applies the proposal once enough admins approved it, and stores
+// the proposal. The event is eventType while the proposal is pending.
+func execute(stub shim.ChaincodeStubInterface, set *AdminSet, proposal *Proposal, eventType string) pb.Response {
+	if proposal.approvals(set) >= set.Threshold {
+		err := proposal.apply(set)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		err = PutAdminSet(stub, set)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		proposal.State = ProposalExecuted
+		proposal.ExecuteTxID = stub.GetTxID()
+		eventType = EventAdminSetChanged
+	}
+	err := putProposal(stub, proposal)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	err = SetEvent(stub, eventType, proposal)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	proposalJSONasBytes, err := json.Marshal(proposal)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(proposalJSONasBytes)
+}
+
+// HandleAdmins registers the functions managing the admin set:
+// "proposeAdmin(action, params...)": proposes a change of the admin set, approved by
+// the proposer, the params being mspId, id for add and remove, mspId, id, newMspId,
+// newId for rotate and the number of approvals for threshold. The id of the new
+// proposal is the id of the transaction.
+// "approveAdmin(proposalId)": approves a pending proposal.
+// The proposal is executed as soon as enough admins approved it. Only an admin can
+// call these functions.
+// "queryAdmins()", "queryProposal(proposalId)": return the admin set and a proposal.
+func HandleAdmins(r *Router) {
+	r.Handle("proposeAdmin", proposeAdmin, StringArg("action"), Variadic(StringArg("param"))).Require(RoleAdmin)
+	r.Handle("approveAdmin", approveAdmin, StringArg("proposalId")).Require(RoleAdmin)
+	r.Handle("queryAdmins", queryAdmins)
+	r.Handle("queryProposal", queryProposal, StringArg("proposalId"))
+}
+
+func proposeAdmin(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	identity, err := GetIdentity(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	proposer := AdminOf(identity)
+	proposal := &Proposal{
+		ID:        stub.GetTxID(),
+		Action:    args[0],
+		Proposer:  proposer,
+		Approvals: []Admin{proposer},
+		State:     ProposalPending,
+	}
+	params := args[1:]
+	switch proposal.Action {
+	case ActionAdd, ActionRemove:
+		if len(params) != 2 {
+			return shim.Error("Incorrect number of arguments. Expecting action, mspId, id")
+		}
+		proposal.Admin = &Admin{params[0], params[1]}
+	case ActionRotate:
+		if len(params) != 4 {
+			return shim.Error("Incorrect number of arguments. Expecting action, mspId, id, newMspId, newId")
+		}
+		proposal.Admin = &Admin{params[0], params[1]}
+		proposal.NewAdmin = &Admin{params[2], params[3]}
+	case ActionThreshold:
+		if len(params) != 1 {
+			return shim.Error("Incorrect number of arguments. Expecting action, threshold")
+		}
+		proposal.Threshold, err = strconv.Atoi(params[0])
+		if err != nil {
+			return shim.Error("threshold argument is incorrect")
+		}
+	default:
+		return shim.Error("action must be one of add, remove, rotate, threshold")
+	}
+
+	set, err := GetAdminSet(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	// check the proposal against a copy of the current set
+	check := AdminSet{set.Threshold, append([]Admin{}, set.Admins...)}
+	if err = proposal.apply(&check); err != nil {
+		return shim.Error(err.Error())
+	}
+	return execute(stub, set, proposal, EventAdminProposed)
+}
+
+func approveAdmin(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	identity, err := GetIdentity(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	proposal, err := getProposal(stub, args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if proposal.State != ProposalPending {
+		return shim.Error("the proposal is already " + proposal.State)
+	}
+	approver := AdminOf(identity)
+	for _, approval := range proposal.Approvals {
+		if approval == approver {
+			return shim.Error("the caller already approved the proposal")
+		}
+	}
+	proposal.Approvals = append(proposal.Approvals, approver)
+
+	set, err := GetAdminSet(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return execute(stub, set, proposal, EventAdminApproved)
+}
+
+func queryAdmins(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	set, err := GetAdminSet(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	setJSONasBytes, err := json.Marshal(set)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(setJSONasBytes)
+}
+
+func queryProposal(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	proposal, err := getProposal(stub, args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	proposalJSONasBytes, err := json.Marshal(proposal)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(proposalJSONasBytes)
+}
@@ -0,0 +1,81 @@
+package common
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/chaincode/mockstub"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+func queryAdminSet(t *testing.T, stub *mockstub.MockStub) AdminSet {
+	t.Helper()
+	var set AdminSet
+	if err := json.Unmarshal(invoke(t, stub, "query", "queryAdmins"), &set); err != nil {
+		t.Fatal(err)
+	}
+	return set
+}
+
+func TestAdminSetChangesOnceEnoughAdminsApproved(t *testing.T) {
+	stub := newTestStub(t)
+	if set := queryAdminSet(t, stub); set.Threshold != 1 || len(set.Admins) != 1 || set.Admins[0] != (Admin{"AdminMSP", "admin"}) {
+		t.Fatalf("initial set %+v", set)
+	}
+	// one approval is enough for the first admin alone
+	invoke(t, stub, "p1", "proposeAdmin", "add", "AdminMSP", "admin2")
+	invokeFails(t, stub, "p2", "proposeAdmin", "threshold", "3")
+	invokeFails(t, stub, "p3", "proposeAdmin", "add", "AdminMSP", "admin2")
+	invokeFails(t, stub, "p4", "proposeAdmin", "demote", "AdminMSP", "admin2")
+	invokeFails(t, stub, "p5", "proposeAdmin", "add", "AdminMSP")
+	invoke(t, stub, "p6", "proposeAdmin", "threshold", "2")
+
+	var proposal Proposal
+	if err := json.Unmarshal(invoke(t, stub, "p7", "proposeAdmin", "rotate", "AdminMSP", "admin", "AdminMSP", "admin1"), &proposal); err != nil {
+		t.Fatal(err)
+	}
+	if proposal.ID != "p7" || proposal.State != ProposalPending || len(proposal.Approvals) != 1 {
+		t.Fatalf("proposal %+v", proposal)
+	}
+	invokeFails(t, stub, "p8", "approveAdmin", "p7")
+	stub.SetCreator("AdminMSP", []byte("outsider"))
+	invokeFails(t, stub, "p9", "approveAdmin", "p7")
+	invokeFails(t, stub, "p10", "proposeAdmin", "remove", "AdminMSP", "admin")
+
+	stub.SetCreator("AdminMSP", []byte("admin2"))
+	if err := json.Unmarshal(invoke(t, stub, "p11", "approveAdmin", "p7"), &proposal); err != nil {
+		t.Fatal(err)
+	}
+	if proposal.State != ProposalExecuted || proposal.ExecuteTxID != "p11" {
+		t.Fatalf("proposal %+v", proposal)
+	}
+	if set := queryAdminSet(t, stub); set.Threshold != 2 || len(set.Admins) != 2 || set.Admins[0] != (Admin{"AdminMSP", "admin1"}) {
+		t.Fatalf("set %+v", set)
+	}
+	invokeFails(t, stub, "p12", "approveAdmin", "p7")
+
+	// the rotated identity lost the admin role
+	stub.SetCreator("AdminMSP", []byte("admin"))
+	invokeFails(t, stub, "p13", "grantRole", "issuer", "Org1MSP", "", "", "")
+	stub.SetCreator("AdminMSP", []byte("admin1"))
+	invoke(t, stub, "p14", "grantRole", "issuer", "Org1MSP", "", "", "")
+	// removing an admin would leave less admins than approvals needed
+	invokeFails(t, stub, "p15", "proposeAdmin", "remove", "AdminMSP", "admin2")
+	if err := json.Unmarshal(invoke(t, stub, "p16", "queryProposal", "p7"), &proposal); err != nil || proposal.ExecuteTxID != "p11" {
+		t.Fatalf("proposal %+v, %v", proposal, err)
+	}
+	invokeFails(t, stub, "p17", "queryProposal", "p15")
+}
+
+func TestUpgradeKeepsTheAdminSet(t *testing.T) {
+	stub := newTestStub(t)
+	invoke(t, stub, "p1", "proposeAdmin", "add", "AdminMSP", "admin2")
+	stub.SetCreator("AdminMSP", []byte("upgrader"))
+	if response := stub.MockInit("upgrade", nil); response.Status != shim.OK {
+		t.Fatal(response.Message)
+	}
+	if set := queryAdminSet(t, stub); len(set.Admins) != 2 || set.Contains(Admin{"AdminMSP", "upgrader"}) {
+		t.Fatalf("set %+v", set)
+	}
+	invokeFails(t, stub, "p2", "proposeAdmin", "add", "AdminMSP", "upgrader")
+}
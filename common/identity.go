@@ -0,0 +1,116 @@
+// Package common holds what the chaincodes of this repository share: the
+// identity of the caller, the administrator set at deployment and the roles
+// granted to identities, a function router with declared arguments and
+// roles, JSON state helpers and the asset types.
+package common
+
+import (
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	mspprotos "github.com/hyperledger/fabric/protos/msp"
+)
+
+// attrOID is the extension of the certificates issued by the CA carrying the
+// attributes of the registration, as {"attrs":{"name":"value"}}
+var attrOID = asn1.ObjectIdentifier{1, 2, 3, 4, 5, 6, 7, 8, 1}
+
+// Identity is the creator of a transaction
+type Identity struct {
+	// MSPID is the identifier of the membership service provider
+	MSPID string
+	// IDBytes is the identity serialized according to the rules of its MSP,
+	// usually a PEM encoded X.509 certificate
+	IDBytes []byte
+	// Cert is the certificate decoded from IDBytes, nil when IDBytes is not a
+	// certificate
+	Cert *x509.Certificate
+	// Attrs are the attributes of the certificate
+	Attrs map[string]string
+}
+
+// GetIdentity decodes the creator of the transaction
+func GetIdentity(stub shim.ChaincodeStubInterface) (*Identity, error) {
+	creator, err := stub.GetCreator()
+	if err != nil {
+		return nil, fmt.Errorf("can not get creator, error: %s", err)
+	}
+	return ParseIdentity(creator)
+}
+
+// ParseIdentity decodes a serialized identity
+func ParseIdentity(serializedID []byte) (*Identity, error) {
+	if len(serializedID) == 0 {
+		return nil, errors.New("empty creator")
+	}
+	sid := &mspprotos.SerializedIdentity{}
+	err := proto.Unmarshal(serializedID, sid)
+	if err != nil {
+		return nil, fmt.Errorf("Failed decoding creator: %s", err)
+	}
+	identity := &Identity{MSPID: sid.Mspid, IDBytes: sid.IdBytes}
+	block, _ := pem.Decode(sid.IdBytes)
+	if block != nil && block.Type == "CERTIFICATE" {
+		identity.Cert, err = x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("Failed parsing creator's certificate: %s", err)
+		}
+		identity.Attrs, err = certAttributes(identity.Cert)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return identity, nil
+}
+
+func certAttributes(cert *x509.Certificate) (map[string]string, error) {
+	for _, ext := range cert.Extensions {
+		if !ext.Id.Equal(attrOID) {
+			continue
+		}
+		var attrs struct {
+			Attrs map[string]string `json:"attrs"`
+		}
+		err := json.Unmarshal(ext.Value, &attrs)
+		if err != nil {
+			return nil, fmt.Errorf("Failed decoding certificate attributes: %s", err)
+		}
+		return attrs.Attrs, nil
+	}
+	return nil, nil
+}
+
+// Attribute returns the value of an attribute of the certificate
+func (id *Identity) Attribute(name string) (string, bool) {
+	value, ok := id.Attrs[name]
+	return value, ok
+}
+
+// ID is the string used to represent the identity as an owner or a user
+func (id *Identity) ID() string {
+	return string(id.IDBytes)
+}
+
+// Subject is the subject of the certificate, empty when there is none
+func (id *Identity) Subject() pkix.Name {
+	if id.Cert == nil {
+		return pkix.Name{}
+	}
+	return id.Cert.Subject
+}
+
+// GetCaller returns the ID of the creator of the transaction
+func GetCaller(stub shim.ChaincodeStubInterface) (string, error) {
+	identity, err := GetIdentity(stub)
+	if err != nil {
+		return "", err
+	}
+	return identity.ID(), nil
+}
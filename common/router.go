@@ -0,0 +1,204 @@
+package common
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+// Handler executes a function of a chaincode with its arguments
+type Handler func(stub shim.ChaincodeStubInterface, args []string) pb.Response
+
+// Kind is the type an argument is checked against before calling a handler
+type Kind int
+
+const (
+	// KindString accepts any value
+	KindString Kind = iota
+	// KindInt accepts an integer
+	KindInt
+	// KindJSON accepts a JSON document
+	KindJSON
+)
+
+// Arg declares an argument of a function
+type Arg struct {
+	Name string
+	Kind Kind
+	// Optional arguments can be left out at the end of the arguments
+	Optional bool
+	// Variadic marks the last argument as repeatable, zero or more times
+	Variadic bool
+}
+
+// StringArg declares a string argument
+func StringArg(name string) Arg {
+	return Arg{Name: name, Kind: KindString}
+}
+
+// IntArg declares an integer argument
+func IntArg(name string) Arg {
+	return Arg{Name: name, Kind: KindInt}
+}
+
+// JSONArg declares a JSON argument
+func JSONArg(name string) Arg {
+	return Arg{Name: name, Kind: KindJSON}
+}
+
+// Optional makes the argument optional
+func Optional(arg Arg) Arg {
+	arg.Optional = true
+	return arg
+}
+
+// Variadic makes the argument repeatable
+func Variadic(arg Arg) Arg {
+	arg.Variadic = true
+	return arg
+}
+
+func (arg Arg) check(value string) error {
+	switch arg.Kind {
+	case KindInt:
+		if _, err := strconv.Atoi(value); err != nil {
+			return fmt.Errorf("%s argument is incorrect, expecting an integer", arg.Name)
+		}
+	case KindJSON:
+		var v interface{}
+		if err := json.Unmarshal([]byte(value), &v); err != nil {
+			return fmt.Errorf("%s argument is incorrect, expecting JSON", arg.Name)
+		}
+	}
+	return nil
+}
+
+// Route is a function of a chaincode
+type Route struct {
+	Name    string
+	Args    []Arg
+	Handler Handler
+	// Roles lists the roles allowed to call the function, anyone can when
+	// it is empty
+	Roles []Role
+}
+
+// Require restricts the function to the callers having one of the roles
+func (route *Route) Require(roles ...Role) *Route {
+	route.Roles = append(route.Roles, roles...)
+	return route
+}
+
+// Usage describes the function and its arguments, e.g.
+// "issue(assetName, owner, balance, [scale])"
+func (route *Route) Usage() string {
+	names := make([]string, 0, len(route.Args))
+	for _, arg := range route.Args {
+		switch {
+		case arg.Variadic:
+			names = append(names, arg.Name+"...")
+		case arg.Optional:
+			names = append(names, "["+arg.Name+"]")
+		default:
+			names = append(names, arg.Name)
+		}
+	}
+	return route.Name + "(" + strings.Join(names, ", ") + ")"
+}
+
+// checkArgs validates the number and the kinds of the arguments
+func (route *Route) checkArgs(args []string) error {
+	required, max := 0, len(route.Args)
+	for _, arg := range route.Args {
+		if arg.Variadic {
+			max = -1
+		} else if !arg.Optional {
+			required++
+		}
+	}
+	if len(args) < required || (max >= 0 && len(args) > max) {
+		switch {
+		case max < 0:
+			return fmt.Errorf("Incorrect number of arguments. Expecting at least %d", required)
+		case required == max:
+			return fmt.Errorf("Incorrect number of arguments. Expecting %d", required)
+		default:
+			return fmt.Errorf("Incorrect number of arguments. Expecting %d to %d", required, max)
+		}
+	}
+	for i, value := range args {
+		arg := route.Args[len(route.Args)-1]
+		if i < len(route.Args) {
+			arg = route.Args[i]
+		}
+		if err := arg.check(value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Router dispatches the invocations of a chaincode to the handlers of its
+// functions, after checking their arguments and the roles of the caller
+type Router struct {
+	routes map[string]*Route
+}
+
+// NewRouter returns a router without any function
+func NewRouter() *Router {
+	return &Router{routes: make(map[string]*Route)}
+}
+
+// Handle registers the handler of a function. Only the last argument can be
+// variadic and optional arguments must follow the required ones.
+func (r *Router) Handle(name string, handler Handler, args ...Arg) *Route {
+	for i, arg := range args {
+		if arg.Variadic && i != len(args)-1 {
+			panic("common: variadic argument " + arg.Name + " of " + name + " is not the last one")
+		}
+		if i > 0 && !arg.Optional && !arg.Variadic && (args[i-1].Optional || args[i-1].Variadic) {
+			panic("common: required argument " + arg.Name + " of " + name + " follows an optional one")
+		}
+	}
+	route := &Route{Name: name, Args: args, Handler: handler}
+	r.routes[name] = route
+	return route
+}
+
+// Route returns the function registered with the name, nil if none
+func (r *Router) Route(name string) *Route {
+	return r.routes[name]
+}
+
+// Functions lists the usage of the registered functions, sorted by name
+func (r *Router) Functions() []string {
+	usages := make([]string, 0, len(r.routes))
+	for _, route := range r.routes {
+		usages = append(usages, route.Usage())
+	}
+	sort.Strings(usages)
+	return usages
+}
+
+// Invoke calls the handler of the function of the transaction
+func (r *Router) Invoke(stub shim.ChaincodeStubInterface) pb.Response {
+	function, args := stub.GetFunctionAndParameters()
+	route, ok := r.routes[function]
+	if !ok {
+		return shim.Error("Received unknown function invocation:" + function)
+	}
+	if err := route.checkArgs(args); err != nil {
+		return shim.Error(err.Error())
+	}
+	if len(route.Roles) > 0 {
+		if err := CheckRoles(stub, route.Roles...); err != nil {
+			return shim.Error(err.Error())
+		}
+	}
+	return route.Handler(stub, args)
+}
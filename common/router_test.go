@@ -0,0 +1,131 @@
+package common
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/chaincode/mockstub"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+// testChaincode routes "echo(word, [count], json...)", "issue(name)" for the
+// issuers, "ping()" and the functions managing the roles and the admins
+type testChaincode struct{}
+
+func (cc *testChaincode) Init(stub shim.ChaincodeStubInterface) pb.Response {
+	return InitAdmin(stub)
+}
+
+func (cc *testChaincode) Invoke(stub shim.ChaincodeStubInterface) pb.Response {
+	return cc.router().Invoke(stub)
+}
+
+func (cc *testChaincode) router() *Router {
+	r := NewRouter()
+	r.Handle("echo", echo, StringArg("word"), Optional(IntArg("count")), Variadic(JSONArg("json")))
+	r.Handle("issue", echo, StringArg("name")).Require(RoleIssuer)
+	r.Handle("ping", echo)
+	HandleRoles(r)
+	HandleAdmins(r)
+	return r
+}
+
+func echo(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	return shim.Success([]byte(strings.Join(args, ",")))
+}
+
+// newTestStub deploys the test chaincode with admin of AdminMSP as the admin
+func newTestStub(t *testing.T) *mockstub.MockStub {
+	t.Helper()
+	stub := mockstub.NewMockStub("test", new(testChaincode))
+	stub.SetCreator("AdminMSP", []byte("admin"))
+	if response := stub.MockInit("init", nil); response.Status != shim.OK {
+		t.Fatal(response.Message)
+	}
+	return stub
+}
+
+func invoke(t *testing.T, stub *mockstub.MockStub, txID string, args ...string) []byte {
+	t.Helper()
+	response := stub.MockInvoke(txID, args)
+	if response.Status != shim.OK {
+		t.Fatalf("%s %v: %s", txID, args, response.Message)
+	}
+	return response.Payload
+}
+
+// invokeFails invokes a transaction which must fail, and returns its message
+func invokeFails(t *testing.T, stub *mockstub.MockStub, txID string, args ...string) string {
+	t.Helper()
+	response := stub.MockInvoke(txID, args)
+	if response.Status == shim.OK {
+		t.Fatalf("%s %v succeeded", txID, args)
+	}
+	return response.Message
+}
+
+func TestRouterChecksTheArguments(t *testing.T) {
+	stub := newTestStub(t)
+	for _, c := range []struct {
+		args   []string
+		result string
+	}{
+		{[]string{"echo", "a"}, "a"},
+		{[]string{"echo", "a", "2"}, "a,2"},
+		{[]string{"echo", "a", "2", `{"b":1}`, "[3]"}, `a,2,{"b":1},[3]`},
+		{[]string{"ping"}, ""},
+	} {
+		if result := invoke(t, stub, "tx", c.args...); string(result) != c.result {
+			t.Errorf("%v = %q", c.args, result)
+		}
+	}
+	for _, c := range []struct {
+		args    []string
+		message string
+	}{
+		{[]string{"unknown"}, "Received unknown function invocation:unknown"},
+		{[]string{"echo"}, "Incorrect number of arguments. Expecting at least 1"},
+		{[]string{"echo", "a", "two"}, "count argument is incorrect, expecting an integer"},
+		{[]string{"echo", "a", "2", "{"}, "json argument is incorrect, expecting JSON"},
+		{[]string{"ping", "a"}, "Incorrect number of arguments. Expecting 0"},
+		{[]string{"queryRoles"}, "Incorrect number of arguments. Expecting 1"},
+	} {
+		if message := invokeFails(t, stub, "tx", c.args...); message != c.message {
+			t.Errorf("%v: %s", c.args, message)
+		}
+	}
+}
+
+func TestRouterChecksTheRoles(t *testing.T) {
+	stub := newTestStub(t)
+	if message := invokeFails(t, stub, "tx1", "issue", "pts"); message != "the caller does not have the role issuer" {
+		t.Fatal(message)
+	}
+	invoke(t, stub, "tx2", "grantRole", "issuer", "AdminMSP", "", "", "")
+	invoke(t, stub, "tx3", "issue", "pts")
+	// the arguments are checked before the roles
+	if message := invokeFails(t, stub, "tx4", "issue"); !strings.HasPrefix(message, "Incorrect number") {
+		t.Fatal(message)
+	}
+}
+
+func TestRouterFunctions(t *testing.T) {
+	functions := new(testChaincode).router().Functions()
+	if len(functions) != 10 || functions[0] != "approveAdmin(proposalId)" || functions[1] != "echo(word, [count], json...)" {
+		t.Fatal(functions)
+	}
+	for _, args := range [][]Arg{
+		{Variadic(StringArg("a")), StringArg("b")},
+		{Optional(StringArg("a")), StringArg("b")},
+	} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("registered %v", args)
+				}
+			}()
+			NewRouter().Handle("f", echo, args...)
+		}()
+	}
+}
@@ -0,0 +1,38 @@
+package common
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+// GetJSON decodes the value of key into v. It returns false, leaving v
+// untouched, when the key does not exist.
+func GetJSON(stub shim.ChaincodeStubInterface, key string, v interface{}) (bool, error) {
+	valueAsBytes, err := stub.GetState(key)
+	if err != nil {
+		return false, fmt.Errorf("Failed to get state of %s: %s", key, err)
+	}
+	if valueAsBytes == nil {
+		return false, nil
+	}
+	err = json.Unmarshal(valueAsBytes, v)
+	if err != nil {
+		return false, fmt.Errorf("Failed decoding state of %s: %s", key, err)
+	}
+	return true, nil
+}
+
+// PutJSON stores v encoded in JSON under key
+func PutJSON(stub shim.ChaincodeStubInterface, key string, v interface{}) error {
+	valueAsBytes, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Errorf("Failed encoding state of %s: %s", key, err)
+	}
+	err = stub.PutState(key, valueAsBytes)
+	if err != nil {
+		return fmt.Errorf("Failed to store state of %s: %s", key, err)
+	}
+	return nil
+}
@@ -0,0 +1,344 @@
+// Package decimal provides the fixed-point amount used for points and
+// currency balances by the chaincodes.
+//
+// An Amount is an int64 count of units of 10^-scale. Arithmetic is exact and
+// checked: an operation which would overflow returns an error instead of
+// wrapping. Amounts are encoded in JSON as a string with exactly scale
+// fractional digits, e.g. "12.50" for scale 2, and plain JSON numbers are
+// accepted when decoding so that balances stored as integers stay readable.
+package decimal
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"strconv"
+	"strings"
+)
+
+// MaxScale is the largest number of fractional digits of an amount
+const MaxScale = 18
+
+// Rounding selects how a result which is not exact at the target scale is
+// rounded
+type Rounding int
+
+const (
+	// RoundDown truncates towards zero
+	RoundDown Rounding = iota
+	// RoundUp rounds away from zero
+	RoundUp
+	// RoundHalfUp rounds to the nearest, half away from zero
+	RoundHalfUp
+)
+
+var (
+	// ErrOverflow is returned when a result does not fit in an amount
+	ErrOverflow = errors.New("amount overflow")
+	// ErrScale is returned for a scale out of [0, MaxScale]
+	ErrScale = fmt.Errorf("scale must be between 0 and %d", MaxScale)
+	// ErrPrecision is returned when an amount has more fractional digits
+	// than its scale allows
+	ErrPrecision = errors.New("amount has too many fractional digits")
+)
+
+var powers [MaxScale + 1]int64
+
+func init() {
+	powers[0] = 1
+	for i := 1; i <= MaxScale; i++ {
+		powers[i] = powers[i-1] * 10
+	}
+}
+
+// Amount is a fixed-point decimal. The zero value is 0 at scale 0.
+type Amount struct {
+	units int64
+	scale uint8
+}
+
+// New returns the amount units * 10^-scale
+func New(units int64, scale int) (Amount, error) {
+	if scale < 0 || scale > MaxScale {
+		return Amount{}, ErrScale
+	}
+	return Amount{units, uint8(scale)}, nil
+}
+
+// Zero returns 0 at the scale
+func Zero(scale int) Amount {
+	amount, err := New(0, scale)
+	if err != nil {
+		return Amount{}
+	}
+	return amount
+}
+
+// FromInt returns the integer value as an amount at the scale
+func FromInt(value int64, scale int) (Amount, error) {
+	if scale < 0 || scale > MaxScale {
+		return Amount{}, ErrScale
+	}
+	units, ok := mul64(value, powers[scale])
+	if !ok {
+		return Amount{}, ErrOverflow
+	}
+	return Amount{units, uint8(scale)}, nil
+}
+
+// Parse reads a decimal string such as "-12.5" at the scale. More fractional
+// digits than the scale, or a value out of range, is an error.
+func Parse(s string, scale int) (Amount, error) {
+	if scale < 0 || scale > MaxScale {
+		return Amount{}, ErrScale
+	}
+	digits, fraction, err := split(s)
+	if err != nil {
+		return Amount{}, err
+	}
+	if len(fraction) > scale {
+		trimmed := strings.TrimRight(fraction, "0")
+		if len(trimmed) > scale {
+			return Amount{}, ErrPrecision
+		}
+		fraction = trimmed
+	}
+	fraction += strings.Repeat("0", scale-len(fraction))
+	units, err := strconv.ParseInt(digits+fraction, 10, 64)
+	if err != nil {
+		if numErr, ok := err.(*strconv.NumError); ok && numErr.Err == strconv.ErrRange {
+			return Amount{}, ErrOverflow
+		}
+		return Amount{}, fmt.Errorf("invalid amount %q", s)
+	}
+	return Amount{units, uint8(scale)}, nil
+}
+
+// split checks the syntax of a decimal string and returns its sign and
+// integer digits, and its fractional digits
+func split(s string) (string, string, error) {
+	invalid := fmt.Errorf("invalid amount %q", s)
+	body := s
+	sign := ""
+	if strings.HasPrefix(body, "-") || strings.HasPrefix(body, "+") {
+		if body[0] == '-' {
+			sign = "-"
+		}
+		body = body[1:]
+	}
+	integer, fraction := body, ""
+	if dot := strings.IndexByte(body, '.'); dot >= 0 {
+		integer, fraction = body[:dot], body[dot+1:]
+		if fraction == "" {
+			return "", "", invalid
+		}
+	}
+	if integer == "" {
+		return "", "", invalid
+	}
+	for _, c := range integer + fraction {
+		if c < '0' || c > '9' {
+			return "", "", invalid
+		}
+	}
+	return sign + integer, fraction, nil
+}
+
+// Scale is the number of fractional digits of the amount
+func (a Amount) Scale() int {
+	return int(a.scale)
+}
+
+// Units is the amount in units of 10^-scale
+func (a Amount) Units() int64 {
+	return a.units
+}
+
+// Sign returns -1, 0 or 1
+func (a Amount) Sign() int {
+	switch {
+	case a.units < 0:
+		return -1
+	case a.units > 0:
+		return 1
+	}
+	return 0
+}
+
+// IsZero reports whether the amount is 0
+func (a Amount) IsZero() bool {
+	return a.units == 0
+}
+
+// Rescale returns the same value at another scale, failing when the value is
+// not exact at that scale
+func (a Amount) Rescale(scale int) (Amount, error) {
+	if scale < 0 || scale > MaxScale {
+		return Amount{}, ErrScale
+	}
+	if scale >= int(a.scale) {
+		units, ok := mul64(a.units, powers[scale-int(a.scale)])
+		if !ok {
+			return Amount{}, ErrOverflow
+		}
+		return Amount{units, uint8(scale)}, nil
+	}
+	divisor := powers[int(a.scale)-scale]
+	if a.units%divisor != 0 {
+		return Amount{}, ErrPrecision
+	}
+	return Amount{a.units / divisor, uint8(scale)}, nil
+}
+
+// align brings both amounts to the larger scale
+func align(a, b Amount) (Amount, Amount, error) {
+	var err error
+	if a.scale < b.scale {
+		a, err = a.Rescale(int(b.scale))
+	} else if b.scale < a.scale {
+		b, err = b.Rescale(int(a.scale))
+	}
+	return a, b, err
+}
+
+// Add returns a + b at the larger scale of both
+func (a Amount) Add(b Amount) (Amount, error) {
+	a, b, err := align(a, b)
+	if err != nil {
+		return Amount{}, err
+	}
+	if (b.units > 0 && a.units > math.MaxInt64-b.units) || (b.units < 0 && a.units < math.MinInt64-b.units) {
+		return Amount{}, ErrOverflow
+	}
+	return Amount{a.units + b.units, a.scale}, nil
+}
+
+// Sub returns a - b at the larger scale of both
+func (a Amount) Sub(b Amount) (Amount, error) {
+	a, b, err := align(a, b)
+	if err != nil {
+		return Amount{}, err
+	}
+	if (b.units < 0 && a.units > math.MaxInt64+b.units) || (b.units > 0 && a.units < math.MinInt64+b.units) {
+		return Amount{}, ErrOverflow
+	}
+	return Amount{a.units - b.units, a.scale}, nil
+}
+
+// Cmp returns -1, 0 or 1 as a is less than, equal to or greater than b
+func (a Amount) Cmp(b Amount) int {
+	x := new(big.Int).Mul(big.NewInt(a.units), big.NewInt(powers[MaxScale-int(a.scale)]))
+	y := new(big.Int).Mul(big.NewInt(b.units), big.NewInt(powers[MaxScale-int(b.scale)]))
+	return x.Cmp(y)
+}
+
+// MulDiv returns a * numerator / denominator at the scale, rounded
+func (a Amount) MulDiv(numerator, denominator int64, scale int, rounding Rounding) (Amount, error) {
+	if scale < 0 || scale > MaxScale {
+		return Amount{}, ErrScale
+	}
+	if denominator == 0 {
+		return Amount{}, errors.New("division by zero")
+	}
+	// a.units * 10^(scale - a.scale) * numerator / denominator
+	num := new(big.Int).Mul(big.NewInt(a.units), big.NewInt(numerator))
+	den := big.NewInt(denominator)
+	if scale >= int(a.scale) {
+		num.Mul(num, big.NewInt(powers[scale-int(a.scale)]))
+	} else {
+		den.Mul(den, big.NewInt(powers[int(a.scale)-scale]))
+	}
+	if den.Sign() < 0 {
+		num.Neg(num)
+		den.Neg(den)
+	}
+	quotient, remainder := new(big.Int).QuoRem(num, den, new(big.Int))
+	if remainder.Sign() != 0 {
+		away := false
+		switch rounding {
+		case RoundUp:
+			away = true
+		case RoundHalfUp:
+			twice := new(big.Int).Abs(remainder)
+			away = twice.Mul(twice, big.NewInt(2)).Cmp(den) >= 0
+		}
+		if away {
+			quotient.Add(quotient, big.NewInt(int64(remainder.Sign())))
+		}
+	}
+	if !quotient.IsInt64() {
+		return Amount{}, ErrOverflow
+	}
+	return Amount{quotient.Int64(), uint8(scale)}, nil
+}
+
+// String returns the canonical form, with exactly scale fractional digits
+func (a Amount) String() string {
+	units := a.units
+	sign := ""
+	if units < 0 {
+		sign = "-"
+	}
+	digits := strconv.FormatUint(abs(units), 10)
+	if a.scale == 0 {
+		return sign + digits
+	}
+	scale := int(a.scale)
+	if len(digits) <= scale {
+		digits = strings.Repeat("0", scale-len(digits)+1) + digits
+	}
+	return sign + digits[:len(digits)-scale] + "." + digits[len(digits)-scale:]
+}
+
+// MarshalJSON encodes the amount as its canonical string
+func (a Amount) MarshalJSON() ([]byte, error) {
+	return json.Marshal(a.String())
+}
+
+// UnmarshalJSON decodes a string or a number, the scale being the number of
+// fractional digits
+func (a *Amount) UnmarshalJSON(data []byte) error {
+	s := string(bytes.TrimSpace(data))
+	if s == "null" {
+		return nil
+	}
+	if strings.HasPrefix(s, "\"") {
+		if err := json.Unmarshal(data, &s); err != nil {
+			return err
+		}
+	}
+	_, fraction, err := split(s)
+	if err != nil {
+		return err
+	}
+	if len(fraction) > MaxScale {
+		return ErrPrecision
+	}
+	amount, err := Parse(s, len(fraction))
+	if err != nil {
+		return err
+	}
+	*a = amount
+	return nil
+}
+
+func abs(v int64) uint64 {
+	if v < 0 {
+		return uint64(-(v + 1)) + 1
+	}
+	return uint64(v)
+}
+
+func mul64(a, b int64) (int64, bool) {
+	if a == 0 || b == 0 {
+		return 0, true
+	}
+	c := a * b
+	if c/b != a || (a == -1 && b == math.MinInt64) || (b == -1 && a == math.MinInt64) {
+		return 0, false
+	}
+	return c, true
+}
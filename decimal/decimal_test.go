@@ -0,0 +1,136 @@
+package decimal
+
+import (
+	"encoding/json"
+	"math"
+	"testing"
+)
+
+func mustParse(t *testing.T, s string, scale int) Amount {
+	t.Helper()
+	amount, err := Parse(s, scale)
+	if err != nil {
+		t.Fatalf("Parse(%q, %d): %s", s, scale, err)
+	}
+	return amount
+}
+
+func TestParse(t *testing.T) {
+	for _, c := range []struct {
+		s      string
+		scale  int
+		result string
+		err    bool
+	}{
+		{"12.5", 2, "12.50", false},
+		{"-0.07", 2, "-0.07", false},
+		{"+3", 0, "3", false},
+		{"1.230", 2, "1.23", false},
+		{"1.234", 2, "", true},
+		{"1.", 2, "", true},
+		{".5", 2, "", true},
+		{"1e3", 2, "", true},
+		{"9223372036854775807", 0, "9223372036854775807", false},
+		{"9223372036854775808", 0, "", true},
+		{"92233720368547758.08", 2, "", true},
+		{"1", MaxScale + 1, "", true},
+	} {
+		amount, err := Parse(c.s, c.scale)
+		if c.err != (err != nil) || (err == nil && amount.String() != c.result) {
+			t.Errorf("Parse(%q, %d) = %s, %v", c.s, c.scale, amount, err)
+		}
+	}
+}
+
+func TestArithmeticOverflows(t *testing.T) {
+	max, _ := New(math.MaxInt64, 0)
+	one := mustParse(t, "1", 0)
+	if _, err := max.Add(one); err != ErrOverflow {
+		t.Errorf("max + 1: %v", err)
+	}
+	min, _ := New(math.MinInt64, 0)
+	if _, err := min.Sub(one); err != ErrOverflow {
+		t.Errorf("min - 1: %v", err)
+	}
+	if _, err := max.Rescale(1); err != ErrOverflow {
+		t.Errorf("rescale max: %v", err)
+	}
+	if _, err := FromInt(math.MaxInt64/10+1, 1); err != ErrOverflow {
+		t.Errorf("FromInt: %v", err)
+	}
+	if _, err := max.MulDiv(2, 1, 0, RoundDown); err != ErrOverflow {
+		t.Errorf("max * 2: %v", err)
+	}
+	// the intermediate product does not overflow
+	if half, err := max.MulDiv(2, 4, 0, RoundDown); err != nil || half.Units() != math.MaxInt64/2 {
+		t.Errorf("max * 2 / 4 = %s, %v", half, err)
+	}
+}
+
+func TestAddAlignsTheScales(t *testing.T) {
+	sum, err := mustParse(t, "1.5", 1).Add(mustParse(t, "0.25", 2))
+	if err != nil || sum.String() != "1.75" || sum.Scale() != 2 {
+		t.Fatalf("1.5 + 0.25 = %s, %v", sum, err)
+	}
+	if mustParse(t, "1.50", 2).Cmp(mustParse(t, "1.5", 1)) != 0 {
+		t.Fatal("1.50 != 1.5")
+	}
+	difference, err := mustParse(t, "1", 0).Sub(mustParse(t, "1.01", 2))
+	if err != nil || difference.String() != "-0.01" || difference.Sign() != -1 {
+		t.Fatalf("1 - 1.01 = %s, %v", difference, err)
+	}
+}
+
+func TestRescale(t *testing.T) {
+	amount := mustParse(t, "1.50", 2)
+	rescaled, err := amount.Rescale(1)
+	if err != nil || rescaled.String() != "1.5" || rescaled.Units() != 15 {
+		t.Fatalf("rescale 1.50 to 1 = %s, %v", rescaled, err)
+	}
+	if rescaled, err = amount.Rescale(4); err != nil || rescaled.String() != "1.5000" {
+		t.Fatalf("rescale 1.50 to 4 = %s, %v", rescaled, err)
+	}
+	if _, err = amount.Rescale(0); err != ErrPrecision {
+		t.Fatalf("rescale 1.50 to 0: %v", err)
+	}
+	if _, err = amount.Rescale(-1); err != ErrScale {
+		t.Fatalf("rescale 1.50 to -1: %v", err)
+	}
+}
+
+func TestMulDivRounding(t *testing.T) {
+	amount := mustParse(t, "10", 0)
+	for _, c := range []struct {
+		rounding Rounding
+		result   string
+	}{
+		{RoundDown, "3.33"},
+		{RoundUp, "3.34"},
+		{RoundHalfUp, "3.33"},
+	} {
+		if result, err := amount.MulDiv(1, 3, 2, c.rounding); err != nil || result.String() != c.result {
+			t.Errorf("10 / 3 rounding %d = %s, %v", c.rounding, result, err)
+		}
+	}
+	if result, _ := mustParse(t, "-0.05", 2).MulDiv(1, 10, 2, RoundHalfUp); result.String() != "-0.01" {
+		t.Errorf("-0.05 / 10 = %s", result)
+	}
+	if _, err := amount.MulDiv(1, 0, 2, RoundDown); err == nil {
+		t.Error("division by zero")
+	}
+}
+
+func TestJSON(t *testing.T) {
+	var amounts []Amount
+	if err := json.Unmarshal([]byte(`["1.25", 7, "-0.5", null]`), &amounts); err != nil {
+		t.Fatal(err)
+	}
+	encoded, err := json.Marshal(amounts)
+	if err != nil || string(encoded) != `["1.25","7","-0.5","0"]` {
+		t.Fatalf("%s, %v", encoded, err)
+	}
+	var amount Amount
+	if err := json.Unmarshal([]byte(`"1.x"`), &amount); err == nil {
+		t.Fatal("decoded 1.x")
+	}
+}
@@ -24,8 +24,8 @@ package main
 
 import (
 	"fmt"
-	"strconv"
 
+	"github.com/chaincode/decimal"
 	"github.com/hyperledger/fabric/core/chaincode/shim"
 	pb "github.com/hyperledger/fabric/protos/peer"
 )
@@ -37,8 +37,8 @@ type SimpleChaincode struct {
 func (t *SimpleChaincode) Init(stub shim.ChaincodeStubInterface) pb.Response {
 	fmt.Println("ex02 Init")
 	_, args := stub.GetFunctionAndParameters()
-	var A, B string    // Entities
-	var Aval, Bval int // Asset holdings
+	var A, B string               // Entities
+	var Aval, Bval decimal.Amount // Asset holdings
 	var err error
 
 	if len(args) != 4 {
@@ -47,24 +47,24 @@ func (t *SimpleChaincode) Init(stub shim.ChaincodeStubInterface) pb.Response {
 
 	// Initialize the chaincode
 	A = args[0]
-	Aval, err = strconv.Atoi(args[1])
-	if err != nil {
-		return shim.Error("Expecting integer value for asset holding")
+	Aval, err = decimal.Parse(args[1], 0)
+	if err != nil || Aval.Sign() < 0 {
+		return shim.Error("Expecting non negative integer value for asset holding")
 	}
 	B = args[2]
-	Bval, err = strconv.Atoi(args[3])
-	if err != nil {
-		return shim.Error("Expecting integer value for asset holding")
+	Bval, err = decimal.Parse(args[3], 0)
+	if err != nil || Bval.Sign() < 0 {
+		return shim.Error("Expecting non negative integer value for asset holding")
 	}
-	fmt.Printf("Aval = %d, Bval = %d\n", Aval, Bval)
+	fmt.Printf("Aval = %s, Bval = %s\n", Aval, Bval)
 
 	// Write the state to the ledger
-	err = stub.PutState(A, []byte(strconv.Itoa(Aval)))
+	err = stub.PutState(A, []byte(Aval.String()))
 	if err != nil {
 		return shim.Error(err.Error())
 	}
 
-	err = stub.PutState(B, []byte(strconv.Itoa(Bval)))
+	err = stub.PutState(B, []byte(Bval.String()))
 	if err != nil {
 		return shim.Error(err.Error())
 	}
@@ -91,9 +91,9 @@ func (t *SimpleChaincode) Invoke(stub shim.ChaincodeStubInterface) pb.Response {
 
 // Transaction makes payment of X units from A to B
 func (t *SimpleChaincode) invoke(stub shim.ChaincodeStubInterface, args []string) pb.Response {
-	var A, B string    // Entities
-	var Aval, Bval int // Asset holdings
-	var X int          // Transaction value
+	var A, B string               // Entities
+	var Aval, Bval decimal.Amount // Asset holdings
+	var X decimal.Amount          // Transaction value
 	var err error
 
 	if len(args) != 3 {
@@ -112,7 +112,10 @@ func (t *SimpleChaincode) invoke(stub shim.ChaincodeStubInterface, args []string
 	if Avalbytes == nil {
 		return shim.Error("Entity not found")
 	}
-	Aval, _ = strconv.Atoi(string(Avalbytes))
+	Aval, err = decimal.Parse(string(Avalbytes), 0)
+	if err != nil {
+		return shim.Error("Failed to decode state")
+	}
 
 	Bvalbytes, err := stub.GetState(B)
 	if err != nil {
@@ -121,24 +124,36 @@ func (t *SimpleChaincode) invoke(stub shim.ChaincodeStubInterface, args []string
 	if Bvalbytes == nil {
 		return shim.Error("Entity not found")
 	}
-	Bval, _ = strconv.Atoi(string(Bvalbytes))
+	Bval, err = decimal.Parse(string(Bvalbytes), 0)
+	if err != nil {
+		return shim.Error("Failed to decode state")
+	}
 
 	// Perform the execution
-	X, err = strconv.Atoi(args[2])
+	X, err = decimal.Parse(args[2], 0)
+	if err != nil || X.Sign() < 0 {
+		return shim.Error("Invalid transaction amount, expecting a non negative integer value")
+	}
+	Aval, err = Aval.Sub(X)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if Aval.Sign() < 0 {
+		return shim.Error("Insufficient asset holding")
+	}
+	Bval, err = Bval.Add(X)
 	if err != nil {
-		return shim.Error("Invalid transaction amount, expecting a integer value")
+		return shim.Error(err.Error())
 	}
-	Aval = Aval - X
-	Bval = Bval + X
-	fmt.Printf("Aval = %d, Bval = %d\n", Aval, Bval)
+	fmt.Printf("Aval = %s, Bval = %s\n", Aval, Bval)
 
 	// Write the state back to the ledger
-	err = stub.PutState(A, []byte(strconv.Itoa(Aval)))
+	err = stub.PutState(A, []byte(Aval.String()))
 	if err != nil {
 		return shim.Error(err.Error())
 	}
 
-	err = stub.PutState(B, []byte(strconv.Itoa(Bval)))
+	err = stub.PutState(B, []byte(Bval.String()))
 	if err != nil {
 		return shim.Error(err.Error())
 	}
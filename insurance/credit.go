@@ -0,0 +1,423 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+	"unicode/utf8"
+
+	"github.com/chaincode/common"
+	"github.com/chaincode/decimal"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+// creditIndex lists the policies credited by a bank: bank~company~id
+const creditIndex = "bank~company~id"
+
+// Exposure is the sum of the credit lines of a bank and of their used part
+type Exposure struct {
+	Bank    string         `json:"bank"`
+	Limit   decimal.Amount `json:"limit"`
+	Used    decimal.Amount `json:"used"`
+	Credits []Credit       `json:"credits"`
+}
+
+// getCredits reads the credits of a policy
+func getCredits(stub shim.ChaincodeStubInterface, company, id string) ([]Credit, error) {
+	var credits []Credit
+	_, err := common.GetJSON(stub, creditPrefix+company+id, &credits)
+	if err != nil {
+		return nil, err
+	}
+	return credits, nil
+}
+
+// putCredits stores the credits of a policy and keeps the credit index of
+// bank up to date
+func putCredits(stub shim.ChaincodeStubInterface, company, id, bank string, credits []Credit) error {
+	err := common.PutJSON(stub, creditPrefix+company+id, credits)
+	if err != nil {
+		return err
+	}
+
+	indexKey, err := stub.CreateCompositeKey(creditIndex, []string{bank, company, id})
+	if err != nil {
+		return err
+	}
+	if findCredit(credits, bank) < 0 {
+		return stub.DelState(indexKey)
+	}
+	return stub.PutState(indexKey, []byte{0x00})
+}
+
+// indexCredits adds the credits stored before the credit index to the index,
+// and returns the number of credits indexed
+func indexCredits(stub shim.ChaincodeStubInterface) (int, error) {
+	creditsIterator, err := stub.GetStateByRange(creditPrefix, creditPrefix+string(utf8.MaxRune))
+	if err != nil {
+		return 0, err
+	}
+	defer creditsIterator.Close()
+
+	indexed := 0
+	for creditsIterator.HasNext() {
+		_, value, err := creditsIterator.Next()
+		if err != nil {
+			return indexed, err
+		}
+		var credits []Credit
+		err = json.Unmarshal(value, &credits)
+		if err != nil {
+			return indexed, err
+		}
+		for _, credit := range credits {
+			indexKey, err := stub.CreateCompositeKey(creditIndex, []string{credit.Bank, credit.Company, credit.Id})
+			if err != nil {
+				return indexed, err
+			}
+			indexValue, err := stub.GetState(indexKey)
+			if err != nil {
+				return indexed, err
+			}
+			if indexValue != nil {
+				continue
+			}
+			err = stub.PutState(indexKey, []byte{0x00})
+			if err != nil {
+				return indexed, err
+			}
+			indexed++
+		}
+	}
+	return indexed, nil
+}
+
+// findCredit returns the index of the credit of bank, -1 when there is none
+func findCredit(credits []Credit, bank string) int {
+	for i, credit := range credits {
+		if credit.Bank == bank {
+			return i
+		}
+	}
+	return -1
+}
+
+// txTime returns the time of the transaction
+func txTime(stub shim.ChaincodeStubInterface) (time.Time, error) {
+	txTimestamp, err := stub.GetTxTimestamp()
+	if err != nil {
+		return time.Time{}, fmt.Errorf("Failed getting transaction timestamp: %s", err)
+	}
+	return time.Unix(txTimestamp.Seconds, int64(txTimestamp.Nanos)), nil
+}
+
+// txDate returns the date of the transaction as yyyymmdd, the format of
+// Credit.Expire
+func txDate(stub shim.ChaincodeStubInterface) (int, error) {
+	t, err := txTime(stub)
+	if err != nil {
+		return 0, err
+	}
+	return dateOf(t), nil
+}
+
+// checkExpire fails when the credit expired before the date of the
+// transaction
+func checkExpire(stub shim.ChaincodeStubInterface, credit *Credit) error {
+	if credit.Expire == 0 {
+		return nil
+	}
+	date, err := txDate(stub)
+	if err != nil {
+		return err
+	}
+	if credit.Expire < date {
+		return fmt.Errorf("the credit of the bank is expired since %d", credit.Expire)
+	}
+	return nil
+}
+
+// reserveCredit reserves amount on the credit of bank on a policy. A zero
+// amount reserves the whole available part of the credit. It returns the
+// amount reserved.
+func reserveCredit(stub shim.ChaincodeStubInterface, company, id, bank string, amount decimal.Amount) (decimal.Amount, error) {
+	credits, err := getCredits(stub, company, id)
+	if err != nil {
+		return amount, err
+	}
+	i := findCredit(credits, bank)
+	if i < 0 {
+		return amount, fmt.Errorf("the bank did not credit this insurance")
+	}
+	err = checkExpire(stub, &credits[i])
+	if err != nil {
+		return amount, err
+	}
+	available, err := credits[i].Credit.Sub(credits[i].Used)
+	if err != nil {
+		return amount, err
+	}
+	if amount.IsZero() {
+		amount = available
+	}
+	if amount.Sign() <= 0 || amount.Cmp(available) > 0 {
+		return amount, fmt.Errorf("the credit limit is exceeded, available %s", available)
+	}
+	credits[i].Used, err = credits[i].Used.Add(amount)
+	if err != nil {
+		return amount, err
+	}
+	return amount, putCredits(stub, company, id, bank, credits)
+}
+
+// releaseCredit releases the amount reserved by a pledge on the credit of its
+// bank. The credit may have been revoked.
+func releaseCredit(stub shim.ChaincodeStubInterface, pledge *Pledge) error {
+	if pledge.Amount.IsZero() {
+		return nil
+	}
+	credits, err := getCredits(stub, pledge.Company, pledge.Id)
+	if err != nil {
+		return err
+	}
+	i := findCredit(credits, pledge.Bank)
+	if i < 0 {
+		return nil
+	}
+	if credits[i].Used.Cmp(pledge.Amount) < 0 {
+		return fmt.Errorf("the credit used %s is less than the pledge amount %s", credits[i].Used, pledge.Amount)
+	}
+	credits[i].Used, err = credits[i].Used.Sub(pledge.Amount)
+	if err != nil {
+		return err
+	}
+	return putCredits(stub, pledge.Company, pledge.Id, pledge.Bank, credits)
+}
+
+// parseCredit reads the expire, credit and rate arguments of credit and
+// amendCredit into credit
+func parseCredit(args []string, credit *Credit) error {
+	expire, err := strconv.Atoi(args[0])
+	if err != nil {
+		return fmt.Errorf("expire argument is incorrect")
+	}
+	limit, err := parseCurrency(args[1])
+	if err != nil {
+		return fmt.Errorf("credit argument is incorrect: %s", err)
+	}
+	rate, err := strconv.Atoi(args[2])
+	if err != nil {
+		return fmt.Errorf("rate argument is incorrect")
+	}
+	credit.Expire = expire
+	credit.Credit = limit
+	credit.Rate = rate
+	return nil
+}
+
+// getCallerBank returns the calling bank, failing when the caller is not a
+// registered bank
+func getCallerBank(stub shim.ChaincodeStubInterface) (string, error) {
+	bankId, err := getCaller(stub)
+	if err != nil {
+		return "", err
+	}
+	if !isInsuranceOrBank(bankPrefix+bankId, stub) {
+		return "", fmt.Errorf("caller is not bank")
+	}
+	return bankId, nil
+}
+
+// credit offers a credit line of the calling bank on a policy
+// args: company, id, expire, credit, rate
+func (t *InsuranceChaincode) credit(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	fmt.Println("start credit")
+
+	bankId, err := getCallerBank(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	company := args[0]
+	id := args[1]
+	credit := Credit{Id: id, Company: company, Bank: bankId, Used: decimal.Zero(currencyScale)}
+	err = parseCredit(args[2:], &credit)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	credits, err := getCredits(stub, company, id)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if findCredit(credits, bankId) >= 0 {
+		return shim.Error("already credit this insurance")
+	}
+	credits = append(credits, credit)
+
+	err = putCredits(stub, company, id, bankId, credits)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	err = common.SetEvent(stub, EventPolicyCredited, credit)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	return shim.Success(nil)
+}
+
+// amendCredit changes the expire, the limit and the rate of the credit line of
+// the calling bank on a policy. The limit can not be less than the used part.
+// args: company, id, expire, credit, rate
+func (t *InsuranceChaincode) amendCredit(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	bankId, err := getCallerBank(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	company := args[0]
+	id := args[1]
+
+	credits, err := getCredits(stub, company, id)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	i := findCredit(credits, bankId)
+	if i < 0 {
+		return shim.Error("the bank did not credit this insurance")
+	}
+	credit := credits[i]
+	err = parseCredit(args[2:], &credit)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if credit.Credit.Cmp(credit.Used) < 0 {
+		return shim.Error(fmt.Sprintf("the credit is used by a pledge, used %s", credit.Used))
+	}
+	credits[i] = credit
+
+	err = putCredits(stub, company, id, bankId, credits)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	err = common.SetEvent(stub, EventCreditAmended, credit)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	return shim.Success(nil)
+}
+
+// revokeCredit withdraws the credit line of the calling bank on a policy,
+// which must not be used by a pledge
+// args: company, id
+func (t *InsuranceChaincode) revokeCredit(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	bankId, err := getCallerBank(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	company := args[0]
+	id := args[1]
+
+	credits, err := getCredits(stub, company, id)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	i := findCredit(credits, bankId)
+	if i < 0 {
+		return shim.Error("the bank did not credit this insurance")
+	}
+	credit := credits[i]
+	if !credit.Used.IsZero() {
+		return shim.Error(fmt.Sprintf("the credit is used by a pledge, used %s", credit.Used))
+	}
+	credits = append(credits[:i], credits[i+1:]...)
+
+	err = putCredits(stub, company, id, bankId, credits)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	err = common.SetEvent(stub, EventCreditRevoked, credit)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	return shim.Success(nil)
+}
+
+// getExposure sums the credits of bank listed by the credit index
+func getExposure(stub shim.ChaincodeStubInterface, bank string) (*Exposure, error) {
+	creditsIterator, err := stub.GetStateByPartialCompositeKey(creditIndex, []string{bank})
+	if err != nil {
+		return nil, err
+	}
+	defer creditsIterator.Close()
+
+	exposure := &Exposure{Bank: bank, Limit: decimal.Zero(currencyScale), Used: decimal.Zero(currencyScale), Credits: []Credit{}}
+	for creditsIterator.HasNext() {
+		indexKey, _, err := creditsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+		_, keyParts, err := stub.SplitCompositeKey(indexKey)
+		if err != nil {
+			return nil, err
+		}
+		credits, err := getCredits(stub, keyParts[1], keyParts[2])
+		if err != nil {
+			return nil, err
+		}
+		i := findCredit(credits, bank)
+		if i < 0 {
+			continue
+		}
+		exposure.Limit, err = exposure.Limit.Add(credits[i].Credit)
+		if err != nil {
+			return nil, err
+		}
+		exposure.Used, err = exposure.Used.Add(credits[i].Used)
+		if err != nil {
+			return nil, err
+		}
+		exposure.Credits = append(exposure.Credits, credits[i])
+	}
+	return exposure, nil
+}
+
+// queryExposure returns the Exposure of a bank, or of every bank when the
+// bank is left out
+// args: [bank]
+func (t *InsuranceChaincode) queryExposure(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	var banks []string
+	if len(args) > 0 {
+		banks = []string{args[0]}
+	} else {
+		banksIterator, err := stub.GetStateByRange(bankPrefix, bankPrefix+string(utf8.MaxRune))
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		defer banksIterator.Close()
+		for banksIterator.HasNext() {
+			key, _, err := banksIterator.Next()
+			if err != nil {
+				return shim.Error(err.Error())
+			}
+			banks = append(banks, key[len(bankPrefix):])
+		}
+	}
+
+	exposures := []*Exposure{}
+	for _, bank := range banks {
+		exposure, err := getExposure(stub, bank)
+		if err != nil {
+			return shim.Error("query exposure failed: " + err.Error())
+		}
+		exposures = append(exposures, exposure)
+	}
+	exposuresJSONasBytes, err := json.Marshal(exposures)
+	if err != nil {
+		return shim.Error("failed marshal exposures: " + err.Error())
+	}
+	return shim.Success(exposuresJSONasBytes)
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/chaincode/decimal"
+	"github.com/chaincode/mockstub"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+func queryExposure(t *testing.T, stub *mockstub.MockStub, bank string) *Exposure {
+	t.Helper()
+	var exposures []*Exposure
+	if err := json.Unmarshal(invoke(t, stub, "exposure", "exposure", bank), &exposures); err != nil {
+		t.Fatal(err)
+	}
+	if len(exposures) != 1 {
+		t.Fatalf("exposures %+v", exposures)
+	}
+	return exposures[0]
+}
+
+func TestExposureOfTheCreditsBeforeTheUpgrade(t *testing.T) {
+	stub := newInsuranceStub(t)
+	invoke(t, stub, "tx1", "assign", id("alice"), "p1", "100")
+	as(t, stub, "bank", "tx2", "credit", idIns, "p1", "0", "500", "500")
+
+	// a credit stored before the credit index, with the amount as a number
+	stub.State[creditPrefix+idIns+"p2"] = []byte(`[{"id":"p2","company":"` + idIns + `","bank":"` + idBank + `","expire":20180101,"credit":300,"rate":400}]`)
+	if exposure := queryExposure(t, stub, idBank); len(exposure.Credits) != 1 {
+		t.Fatalf("exposure %+v", exposure)
+	}
+
+	stub.SetCreator("AdminMSP", []byte("upgrader"))
+	if response := stub.MockInit("upgrade", []string{"init"}); response.Status != shim.OK {
+		t.Fatal(response.Message)
+	}
+	exposure := queryExposure(t, stub, idBank)
+	if len(exposure.Credits) != 2 || exposure.Limit.String() != "800.00" || exposure.Used.String() != "0.00" {
+		t.Fatalf("exposure %+v", exposure)
+	}
+}
+
+func TestReleaseOfAnInconsistentCreditFails(t *testing.T) {
+	stub := newInsuranceStub(t)
+	invoke(t, stub, "tx1", "assign", id("alice"), "p1", "100")
+	as(t, stub, "bank", "tx2", "credit", idIns, "p1", "0", "500", "500")
+	as(t, stub, "alice", "tx3", "apply", idIns, "p1", idBank, "200")
+	invoke(t, stub, "tx4", "cancel", idIns, "p1", idBank)
+	if exposure := queryExposure(t, stub, idBank); exposure.Used.String() != "0.00" {
+		t.Fatalf("exposure %+v", exposure)
+	}
+
+	// the used part of the credit no longer covers the pledge
+	invoke(t, stub, "tx5", "apply", idIns, "p1", idBank, "200")
+	credits, err := getCredits(stub, idIns, "p1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	credits[0].Used = decimal.Zero(currencyScale)
+	value, err := json.Marshal(credits)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stub.State[creditPrefix+idIns+"p1"] = value
+	if message := invokeFails(t, stub, "tx6", "cancel", idIns, "p1", idBank); !strings.HasPrefix(message, "the credit used 0.00 is less than the pledge amount 200.00") {
+		t.Fatal(message)
+	}
+}
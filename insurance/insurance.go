@@ -1,536 +1,408 @@
 package main
 
 import (
+	"bytes"
+	"crypto/x509"
 	"errors"
 	"fmt"
-	"strconv"
 
-	"github.com/hyperledger/fabric/core/chaincode/shim"
 	"encoding/base64"
-	"bytes"
 	"encoding/json"
-	"strings"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+
+	"github.com/chaincode/common"
+	"github.com/chaincode/decimal"
 )
 
 const (
 	insurancePrefix = "insurance_"
-	bankPrefix = "bank_"
-	userPrefix = "user_"
-	creditPrefix = "credit_"
-	applyPrefix = "apply_"
-	loanPrefix = "loan_"
-	payPrefix = "pay_"
-	breakPrefix = "break_"
-	confirmPrefix = "confirm_"
-	adminRole = "admin"
+	bankPrefix      = "bank_"
+	userPrefix      = "user_" // the policies of an owner, before policyIndex
+	creditPrefix    = "credit_"
+	applyPrefix     = "apply_"
+	loanPrefix      = "loan_"
+	payPrefix       = "pay_"
+	breakPrefix     = "break_"
+	confirmPrefix   = "confirm_"
+	// currencyScale is the number of fractional digits of policy balances
+	// and credit lines
+	currencyScale = 2
+)
+
+// The types of the events emitted by the functions of the chaincode, each
+// with the payload it carries as the data of a common.Event.
+const (
+	// EventInsurerRegistered is emitted by issue, with the Party
+	EventInsurerRegistered = "InsurerRegistered"
+	// EventBankRegistered is emitted by bank, with the Party
+	EventBankRegistered = "BankRegistered"
+	// EventPolicyAssigned is emitted by assign, with the Policy
+	EventPolicyAssigned = "PolicyAssigned"
+	// EventPolicyStateChanged is emitted by setPolicyState, with the Policy
+	EventPolicyStateChanged = "PolicyStateChanged"
+	// EventTransferRequested is emitted by requestTransfer, with the Transfer
+	EventTransferRequested = "TransferRequested"
+	// EventTransferApproved is emitted by approveTransfer, with the Transfer
+	EventTransferApproved = "TransferApproved"
+	// EventTransferRejected is emitted by rejectTransfer, with the Transfer
+	EventTransferRejected = "TransferRejected"
+	// EventPolicyCredited is emitted by credit, with the Credit
+	EventPolicyCredited = "PolicyCredited"
+	// EventCreditAmended is emitted by amendCredit, with the Credit
+	EventCreditAmended = "CreditAmended"
+	// EventCreditRevoked is emitted by revokeCredit, with the Credit
+	EventCreditRevoked = "CreditRevoked"
+	// EventLoanApplied is emitted by apply, with the Pledge
+	EventLoanApplied = "LoanApplied"
+	// EventLoanApproved is emitted by approve, with the Pledge
+	EventLoanApproved = "LoanApproved"
+	// EventLoanGranted is emitted by loan, with the Pledge
+	EventLoanGranted = "LoanGranted"
+	// EventLoanPaid is emitted by pay, with the Pledge
+	EventLoanPaid = "LoanPaid"
+	// EventPolicyBroken is emitted by break, with the Pledge
+	EventPolicyBroken = "PolicyBroken"
+	// EventLoanConfirmed is emitted by confirm, with the Pledge
+	EventLoanConfirmed = "LoanConfirmed"
+	// EventLoanCancelled is emitted by cancel, with the Pledge
+	EventLoanCancelled = "LoanCancelled"
+	// EventPoliciesMigrated is emitted by migrate, with the PolicyMigration
+	EventPoliciesMigrated = "PoliciesMigrated"
 )
 
+// Party is an insurance company or a bank registered by the admin
+type Party struct {
+	Id     string `json:"id"`
+	Detail string `json:"detail"`
+}
+
+// Credit is the credit line a bank offers on a policy, stored with the other
+// credits of the policy under credit_ + company + id. Used is the part of
+// Credit reserved by the pledge of the policy to the bank. Expire is a date
+// as yyyymmdd, 0 when the credit does not expire. Rate is the annual interest
+// rate of the loans, in basis points.
 type Credit struct {
-	Id      string 	 `json:"id,omitempty"`
-	Company string 	 `json:"company,omitempty"`
-	Bank 	string 	 `json:"bank,omitempty"`
-	Expire  int	 `json:"expire,omitempty"`
-	Credit	int	 `json:"credit,omitempty"`
-	Rate    int	 `json:"rate,omitempty"`
+	Id      string         `json:"id,omitempty"`
+	Company string         `json:"company,omitempty"`
+	Bank    string         `json:"bank,omitempty"`
+	Expire  int            `json:"expire,omitempty"`
+	Credit  decimal.Amount `json:"credit,omitempty"`
+	Rate    int            `json:"rate,omitempty"`
+	Used    decimal.Amount `json:"used,omitempty"`
 }
 
+// Apply is the value of the apply_, loan_, pay_, break_ and confirm_ keys,
+// which recorded the steps of a loan before the pledges
 type Apply struct {
-	Id      string 	 `json:"id,omitempty"`
-	Company string 	 `json:"company,omitempty"`
-	Bank 	string 	 `json:"bank,omitempty"`
+	Id      string `json:"id,omitempty"`
+	Company string `json:"company,omitempty"`
+	Bank    string `json:"bank,omitempty"`
 }
 
+// Policy is a policy assigned to its owner by the company, stored under the
+// owner, the company and its id. State is PolicyActive until the company
+// ends the policy.
 type Policy struct {
-	Owner   string   `json:"owner,omitempty"`
-	Id      string 	 `json:"id,omitempty"`
-	Company string 	 `json:"company,omitempty"`
-	State   int	 `json:"state,omitempty"`
-	Balance int	 `json:"balance,omitempty"`
+	Owner       string         `json:"owner,omitempty"`
+	Id          string         `json:"id,omitempty"`
+	Company     string         `json:"company,omitempty"`
+	State       int            `json:"state,omitempty"`
+	Balance     decimal.Amount `json:"balance,omitempty"`
+	Beneficiary string         `json:"beneficiary,omitempty"`
 }
 
 type PolicyResult struct {
 	Insurance Policy   `json:"insurance,omitempty"`
 	Credits   []Credit `json:"credits,omitempty"`
-	Applied   *Apply   `json:"Applied,omitempty"`
-	Loan      *Apply   `json:"loan,omitempty"`
-	Pay       *Apply   `json:"pay,omitempty"`
-	IsBreak   *Apply   `json:"isBreak,omitempty"`
-	Confirm   *Apply   `json:"confirm,omitempty"`
+	Pledge    *Pledge  `json:"pledge,omitempty"`
+	// Balance is the balance of the loan of the pledge at the date of the
+	// query, nil before the loan is disbursed
+	Balance *Balance `json:"balance,omitempty"`
 }
 
-// SimpleChaincode example simple Chaincode implementation
+// InsuranceChaincode records the policies assigned by the insurance
+// companies, the credit lines the banks offer on them and the loans pledged
+// on the policies.
+//
+// The companies, the banks and the users are identified by the base64 of
+// their certificate, as in the keys insurance_ and bank_ and the policy
+// keys of the state.
 type InsuranceChaincode struct {
 }
 
-func (t *InsuranceChaincode) Init(stub shim.ChaincodeStubInterface, function string, args []string) ([]byte, error) {
-	if len(args) != 1 {
-		return nil, errors.New("Incorrect number of arguments. Expecting 1")
+// Init makes the creator of the deploy transaction the administrator, who
+// registers the insurance companies and the banks. An upgrade indexes the
+// records stored by the previous versions.
+func (t *InsuranceChaincode) Init(stub shim.ChaincodeStubInterface) pb.Response {
+	fmt.Println("Init Chaincode...")
+
+	response := initAdmin(stub)
+	if response.Status != shim.OK {
+		return response
 	}
-	admin, err := base64.StdEncoding.DecodeString(args[0])
+	indexed, err := reindex(stub)
 	if err != nil {
-		return nil, fmt.Errorf("Failed getting call certificate, [%v]", err)
-	}
-	if len(admin) == 0 {
-		return nil, errors.New("Invalid call asset role. Empty.")
+		return shim.Error(err.Error())
 	}
-
-	stub.PutState(adminRole, admin)
-	return nil, nil
-}
-
-// Transaction makes payment of X units from A to B
-func (t *InsuranceChaincode) Invoke(stub shim.ChaincodeStubInterface, function string, args []string) ([]byte, error) {
-	if function == "issue" {
-		// Assign ownership
-		return t.issue(stub, args)
-	} else if function == "bank" {
-		// Assign ownership
-		return t.bank(stub, args)
-	} else if function == "assign" {
-		// Assign ownership
-		return t.assign(stub, args)
-	} else if function == "credit" {
-		// Transfer ownership
-		return t.credit(stub, args)
-	} else if function == "apply" {
-		// Transfer ownership
-		return t.apply(stub, args)
-	} else if function == "loan" {
-		// Transfer ownership
-		return t.loan(stub, args, 0)
-	} else if function == "pay" {
-		// Transfer ownership
-		return t.loan(stub, args, 1)
-	} else if function == "break" {
-		// Transfer ownership
-		return t.loan(stub, args, 2)
-	} else if function == "confirm" {
-		// Transfer ownership
-		return t.loan(stub, args, 3)
-	}
-
-	return nil, errors.New("Received unknown function invocation")
+	fmt.Printf("Init Chaincode... %d records indexed\n", indexed)
+	return response
 }
 
-func (t *InsuranceChaincode) issue(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
-	fmt.Printf("start issue")
-	if len(args) != 2 {
-		return nil, errors.New("Incorrect number of arguments. Expecting 2")
-	}
-
-	callerCertificate, err := stub.GetCallerCertificate()
-	if err != nil {
-		return nil, fmt.Errorf("Failed getting call certificate, [%v]", err)
-	}
-	admin, err := stub.GetState(adminRole)
+// initAdmin runs common.InitAdmin, unless the admin key holds the DER
+// certificate stored by the first versions: the creator of the upgrade must
+// have this certificate, as getCaller identifies the callers, and becomes the
+// admin.
+func initAdmin(stub shim.ChaincodeStubInterface) pb.Response {
+	adminCert, err := stub.GetState(common.AdminKey)
 	if err != nil {
-		return nil, fmt.Errorf("Failed getting admin certificate, [%v]", err)
+		return shim.Error("Failed to get admin's cert, error: " + err.Error())
 	}
-	if bytes.Compare(callerCertificate, admin) != 0 {
-		return nil, fmt.Errorf("the caller is not admin")
+	if len(adminCert) == 0 {
+		return common.InitAdmin(stub)
 	}
-
-	key := insurancePrefix + args[0]
-	if isInsuranceOrBank(key, stub) {
-		return nil, fmt.Errorf("Failed this company is alreay issue insurance", err)
+	if _, err = x509.ParseCertificate(adminCert); err != nil {
+		return common.InitAdmin(stub)
 	}
-
-	stub.PutState(key, []byte(args[1]))
+	identity, err := common.GetIdentity(stub)
 	if err != nil {
-		return nil, fmt.Errorf("the caller is not admin, [%v]", err)
+		return shim.Error(err.Error())
 	}
-
-	return nil, nil
-}
-
-func (t *InsuranceChaincode) bank(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
-	fmt.Printf("start issue")
-	if len(args) != 2 {
-		return nil, errors.New("Incorrect number of arguments. Expecting 2")
+	if identity.Cert == nil || !bytes.Equal(identity.Cert.Raw, adminCert) {
+		return shim.Error("the upgrade must be created by the admin of the chaincode")
 	}
+	return common.SeedAdmin(stub)
+}
 
-	callerCertificate, err := stub.GetCallerCertificate()
+// reindex moves the lists of policies of the owners to one key per policy,
+// adds the records stored before their indexes to the indexes, and returns
+// the number of records migrated or indexed
+func reindex(stub shim.ChaincodeStubInterface) (int, error) {
+	owners, err := legacyOwners(stub)
 	if err != nil {
-		return nil, fmt.Errorf("Failed getting call certificate, [%v]", err)
+		return 0, err
 	}
-	admin, err := stub.GetState(adminRole)
+	policyOwners := make(policyOwners)
+	migration, err := migratePolicies(stub, owners, policyOwners)
 	if err != nil {
-		return nil, fmt.Errorf("Failed getting admin certificate, [%v]", err)
+		return 0, err
 	}
-	if bytes.Compare(callerCertificate, admin) != 0 {
-		return nil, fmt.Errorf("the caller is not admin")
-	}
-	company, err := base64.StdEncoding.DecodeString(args[0])
-
-	fmt.Printf("issue for company: [%x]", company)
-	key := bankPrefix + args[0]
-	if isInsuranceOrBank(key, stub) {
-		return nil, fmt.Errorf("Failed this company is alreay issue insurance", err)
+	policies, err := indexPolicies(stub, policyOwners)
+	policies += migration.Policies
+	if err != nil {
+		return policies, err
 	}
-
-	stub.PutState(key, []byte(args[1]))
+	credits, err := indexCredits(stub)
 	if err != nil {
-		return nil, fmt.Errorf("the caller is not admin, [%v]", err)
+		return policies + credits, err
 	}
+	pledges, err := indexPledges(stub)
+	return policies + credits + pledges, err
+}
 
-	return nil, nil
+// Invoke will be called for every transaction, and dispatches it to the
+// function declared by router.
+func (t *InsuranceChaincode) Invoke(stub shim.ChaincodeStubInterface) pb.Response {
+	return t.router().Invoke(stub)
 }
 
-func isInsuranceOrBank(key string, stub shim.ChaincodeStubInterface) (bool) {
-	balance, err := stub.GetState(key)
-	if err != nil || balance == nil {
-		return false
+// pledgeHandler adapts transition to a handler of the action
+func (t *InsuranceChaincode) pledgeHandler(action *pledgeAction) common.Handler {
+	return func(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+		return t.transition(stub, args, action)
 	}
-	return true
 }
 
-func (t *InsuranceChaincode) assign(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
-	fmt.Printf("start assign")
+func (t *InsuranceChaincode) router() *common.Router {
+	r := common.NewRouter()
+	r.Handle("issue", t.issue, common.StringArg("company"), common.StringArg("detail")).
+		Require(common.RoleAdmin)
+	r.Handle("bank", t.bank, common.StringArg("bank"), common.StringArg("detail")).
+		Require(common.RoleAdmin)
+	r.Handle("assign", t.assign, common.StringArg("owner"), common.StringArg("id"),
+		common.StringArg("balance"))
+	r.Handle("setPolicyState", t.setPolicyState, common.StringArg("owner"), common.StringArg("id"),
+		common.StringArg("state"))
+	r.Handle("requestTransfer", t.requestTransfer, common.StringArg("company"), common.StringArg("id"),
+		common.StringArg("kind"), common.StringArg("target"))
+	r.Handle("approveTransfer", t.approveTransfer, common.StringArg("id"))
+	r.Handle("rejectTransfer", t.rejectTransfer, common.StringArg("id"))
+	r.Handle("credit", t.credit, common.StringArg("company"), common.StringArg("id"),
+		common.IntArg("expire"), common.StringArg("credit"), common.IntArg("rate"))
+	r.Handle("amendCredit", t.amendCredit, common.StringArg("company"), common.StringArg("id"),
+		common.IntArg("expire"), common.StringArg("credit"), common.IntArg("rate"))
+	r.Handle("revokeCredit", t.revokeCredit, common.StringArg("company"), common.StringArg("id"))
+	r.Handle("apply", t.apply, common.StringArg("company"), common.StringArg("id"),
+		common.StringArg("bank"), common.Optional(common.StringArg("amount")))
+	for i := range pledgeActions {
+		action := &pledgeActions[i]
+		args := []common.Arg{common.StringArg("company"), common.StringArg("id"), common.StringArg("bank")}
+		r.Handle(action.Name, t.pledgeHandler(action), append(args, action.Args...)...)
+	}
+	r.Handle("user", t.queryUser, common.StringArg("owner"))
+	r.Handle("queryBank", t.queryBank, common.StringArg("section"),
+		common.Optional(common.StringArg("pageSize")), common.Optional(common.StringArg("bookmark")))
+	r.Handle("exposure", t.queryExposure, common.Optional(common.StringArg("bank")))
+	r.Handle("migrate", t.migrate, common.Variadic(common.StringArg("owner"))).
+		Require(common.RoleAdmin)
+	common.HandleRoles(r)
+	common.HandleAdmins(r)
+	return r
+}
 
-	if len(args) != 3 {
-		return nil, errors.New("Incorrect number of arguments. Expecting 3")
-	}
-	callerCertificate, err := stub.GetCallerCertificate()
+// getCaller returns the base64 of the DER certificate of the creator of the
+// transaction, the ID of the companies, the banks and the users as recorded
+// before the upgrade. A creator which is not a certificate is identified by
+// the base64 of its identity bytes.
+func getCaller(stub shim.ChaincodeStubInterface) (string, error) {
+	identity, err := common.GetIdentity(stub)
 	if err != nil {
-		return nil, fmt.Errorf("Failed getting call certificate, [%v]", err)
+		return "", err
 	}
-	company := base64.StdEncoding.EncodeToString(callerCertificate)
-	insuranceKey := insurancePrefix + company
-	if !isInsuranceOrBank(insuranceKey, stub) {
-		return nil, fmt.Errorf("caller is not insurance company, [%x]", callerCertificate)
+	if identity.Cert != nil {
+		return base64.StdEncoding.EncodeToString(identity.Cert.Raw), nil
 	}
-	owner := args[0]
-	id := args[1]
-	balance, err := strconv.Atoi(args[2])
-	key := userPrefix + owner
-
-	policy := Policy{owner, id, company, 0, balance}
+	return base64.StdEncoding.EncodeToString(identity.IDBytes), nil
+}
 
-	var policies []Policy
-	userAssetString, err := stub.GetState(key)
-	if err != nil || userAssetString == nil {
-		policies = []Policy{policy}
-	} else {
-		err = json.Unmarshal(userAssetString, &policies)
-		if err != nil {
-			return nil, fmt.Errorf("unmarshal user balance failed " + err.Error())
-		}
-		policies = append(policies, policy)
+// register stores a company or a bank under key, once
+func register(stub shim.ChaincodeStubInterface, key string, party Party, eventType string) pb.Response {
+	if isInsuranceOrBank(key, stub) {
+		return shim.Error("Failed this company is alreay issue insurance")
 	}
 
-	userAssetResult, err := json.Marshal(policies)
+	err := stub.PutState(key, []byte(party.Detail))
 	if err != nil {
-		return nil, fmt.Errorf("marshal user's asset failed")
+		return shim.Error("store company failed: " + err.Error())
 	}
 
-	fmt.Println("set key: %s , detail: %s", key, string(userAssetResult))
-	err = stub.PutState(key, userAssetResult)
+	err = common.SetEvent(stub, eventType, party)
 	if err != nil {
-		return nil, fmt.Errorf("store user's asset failed")
+		return shim.Error(err.Error())
 	}
-	return nil, nil
-}
 
-func (t *InsuranceChaincode) credit(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
-	fmt.Printf("start assign")
-
-	if len(args) != 5 {
-		return nil, errors.New("Incorrect number of arguments. Expecting 5")
-	}
-	callerCertificate, err := stub.GetCallerCertificate()
-	if err != nil {
-		return nil, fmt.Errorf("Failed getting call certificate, [%v]", err)
-	}
-	bankId := base64.StdEncoding.EncodeToString(callerCertificate)
-	key := bankPrefix + bankId
-	if !isInsuranceOrBank(key, stub) {
-		return nil, errors.New("caller is not bank")
-	}
-	company := args[0]
-	id := args[1]
-	expire, err := strconv.Atoi(args[2])
-	limit, err := strconv.Atoi(args[3])
-	rate, err := strconv.Atoi(args[4])
-	credit := Credit{id, company, bankId, expire, limit, rate}
-
-	insuranceId := creditPrefix + company + id
-	var credits []Credit
-	userAssetString, err := stub.GetState(insuranceId)
-	if err != nil || userAssetString == nil {
-		credits = []Credit{credit}
-	} else {
-		err = json.Unmarshal(userAssetString, &credits)
-		if err != nil {
-			return nil, fmt.Errorf("unmarshal user balance failed " + err.Error())
-		}
-		for _, c := range credits {
-			if strings.Compare(c.Bank, bankId) == 0 {
-				return nil, fmt.Errorf("already credit this insurance")
-			}
-		}
-		credits = append(credits, credit)
-	}
-	userAssetResult, err := json.Marshal(credits)
-	if err != nil {
-		return nil, fmt.Errorf("marshal user's asset failed")
-	}
+	return shim.Success(nil)
+}
 
-	fmt.Println("set key: %s , detail: %s", insuranceId, string(userAssetResult))
-	err = stub.PutState(insuranceId, userAssetResult)
-	if err != nil {
-		return nil, fmt.Errorf("store user's asset failed")
-	}
+// issue registers an insurance company
+// args: company, detail
+func (t *InsuranceChaincode) issue(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	fmt.Println("start issue")
 
-	return nil, nil
+	return register(stub, insurancePrefix+args[0], Party{args[0], args[1]}, EventInsurerRegistered)
 }
 
-func (t *InsuranceChaincode) apply(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
-	fmt.Printf("start assign")
-
-	if len(args) != 3 {
-		return nil, errors.New("Incorrect number of arguments. Expecting 3")
-	}
-	owner, err := stub.GetCallerCertificate()
-	if err != nil {
-		return nil, fmt.Errorf("Failed getting call certificate, [%v]", err)
-	}
-	user := base64.StdEncoding.EncodeToString(owner)
-	company := args[0]
-	id := args[1]
-	bank := args[2]
+// bank registers a bank
+// args: bank, detail
+func (t *InsuranceChaincode) bank(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	fmt.Println("start bank")
 
-	key := userPrefix + user
+	return register(stub, bankPrefix+args[0], Party{args[0], args[1]}, EventBankRegistered)
+}
 
-	var policies []Policy
-	userAssetString, err := stub.GetState(key)
-	if err != nil || userAssetString == nil {
-		return nil, fmt.Errorf("user did not have any insurance")
-	} else {
-		err = json.Unmarshal(userAssetString, &policies)
-		if err != nil {
-			return nil, fmt.Errorf("unmarshal user balance failed " + err.Error())
-		}
-		flag := false
-		for _, p := range policies {
-			if strings.Compare(p.Company, company) == 0 && strings.Compare(p.Id, id) == 0{
-				flag = true
-				break
-			}
-		}
-		if !flag {
-			return nil, fmt.Errorf("user did not have this insurance, %s, %s", company, id)
-		}
+func isInsuranceOrBank(key string, stub shim.ChaincodeStubInterface) bool {
+	balance, err := stub.GetState(key)
+	if err != nil || balance == nil {
+		return false
 	}
+	return true
+}
 
-	applyId := applyPrefix + company + id
-	applyString, err := stub.GetState(applyId)
-	if err != nil || applyString != nil {
-		return nil, fmt.Errorf("this insurance is already apply")
-	}
-	apply := Apply{id, company, bank}
-	userAssetResult, err := json.Marshal(apply)
+// parseCurrency reads a non negative currency amount
+func parseCurrency(value string) (decimal.Amount, error) {
+	amount, err := decimal.Parse(value, currencyScale)
 	if err != nil {
-		return nil, fmt.Errorf("marshal user's asset failed")
+		return amount, err
 	}
-
-	fmt.Println("set key: %s , detail: %s", applyId, string(userAssetResult))
-	err = stub.PutState(applyId, userAssetResult)
-	if err != nil {
-		return nil, fmt.Errorf("store user's asset failed")
+	if amount.Sign() < 0 {
+		return amount, errors.New("the amount must not negative")
 	}
-
-	return nil, nil
+	return amount, nil
 }
 
-func (t *InsuranceChaincode) loan(stub shim.ChaincodeStubInterface, args []string, actionType int) ([]byte, error) {
-	fmt.Printf("start assign")
+// assign assigns a policy of the calling company to its owner
+// args: owner, id, balance
+func (t *InsuranceChaincode) assign(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	fmt.Println("start assign")
 
-	if len(args) != 3 {
-		return nil, errors.New("Incorrect number of arguments. Expecting 3")
-	}
-	owner, err := stub.GetCallerCertificate()
+	company, err := getCallerCompany(stub)
 	if err != nil {
-		return nil, fmt.Errorf("Failed getting call certificate, [%v]", err)
+		return shim.Error(err.Error())
 	}
-	user := base64.StdEncoding.EncodeToString(owner)
-	company := args[0]
+	owner := args[0]
 	id := args[1]
-	bank := args[2]
-
-	key := userPrefix + user
-
-	var policies []Policy
-	userAssetString, err := stub.GetState(key)
-	if err != nil || userAssetString == nil {
-		return nil, fmt.Errorf("user did not have any insurance")
-	} else {
-		err = json.Unmarshal(userAssetString, &policies)
-		if err != nil {
-			return nil, fmt.Errorf("unmarshal user balance failed " + err.Error())
-		}
-		flag := false
-		for _, p := range policies {
-			if strings.Compare(p.Company, company) == 0 && strings.Compare(p.Id, id) == 0{
-				flag = true
-				break
-			}
-		}
-		if !flag {
-			return nil, fmt.Errorf("user did not have this insurance, %s, %s", company, id)
-		}
-	}
-	keyPrefix := loanPrefix
-	switch actionType {
-	case 1 :
-		keyPrefix = payPrefix
-	case 2 :
-		keyPrefix = breakPrefix
-	case 3 :
-		keyPrefix = confirmPrefix
-	}
-
-	applyId := keyPrefix + company + id
-	applyString, err := stub.GetState(applyId)
-	if err != nil || applyString != nil {
-		return nil, fmt.Errorf("this insurance is already apply")
-	}
-	apply := Apply{id, company, bank}
-	userAssetResult, err := json.Marshal(apply)
+	balance, err := parseCurrency(args[2])
 	if err != nil {
-		return nil, fmt.Errorf("marshal user's asset failed")
+		return shim.Error("balance argument is incorrect: " + err.Error())
 	}
 
-	fmt.Println("set key: %s , detail: %s", applyId, string(userAssetResult))
-	err = stub.PutState(applyId, userAssetResult)
-	if err != nil {
-		return nil, fmt.Errorf("store user's asset failed")
+	if owner == "" {
+		return shim.Error("owner argument is incorrect")
 	}
 
-	return nil, nil
-}
+	policy := Policy{Owner: owner, Id: id, Company: company, State: PolicyActive, Balance: balance}
 
-// Query callback representing the query of a chaincode
-func (t *InsuranceChaincode) Query(stub shim.ChaincodeStubInterface, function string, args []string) ([]byte, error) {
-	if function == "user" {
-		return t.queryUser(stub, args)
-	} else if function == "bank" {
-		return t.queryBank(stub, args)
-	} else {
-		return nil, errors.New("Invalid query function name. Expecting \"query\"")
+	// pledges, credits and transfers are keyed by company and id, a policy
+	// has one owner
+	held, err := getPolicyOwner(stub, company, id)
+	if err != nil {
+		return shim.Error(err.Error())
 	}
-	return nil, nil
-}
-
-func queryBank(stub shim.ChaincodeStubInterface, id , company string) ([]Credit, error) {
-	insuranceId := creditPrefix + company + id
-	var credits []Credit
-	userAssetString, err := stub.GetState(insuranceId)
-	if err != nil || userAssetString == nil {
-		return credits, nil
-	} else {
-		err = json.Unmarshal(userAssetString, &credits)
-		if err != nil {
-			return nil, fmt.Errorf("unmarshal user balance failed " + err.Error())
-		}
+	if held != "" {
+		return shim.Error(fmt.Sprintf("this insurance is already assigned, %s, %s", company, id))
 	}
-	return credits, nil
-}
 
-func queryApply(stub shim.ChaincodeStubInterface, id, company string) (*Apply, error) {
-	insuranceId := applyPrefix + company + id
-	var apply Apply
-	userAssetString, err := stub.GetState(insuranceId)
-	if err != nil || userAssetString == nil {
-		return nil, nil
-	} else {
-		err = json.Unmarshal(userAssetString, &apply)
-		if err != nil {
-			return nil, fmt.Errorf("unmarshal user balance failed " + err.Error())
-		}
+	err = putPolicy(stub, &policy)
+	if err != nil {
+		return shim.Error(err.Error())
 	}
-	return &apply, nil
-}
-
-
-func queryByAction(stub shim.ChaincodeStubInterface, id, company string, actionType int) (*Apply, error) {
-	keyPrefix := loanPrefix
-	switch actionType {
-	case 1 :
-		keyPrefix = payPrefix
-	case 2 :
-		keyPrefix = breakPrefix
-	case 3 :
-		keyPrefix = confirmPrefix
-	}
-	insuranceId := keyPrefix + company + id
-	var apply Apply
-	userAssetString, err := stub.GetState(insuranceId)
-	if err != nil || userAssetString == nil {
-		return nil, nil
-	} else {
-		err = json.Unmarshal(userAssetString, &apply)
-		if err != nil {
-			return nil, fmt.Errorf("unmarshal user balance failed " + err.Error())
-		}
+	err = common.SetEvent(stub, EventPolicyAssigned, policy)
+	if err != nil {
+		return shim.Error(err.Error())
 	}
-	return &apply, nil
+	return shim.Success(nil)
 }
 
-func (t *InsuranceChaincode) queryUser(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
-	if len(args) != 1 {
-		return nil, errors.New("Incorrect number of arguments. Expecting name of an asset to query 1")
-	}
+// queryUser returns the PolicyResult of each policy of a user
+// args: owner
+func (t *InsuranceChaincode) queryUser(stub shim.ChaincodeStubInterface, args []string) pb.Response {
 	owner := args[0]
-	key := userPrefix + owner
 
-	var policies []Policy
+	policies, err := getPolicies(stub, owner)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if policies == nil {
+		return shim.Success(nil)
+	}
 	var issurances []PolicyResult
-	userAssetString, err := stub.GetState(key)
-	if err != nil || userAssetString == nil {
-		return nil, nil
-	} else {
-		err = json.Unmarshal(userAssetString, &policies)
+	for _, p := range policies {
+		credits, err := getCredits(stub, p.Company, p.Id)
 		if err != nil {
-			return nil, fmt.Errorf("unmarshal user balance failed " + err.Error())
+			return shim.Error("query credit failed: " + err.Error())
 		}
-		for _, p := range policies {
-			credits, error := queryBank(stub, p.Id, p.Company)
-			if error != nil {
-				return nil, fmt.Errorf("query credit failed" + err.Error())
-			}
-			apply, error := queryApply(stub, p.Id, p.Company)
-			if error != nil {
-				return nil, fmt.Errorf("query credit apply" + err.Error())
-			}
-			loan, error := queryByAction(stub, p.Id, p.Company, 0)
-			if error != nil {
-				return nil, fmt.Errorf("query credit apply" + err.Error())
-			}
-			pay, error := queryByAction(stub, p.Id, p.Company, 1)
-			if error != nil {
-				return nil, fmt.Errorf("query credit apply" + err.Error())
-			}
-			isBreak, error := queryByAction(stub, p.Id, p.Company, 2)
-			if error != nil {
-				return nil, fmt.Errorf("query credit apply" + err.Error())
+		pledge, err := getPledge(stub, p.Company, p.Id)
+		if err != nil {
+			return shim.Error("query pledge failed: " + err.Error())
+		}
+		result := PolicyResult{Insurance: p, Credits: credits, Pledge: pledge}
+		if pledge != nil && pledge.Loan != nil {
+			date, err := txDate(stub)
+			if err != nil {
+				return shim.Error(err.Error())
 			}
-			confirm, error := queryByAction(stub, p.Id, p.Company, 3)
-			if error != nil {
-				return nil, fmt.Errorf("query credit apply" + err.Error())
+			result.Balance, err = pledge.Loan.balance(date)
+			if err != nil {
+				return shim.Error("query balance failed: " + err.Error())
 			}
-			result := PolicyResult{p, credits, apply, loan, pay, isBreak, confirm}
-			issurances = append(issurances, result)
 		}
+		issurances = append(issurances, result)
 	}
 	jSONasBytes, err := json.Marshal(issurances)
-	if err != nil || userAssetString == nil {
-		return nil, fmt.Errorf("failed marshal issurance" + err.Error())
+	if err != nil {
+		return shim.Error("failed marshal issurance: " + err.Error())
 	}
-	return jSONasBytes, nil
-}
-
-func (t *InsuranceChaincode) queryBank(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
-	return nil, nil
+	return shim.Success(jSONasBytes)
 }
 
 func main() {
@@ -538,4 +410,4 @@ func main() {
 	if err != nil {
 		fmt.Printf("Error starting insurance chaincode: %s", err)
 	}
-}
\ No newline at end of file
+}
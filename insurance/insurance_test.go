@@ -0,0 +1,145 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/chaincode/common"
+	"github.com/chaincode/mockstub"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+// id returns the ID of the company, the bank or the user of the identity
+// bytes name
+func id(name string) string {
+	return base64.StdEncoding.EncodeToString([]byte(name))
+}
+
+// newInsuranceStub deploys the chaincode with "admin" as the admin, who
+// registers the company "ins" and the bank "bank" on 2017-07-01. It returns
+// with "ins" as the creator.
+func newInsuranceStub(t *testing.T) *mockstub.MockStub {
+	t.Helper()
+	stub := mockstub.NewMockStub("insurance", new(InsuranceChaincode))
+	stub.SetCreator("AdminMSP", []byte("admin"))
+	stub.SetTxTimestamp(time.Date(2017, 7, 1, 0, 0, 0, 0, time.UTC))
+	if response := stub.MockInit("init", []string{"init"}); response.Status != shim.OK {
+		t.Fatal(response.Message)
+	}
+	invoke(t, stub, "issue", "issue", id("ins"), "Insurer")
+	invoke(t, stub, "bank", "bank", id("bank"), "Bank")
+	stub.SetCreator("OrgMSP", []byte("ins"))
+	return stub
+}
+
+func invoke(t *testing.T, stub *mockstub.MockStub, txID string, args ...string) []byte {
+	t.Helper()
+	response := stub.MockInvoke(txID, args)
+	if response.Status != shim.OK {
+		t.Fatalf("%s %v: %s", txID, args, response.Message)
+	}
+	return response.Payload
+}
+
+// invokeFails invokes a transaction which must fail, and returns its message
+func invokeFails(t *testing.T, stub *mockstub.MockStub, txID string, args ...string) string {
+	t.Helper()
+	response := stub.MockInvoke(txID, args)
+	if response.Status == shim.OK {
+		t.Fatalf("%s %v succeeded", txID, args)
+	}
+	return response.Message
+}
+
+// as invokes a transaction created by the identity bytes name
+func as(t *testing.T, stub *mockstub.MockStub, name, txID string, args ...string) []byte {
+	t.Helper()
+	stub.SetCreator("OrgMSP", []byte(name))
+	return invoke(t, stub, txID, args...)
+}
+
+func queryUser(t *testing.T, stub *mockstub.MockStub, owner string) []PolicyResult {
+	t.Helper()
+	var results []PolicyResult
+	if payload := invoke(t, stub, "user", "user", owner); payload != nil {
+		if err := json.Unmarshal(payload, &results); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return results
+}
+
+// newCert returns the DER and the PEM of a self-signed certificate
+func newCert(t *testing.T, commonName string) ([]byte, []byte) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: commonName},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return der, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+}
+
+func TestCallerIsTheDERCertificate(t *testing.T) {
+	stub := newInsuranceStub(t)
+	der, certPEM := newCert(t, "insurer")
+	company := base64.StdEncoding.EncodeToString(der)
+	stub.SetCreator("AdminMSP", []byte("admin"))
+	invoke(t, stub, "tx1", "issue", company, "Insurer with a certificate")
+
+	// the company registered with its certificate before the upgrade signs
+	// with the PEM of the certificate
+	stub.SetCreator("OrgMSP", certPEM)
+	invoke(t, stub, "tx2", "assign", id("alice"), "p1", "100")
+	results := queryUser(t, stub, id("alice"))
+	if len(results) != 1 || results[0].Insurance.Company != company {
+		t.Fatalf("policies %+v", results)
+	}
+
+	// without certificate the identity bytes are the ID
+	stub.SetCreator("OrgMSP", []byte("ins"))
+	invoke(t, stub, "tx3", "assign", id("alice"), "p2", "100")
+	stub.SetCreator("OrgMSP", []byte("unknown"))
+	if message := invokeFails(t, stub, "tx4", "assign", id("alice"), "p3", "100"); message != "caller is not insurance company" {
+		t.Fatal(message)
+	}
+}
+
+func TestUpgradeFromTheDERAdmin(t *testing.T) {
+	der, certPEM := newCert(t, "admin")
+	stub := mockstub.NewMockStub("insurance", new(InsuranceChaincode))
+	// the first Init stored the DER certificate of the admin
+	stub.State[common.AdminKey] = der
+
+	stub.SetCreator("AdminMSP", []byte("other"))
+	if response := stub.MockInit("upgrade1", []string{"init"}); response.Status == shim.OK {
+		t.Fatal("upgrade by another identity than the admin")
+	}
+	stub.SetCreator("AdminMSP", certPEM)
+	if response := stub.MockInit("upgrade2", []string{"init"}); response.Status != shim.OK {
+		t.Fatal(response.Message)
+	}
+	invoke(t, stub, "tx1", "issue", id("ins"), "Insurer")
+	invoke(t, stub, "tx2", "bank", id("bank"), "Bank")
+
+	stub.SetCreator("AdminMSP", []byte("other"))
+	invokeFails(t, stub, "tx3", "issue", id("ins2"), "Insurer")
+}
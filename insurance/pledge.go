@@ -0,0 +1,389 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/chaincode/common"
+	"github.com/chaincode/decimal"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+// pledgePrefix keys the pledge of a policy: pledge_ + company + id
+const pledgePrefix = "pledge_"
+
+// The states of a pledge
+const (
+	// PledgeApplied is the state of a pledge the holder applied for
+	PledgeApplied = "applied"
+	// PledgeApproved is the state of a pledge the bank approved
+	PledgeApproved = "approved"
+	// PledgeDisbursed is the state of a pledge the bank lent the loan of
+	PledgeDisbursed = "disbursed"
+	// PledgeRepaid is the state of a pledge the holder repaid the loan of
+	PledgeRepaid = "repaid"
+	// PledgeDefaulted is the state of a pledge the holder did not repay
+	PledgeDefaulted = "defaulted"
+	// PledgeClosed is the state of a pledge confirmed or cancelled. The
+	// holder can apply for a new pledge of the policy.
+	PledgeClosed = "closed"
+)
+
+// Transition is a change of the state of a pledge
+type Transition struct {
+	Action    string `json:"action"`
+	From      string `json:"from,omitempty"`
+	To        string `json:"to"`
+	Caller    string `json:"caller"`
+	TxID      string `json:"txId"`
+	Timestamp int64  `json:"timestamp"`
+}
+
+// Pledge is the loan of a bank on a policy, from the application of the
+// holder to its closing. Amount is reserved on the credit of the bank until
+// the pledge is closed.
+type Pledge struct {
+	Id      string         `json:"id"`
+	Company string         `json:"company"`
+	Owner   string         `json:"owner,omitempty"`
+	Bank    string         `json:"bank"`
+	Amount  decimal.Amount `json:"amount,omitempty"`
+	State   string         `json:"state"`
+	// Loan is the loan disbursed, nil before the disbursement
+	Loan    *Loan        `json:"loan,omitempty"`
+	History []Transition `json:"history,omitempty"`
+}
+
+// pledgeAction is a function of the chaincode moving a pledge from one of
+// the states From to To
+type pledgeAction struct {
+	Name string
+	From []string
+	To   string
+	// ByHolder and ByBank tell who can call the function: the holder of the
+	// policy and the bank of the pledge
+	ByHolder bool
+	ByBank   bool
+	// Unexpired requires the credit of the bank not to be expired
+	Unexpired bool
+	// Args are the arguments after company, id and bank
+	Args []common.Arg
+	// Handle updates the pledge with the arguments and returns the state
+	// of the transition, To when it is nil
+	Handle func(stub shim.ChaincodeStubInterface, pledge *Pledge, args []string) (string, error)
+	Event  string
+}
+
+// pledgeActions are the transitions of the pledges after apply
+var pledgeActions = []pledgeAction{
+	{Name: "approve", From: []string{PledgeApplied}, To: PledgeApproved,
+		ByBank: true, Unexpired: true, Event: EventLoanApproved},
+	{Name: "loan", From: []string{PledgeApproved}, To: PledgeDisbursed,
+		ByBank: true, Unexpired: true, Event: EventLoanGranted,
+		Args: []common.Arg{common.Optional(common.IntArg("term"))}, Handle: disburse},
+	{Name: "pay", From: []string{PledgeDisbursed}, To: PledgeRepaid,
+		ByHolder: true, Event: EventLoanPaid,
+		Args: []common.Arg{common.Optional(common.StringArg("amount"))}, Handle: repay},
+	{Name: "break", From: []string{PledgeDisbursed}, To: PledgeDefaulted,
+		ByBank: true, Event: EventPolicyBroken, Handle: checkOverdue},
+	{Name: "confirm", From: []string{PledgeRepaid, PledgeDefaulted}, To: PledgeClosed,
+		ByBank: true, Event: EventLoanConfirmed},
+	{Name: "cancel", From: []string{PledgeApplied, PledgeApproved}, To: PledgeClosed,
+		ByHolder: true, ByBank: true, Event: EventLoanCancelled},
+}
+
+func (action *pledgeAction) allows(state string) bool {
+	for _, from := range action.From {
+		if from == state {
+			return true
+		}
+	}
+	return false
+}
+
+// getPledge reads the pledge of a policy, nil when the policy was never
+// pledged. The pledges recorded as apply_, loan_, pay_, break_ and confirm_
+// keys are read into a pledge without history.
+func getPledge(stub shim.ChaincodeStubInterface, company, id string) (*Pledge, error) {
+	var pledge Pledge
+	found, err := common.GetJSON(stub, pledgePrefix+company+id, &pledge)
+	if err != nil {
+		return nil, err
+	}
+	if found {
+		return &pledge, nil
+	}
+
+	var apply Apply
+	found, err = common.GetJSON(stub, applyPrefix+company+id, &apply)
+	if err != nil || !found {
+		return nil, err
+	}
+	pledge = Pledge{Id: id, Company: company, Bank: apply.Bank, State: PledgeApplied}
+	for _, step := range []struct {
+		prefix string
+		state  string
+	}{
+		{confirmPrefix, PledgeClosed},
+		{breakPrefix, PledgeDefaulted},
+		{payPrefix, PledgeRepaid},
+		{loanPrefix, PledgeDisbursed},
+	} {
+		value, err := stub.GetState(step.prefix + company + id)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to get state of %s: %s", step.prefix+company+id, err)
+		}
+		if value != nil {
+			pledge.State = step.state
+			break
+		}
+	}
+	return &pledge, nil
+}
+
+// putPledge records the transition of the pledge to the state to, and stores
+// the pledge and its entry in the portfolio of its bank
+func putPledge(stub shim.ChaincodeStubInterface, pledge *Pledge, action, caller, to string) error {
+	txTimestamp, err := stub.GetTxTimestamp()
+	if err != nil {
+		return fmt.Errorf("Failed getting transaction timestamp: %s", err)
+	}
+	pledge.History = append(pledge.History, Transition{
+		Action:    action,
+		From:      pledge.State,
+		To:        to,
+		Caller:    caller,
+		TxID:      stub.GetTxID(),
+		Timestamp: txTimestamp.Seconds,
+	})
+	from := pledge.State
+	pledge.State = to
+	err = common.PutJSON(stub, pledgePrefix+pledge.Company+pledge.Id, pledge)
+	if err != nil {
+		return err
+	}
+	return indexPledge(stub, pledge, from)
+}
+
+// apply applies for a loan of a bank crediting a policy of the caller, and
+// reserves the amount on the credit of the bank, by default all the
+// available credit
+// args: company, id, bank, [amount]
+func (t *InsuranceChaincode) apply(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	fmt.Println("start apply")
+
+	user, err := getCaller(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	company := args[0]
+	id := args[1]
+	bank := args[2]
+
+	var amount decimal.Amount
+	if len(args) > 3 {
+		amount, err = parseCurrency(args[3])
+		if err != nil {
+			return shim.Error("amount argument is incorrect: " + err.Error())
+		}
+	}
+
+	policy, err := getHeldPolicy(stub, user, company, id)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if policy.State != PolicyActive {
+		return shim.Error(fmt.Sprintf("the insurance is %s", policyStates[policy.State]))
+	}
+
+	pledge, err := getPledge(stub, company, id)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if pledge != nil && pledge.State != PledgeClosed {
+		return shim.Error("this insurance is already pledged")
+	}
+	if pledge == nil {
+		pledge = &Pledge{Id: id, Company: company}
+	}
+	pledge.Amount, err = reserveCredit(stub, company, id, bank, amount)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	pledge.Owner = user
+	pledge.Bank = bank
+	pledge.Loan = nil
+	err = putPledge(stub, pledge, "apply", user, PledgeApplied)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	err = common.SetEvent(stub, EventLoanApplied, pledge)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	return shim.Success(nil)
+}
+
+// transition moves the pledge of a policy as action, called by the holder of
+// the policy or by the bank of the pledge
+// args: company, id, bank
+func (t *InsuranceChaincode) transition(stub shim.ChaincodeStubInterface, args []string, action *pledgeAction) pb.Response {
+	fmt.Printf("start %s\n", action.Name)
+
+	caller, err := getCaller(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	company := args[0]
+	id := args[1]
+	bank := args[2]
+
+	pledge, err := getPledge(stub, company, id)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if pledge == nil || pledge.State == PledgeClosed {
+		return shim.Error("this insurance is not pledged")
+	}
+	if pledge.Bank != bank {
+		return shim.Error("this insurance is not pledged to the bank")
+	}
+
+	allowed := action.ByBank && caller == pledge.Bank
+	if !allowed && action.ByHolder {
+		allowed = checkHolder(stub, caller, company, id) == nil
+	}
+	if !allowed {
+		return shim.Error(fmt.Sprintf("the caller can not %s this pledge", action.Name))
+	}
+	if !action.allows(pledge.State) {
+		return shim.Error(fmt.Sprintf("the pledge is %s, can not %s it", pledge.State, action.Name))
+	}
+	if action.Unexpired {
+		credits, err := getCredits(stub, company, id)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		i := findCredit(credits, bank)
+		if i < 0 {
+			return shim.Error("the bank did not credit this insurance")
+		}
+		err = checkExpire(stub, &credits[i])
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+	}
+	to := action.To
+	if action.Handle != nil {
+		to, err = action.Handle(stub, pledge, args[3:])
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+	}
+	if to == PledgeClosed {
+		err = releaseCredit(stub, pledge)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+	}
+
+	err = putPledge(stub, pledge, action.Name, caller, to)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	err = common.SetEvent(stub, action.Event, pledge)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	return shim.Success(nil)
+}
+
+// disburse computes the schedule of the loan of the amount of the pledge, at
+// the rate of the credit of the bank
+// args: [term]
+func disburse(stub shim.ChaincodeStubInterface, pledge *Pledge, args []string) (string, error) {
+	term := defaultTerm
+	if len(args) > 0 {
+		term, _ = strconv.Atoi(args[0])
+		if term < 1 || term > maxTerm {
+			return "", fmt.Errorf("term argument is incorrect, expecting 1 to %d", maxTerm)
+		}
+	}
+	credits, err := getCredits(stub, pledge.Company, pledge.Id)
+	if err != nil {
+		return "", err
+	}
+	i := findCredit(credits, pledge.Bank)
+	if i < 0 {
+		return "", fmt.Errorf("the bank did not credit this insurance")
+	}
+	disbursed, err := txTime(stub)
+	if err != nil {
+		return "", err
+	}
+	pledge.Loan, err = newLoan(pledge.Amount, credits[i].Rate, term, disbursed)
+	if err != nil {
+		return "", err
+	}
+	return PledgeDisbursed, nil
+}
+
+// repay allocates a repayment to the loan, all the outstanding balance by
+// default. The pledge is repaid when nothing remains.
+// args: [amount]
+func repay(stub shim.ChaincodeStubInterface, pledge *Pledge, args []string) (string, error) {
+	if pledge.Loan == nil {
+		return PledgeRepaid, nil
+	}
+	txTimestamp, err := stub.GetTxTimestamp()
+	if err != nil {
+		return "", fmt.Errorf("Failed getting transaction timestamp: %s", err)
+	}
+	date := dateOf(time.Unix(txTimestamp.Seconds, 0))
+	balance, err := pledge.Loan.balance(date)
+	if err != nil {
+		return "", err
+	}
+	amount := balance.Outstanding
+	if len(args) > 0 {
+		amount, err = parseCurrency(args[0])
+		if err != nil {
+			return "", fmt.Errorf("amount argument is incorrect: %s", err)
+		}
+	}
+	_, err = pledge.Loan.repay(amount, stub.GetTxID(), txTimestamp.Seconds)
+	if err != nil {
+		return "", err
+	}
+	balance, err = pledge.Loan.balance(date)
+	if err != nil {
+		return "", err
+	}
+	if balance.Outstanding.IsZero() {
+		return PledgeRepaid, nil
+	}
+	return PledgeDisbursed, nil
+}
+
+// checkOverdue allows the bank to default a loan with an installment overdue
+func checkOverdue(stub shim.ChaincodeStubInterface, pledge *Pledge, args []string) (string, error) {
+	if pledge.Loan == nil {
+		return PledgeDefaulted, nil
+	}
+	date, err := txDate(stub)
+	if err != nil {
+		return "", err
+	}
+	balance, err := pledge.Loan.balance(date)
+	if err != nil {
+		return "", err
+	}
+	if len(balance.OverdueInstallments) == 0 {
+		return "", fmt.Errorf("the loan has no overdue installment")
+	}
+	return PledgeDefaulted, nil
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/chaincode/mockstub"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+func assertPledge(t *testing.T, stub *mockstub.MockStub, id, state string, actions ...string) *Pledge {
+	t.Helper()
+	pledge, err := getPledge(stub, idIns, id)
+	if err != nil || pledge == nil {
+		t.Fatalf("pledge of %s %v, %v", id, pledge, err)
+	}
+	if pledge.State != state || len(pledge.History) != len(actions) {
+		t.Fatalf("pledge of %s %+v", id, pledge)
+	}
+	for i, action := range actions {
+		if pledge.History[i].Action != action {
+			t.Fatalf("transition %d of %s %+v", i, id, pledge.History[i])
+		}
+	}
+	return pledge
+}
+
+// idIns and idBank are the IDs of the company and the bank of newInsuranceStub
+var idIns, idBank = id("ins"), id("bank")
+
+func TestPledgeMovesThroughItsStates(t *testing.T) {
+	stub := newInsuranceStub(t)
+	invoke(t, stub, "tx1", "assign", id("alice"), "p1", "1000.50")
+	as(t, stub, "bank", "tx2", "credit", idIns, "p1", "20180101", "500", "500")
+
+	stub.SetCreator("OrgMSP", []byte("alice"))
+	invokeFails(t, stub, "tx3", "apply", idIns, "p1", id("other"))
+	invokeFails(t, stub, "tx4", "pay", idIns, "p1", idBank)
+	invoke(t, stub, "tx5", "apply", idIns, "p1", idBank)
+	if message := invokeFails(t, stub, "tx6", "apply", idIns, "p1", idBank); message != "this insurance is already pledged" {
+		t.Fatal(message)
+	}
+	if message := invokeFails(t, stub, "tx7", "approve", idIns, "p1", idBank); message != "the caller can not approve this pledge" {
+		t.Fatal(message)
+	}
+	as(t, stub, "ins", "tx8", "assign", id("bob"), "p2", "1")
+	stub.SetCreator("OrgMSP", []byte("bob"))
+	invokeFails(t, stub, "tx9", "cancel", idIns, "p1", idBank)
+
+	stub.SetCreator("OrgMSP", []byte("bank"))
+	if message := invokeFails(t, stub, "tx10", "loan", idIns, "p1", idBank); message != "the pledge is applied, can not loan it" {
+		t.Fatal(message)
+	}
+	invoke(t, stub, "tx11", "approve", idIns, "p1", idBank)
+	invoke(t, stub, "tx12", "loan", idIns, "p1", idBank)
+	as(t, stub, "alice", "tx13", "pay", idIns, "p1", idBank)
+	as(t, stub, "bank", "tx14", "confirm", idIns, "p1", idBank)
+	assertPledge(t, stub, "p1", PledgeClosed, "apply", "approve", "loan", "pay", "confirm")
+	invokeFails(t, stub, "tx15", "cancel", idIns, "p1", idBank)
+
+	// a closed pledge is applied for again, and cancelled by the holder
+	as(t, stub, "alice", "tx16", "apply", idIns, "p1", idBank)
+	invoke(t, stub, "tx17", "cancel", idIns, "p1", idBank)
+	pledge := assertPledge(t, stub, "p1", PledgeClosed, "apply", "approve", "loan", "pay", "confirm", "apply", "cancel")
+	if pledge.History[6].Caller != id("alice") || pledge.History[6].From != PledgeApplied || pledge.History[6].TxID != "tx17" {
+		t.Fatalf("transition %+v", pledge.History[6])
+	}
+}
+
+func TestPolicyIsHeldByOneOwner(t *testing.T) {
+	stub := newInsuranceStub(t)
+	invoke(t, stub, "tx1", "assign", id("alice"), "p1", "100")
+	if message := invokeFails(t, stub, "tx2", "assign", id("bob"), "p1", "100"); !strings.HasPrefix(message, "this insurance is already assigned") {
+		t.Fatal(message)
+	}
+	invokeFails(t, stub, "tx3", "assign", "", "p2", "100")
+
+	// the policies stored before the owner index are indexed by the upgrade
+	legacyKey, err := stub.CreateCompositeKey(policyIndex, []string{id("carol"), idIns, "p2"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	stub.State[legacyKey] = []byte(`{"owner":"` + id("carol") + `","id":"p2","company":"` + idIns + `","balance":"10"}`)
+	stub.SetCreator("AdminMSP", []byte("upgrader"))
+	if response := stub.MockInit("upgrade", []string{"init"}); response.Status != shim.OK {
+		t.Fatal(response.Message)
+	}
+	if owner, err := getPolicyOwner(stub, idIns, "p2"); err != nil || owner != id("carol") {
+		t.Fatalf("owner %s, %v", owner, err)
+	}
+	stub.SetCreator("OrgMSP", []byte("ins"))
+	invokeFails(t, stub, "tx4", "assign", id("bob"), "p2", "100")
+
+	// a policy held by two owners fails the upgrade
+	duplicateKey, err := stub.CreateCompositeKey(policyIndex, []string{id("dave"), idIns, "p2"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	stub.State[duplicateKey] = stub.State[legacyKey]
+	if response := stub.MockInit("upgrade2", []string{"init"}); response.Status == shim.OK || !strings.Contains(response.Message, "is held by") {
+		t.Fatalf("upgrade %d %s", response.Status, response.Message)
+	}
+}
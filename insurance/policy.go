@@ -0,0 +1,535 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/chaincode/common"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+const (
+	// policyIndex stores each policy under its own key: owner~company~id
+	policyIndex = "owner~company~id"
+	// policyOwnerIndex keys the owner of each policy: company~id, so that a
+	// policy is held by one owner
+	policyOwnerIndex = "company~id"
+	// transferPrefix keys the pending transfer of a policy: transfer_ + company + id
+	transferPrefix = "transfer_"
+)
+
+// The states of a policy. A policy is active until the company ends it.
+const (
+	PolicyActive = iota
+	PolicySurrendered
+	PolicyMatured
+	PolicyLapsed
+	PolicyClaimed
+)
+
+// policyStates are the names of the states of a policy, by state
+var policyStates = []string{"active", "surrendered", "matured", "lapsed", "claimed"}
+
+// The kinds of the transfers of a policy
+const (
+	// TransferOwner moves the policy to the target as its owner
+	TransferOwner = "owner"
+	// TransferBeneficiary makes the target the beneficiary of the policy
+	TransferBeneficiary = "beneficiary"
+)
+
+// Transfer is the request of the holder of a policy to transfer it, pending
+// until the company approves or rejects it
+type Transfer struct {
+	Id        string `json:"id"`
+	Company   string `json:"company"`
+	Owner     string `json:"owner"`
+	Kind      string `json:"kind"`
+	Target    string `json:"target"`
+	TxID      string `json:"txId"`
+	Timestamp int64  `json:"timestamp"`
+}
+
+// PolicyMigration is the payload of the event emitted by migrate
+type PolicyMigration struct {
+	Owners   []string `json:"owners"`
+	Policies int      `json:"policies"`
+}
+
+// getPolicy reads the policy id of company held by owner, nil when owner does
+// not hold it
+func getPolicy(stub shim.ChaincodeStubInterface, owner, company, id string) (*Policy, error) {
+	key, err := stub.CreateCompositeKey(policyIndex, []string{owner, company, id})
+	if err != nil {
+		return nil, err
+	}
+	var policy Policy
+	found, err := common.GetJSON(stub, key, &policy)
+	if err != nil || !found {
+		return nil, err
+	}
+	return &policy, nil
+}
+
+// getPolicies reads the policies of owner
+func getPolicies(stub shim.ChaincodeStubInterface, owner string) ([]Policy, error) {
+	policiesIterator, err := stub.GetStateByPartialCompositeKey(policyIndex, []string{owner})
+	if err != nil {
+		return nil, err
+	}
+	defer policiesIterator.Close()
+
+	var policies []Policy
+	for policiesIterator.HasNext() {
+		_, value, err := policiesIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+		var policy Policy
+		err = json.Unmarshal(value, &policy)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to decode policy: %s", err)
+		}
+		policies = append(policies, policy)
+	}
+	return policies, nil
+}
+
+// putPolicy stores a policy under its owner, and records its owner
+func putPolicy(stub shim.ChaincodeStubInterface, policy *Policy) error {
+	key, err := stub.CreateCompositeKey(policyIndex, []string{policy.Owner, policy.Company, policy.Id})
+	if err != nil {
+		return err
+	}
+	err = common.PutJSON(stub, key, policy)
+	if err != nil {
+		return err
+	}
+	return putPolicyOwner(stub, policy.Owner, policy.Company, policy.Id)
+}
+
+// delPolicy deletes the policy id of company held by owner, and its owner
+func delPolicy(stub shim.ChaincodeStubInterface, owner, company, id string) error {
+	key, err := stub.CreateCompositeKey(policyIndex, []string{owner, company, id})
+	if err != nil {
+		return err
+	}
+	err = stub.DelState(key)
+	if err != nil {
+		return err
+	}
+	ownerKey, err := stub.CreateCompositeKey(policyOwnerIndex, []string{company, id})
+	if err != nil {
+		return err
+	}
+	return stub.DelState(ownerKey)
+}
+
+// getPolicyOwner returns the owner of the policy id of company, empty when
+// the policy is not assigned
+func getPolicyOwner(stub shim.ChaincodeStubInterface, company, id string) (string, error) {
+	ownerKey, err := stub.CreateCompositeKey(policyOwnerIndex, []string{company, id})
+	if err != nil {
+		return "", err
+	}
+	owner, err := stub.GetState(ownerKey)
+	if err != nil {
+		return "", fmt.Errorf("Failed to get the owner of %s, %s: %s", company, id, err)
+	}
+	return string(owner), nil
+}
+
+// putPolicyOwner records owner as the owner of the policy id of company
+func putPolicyOwner(stub shim.ChaincodeStubInterface, owner, company, id string) error {
+	ownerKey, err := stub.CreateCompositeKey(policyOwnerIndex, []string{company, id})
+	if err != nil {
+		return err
+	}
+	return stub.PutState(ownerKey, []byte(owner))
+}
+
+// policyOwners are the owners of the policies recorded by a transaction,
+// which can not read its own writes, by company~id key
+type policyOwners map[string]string
+
+// add records owner as the owner of the policy id of company, failing when
+// another owner holds it. It reports whether the owner was not recorded yet.
+func (owners policyOwners) add(stub shim.ChaincodeStubInterface, owner, company, id string) (bool, error) {
+	ownerKey, err := stub.CreateCompositeKey(policyOwnerIndex, []string{company, id})
+	if err != nil {
+		return false, err
+	}
+	held, ok := owners[ownerKey]
+	if !ok {
+		held, err = getPolicyOwner(stub, company, id)
+		if err != nil {
+			return false, err
+		}
+	}
+	if held != "" && held != owner {
+		return false, fmt.Errorf("the insurance %s, %s is held by %s and %s", company, id, held, owner)
+	}
+	owners[ownerKey] = owner
+	return held == "", nil
+}
+
+// indexPolicies records the owners of the policies stored before the owner
+// index in owners, failing when two owners hold the same policy. It returns
+// the number of owners recorded.
+func indexPolicies(stub shim.ChaincodeStubInterface, owners policyOwners) (int, error) {
+	policiesIterator, err := stub.GetStateByPartialCompositeKey(policyIndex, []string{})
+	if err != nil {
+		return 0, err
+	}
+	defer policiesIterator.Close()
+
+	indexed := 0
+	for policiesIterator.HasNext() {
+		key, _, err := policiesIterator.Next()
+		if err != nil {
+			return indexed, err
+		}
+		_, keyParts, err := stub.SplitCompositeKey(key)
+		if err != nil {
+			return indexed, err
+		}
+		owner, company, id := keyParts[0], keyParts[1], keyParts[2]
+		added, err := owners.add(stub, owner, company, id)
+		if err != nil {
+			return indexed, err
+		}
+		if !added {
+			continue
+		}
+		err = putPolicyOwner(stub, owner, company, id)
+		if err != nil {
+			return indexed, err
+		}
+		indexed++
+	}
+	return indexed, nil
+}
+
+// getHeldPolicy reads the policy id of company held by user, failing when
+// user is not the owner recorded under company~id
+func getHeldPolicy(stub shim.ChaincodeStubInterface, user, company, id string) (*Policy, error) {
+	owner, err := getPolicyOwner(stub, company, id)
+	if err != nil {
+		return nil, err
+	}
+	if owner != user {
+		return nil, fmt.Errorf("user did not have this insurance, %s, %s", company, id)
+	}
+	policy, err := getPolicy(stub, owner, company, id)
+	if err != nil {
+		return nil, err
+	}
+	if policy == nil {
+		return nil, fmt.Errorf("the owner index of %s, %s is out of date", company, id)
+	}
+	return policy, nil
+}
+
+// checkHolder checks that user holds the policy id of company
+func checkHolder(stub shim.ChaincodeStubInterface, user, company, id string) error {
+	_, err := getHeldPolicy(stub, user, company, id)
+	return err
+}
+
+// checkActive fails when the policy is ended or pledged to a bank
+func checkActive(stub shim.ChaincodeStubInterface, policy *Policy) error {
+	if policy.State != PolicyActive {
+		return fmt.Errorf("the insurance is %s", policyStates[policy.State])
+	}
+	pledge, err := getPledge(stub, policy.Company, policy.Id)
+	if err != nil {
+		return err
+	}
+	if pledge != nil && pledge.State != PledgeClosed {
+		return fmt.Errorf("the insurance is pledged to a bank, the pledge is %s", pledge.State)
+	}
+	return nil
+}
+
+// getCallerCompany returns the calling insurance company, failing when the
+// caller is not a registered company
+func getCallerCompany(stub shim.ChaincodeStubInterface) (string, error) {
+	company, err := getCaller(stub)
+	if err != nil {
+		return "", err
+	}
+	if !isInsuranceOrBank(insurancePrefix+company, stub) {
+		return "", errors.New("caller is not insurance company")
+	}
+	return company, nil
+}
+
+// setPolicyState ends a policy of the calling company as surrendered,
+// matured, lapsed or claimed. The policy must be active and not pledged.
+// args: owner, id, state
+func (t *InsuranceChaincode) setPolicyState(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	company, err := getCallerCompany(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	owner := args[0]
+	id := args[1]
+	state := -1
+	for s, name := range policyStates {
+		if name == args[2] && s != PolicyActive {
+			state = s
+		}
+	}
+	if state < 0 {
+		return shim.Error("state argument is incorrect, expecting surrendered, matured, lapsed or claimed")
+	}
+
+	policy, err := getHeldPolicy(stub, owner, company, id)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	err = checkActive(stub, policy)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	policy.State = state
+
+	err = putPolicy(stub, policy)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	err = common.SetEvent(stub, EventPolicyStateChanged, policy)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(nil)
+}
+
+// requestTransfer asks the company to transfer a policy of the caller to a
+// new owner or to a new beneficiary. The policy must be active and not
+// pledged, and a policy has one pending transfer at most.
+// args: company, id, kind, target
+func (t *InsuranceChaincode) requestTransfer(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	user, err := getCaller(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	company := args[0]
+	id := args[1]
+	kind := args[2]
+	target := args[3]
+	if kind != TransferOwner && kind != TransferBeneficiary {
+		return shim.Error("kind argument is incorrect, expecting owner or beneficiary")
+	}
+	if target == "" || (kind == TransferOwner && target == user) {
+		return shim.Error("target argument is incorrect")
+	}
+
+	policy, err := getHeldPolicy(stub, user, company, id)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	err = checkActive(stub, policy)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	transferString, err := stub.GetState(transferPrefix + company + id)
+	if err != nil {
+		return shim.Error("Failed to get state of " + transferPrefix + company + id + ": " + err.Error())
+	}
+	if transferString != nil {
+		return shim.Error("this insurance already has a pending transfer")
+	}
+
+	txTimestamp, err := stub.GetTxTimestamp()
+	if err != nil {
+		return shim.Error("Failed getting transaction timestamp: " + err.Error())
+	}
+	transfer := Transfer{
+		Id:        id,
+		Company:   company,
+		Owner:     user,
+		Kind:      kind,
+		Target:    target,
+		TxID:      stub.GetTxID(),
+		Timestamp: txTimestamp.Seconds,
+	}
+	err = common.PutJSON(stub, transferPrefix+company+id, transfer)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	err = common.SetEvent(stub, EventTransferRequested, transfer)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(nil)
+}
+
+// getTransfer reads the pending transfer of a policy of the calling company
+func getTransfer(stub shim.ChaincodeStubInterface, id string) (*Transfer, error) {
+	company, err := getCallerCompany(stub)
+	if err != nil {
+		return nil, err
+	}
+	var transfer Transfer
+	found, err := common.GetJSON(stub, transferPrefix+company+id, &transfer)
+	if err != nil {
+		return nil, err
+	}
+	if !found {
+		return nil, errors.New("this insurance has no pending transfer")
+	}
+	return &transfer, nil
+}
+
+// approveTransfer applies the pending transfer of a policy of the calling
+// company. The policy must still be active and not pledged.
+// args: id
+func (t *InsuranceChaincode) approveTransfer(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	transfer, err := getTransfer(stub, args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	policy, err := getHeldPolicy(stub, transfer.Owner, transfer.Company, transfer.Id)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	err = checkActive(stub, policy)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	if transfer.Kind == TransferBeneficiary {
+		policy.Beneficiary = transfer.Target
+	} else {
+		err = delPolicy(stub, policy.Owner, policy.Company, policy.Id)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		policy.Owner = transfer.Target
+	}
+	err = putPolicy(stub, policy)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	err = stub.DelState(transferPrefix + transfer.Company + transfer.Id)
+	if err != nil {
+		return shim.Error("delete transfer failed: " + err.Error())
+	}
+	err = common.SetEvent(stub, EventTransferApproved, transfer)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(nil)
+}
+
+// rejectTransfer discards the pending transfer of a policy of the calling
+// company
+// args: id
+func (t *InsuranceChaincode) rejectTransfer(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	transfer, err := getTransfer(stub, args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	err = stub.DelState(transferPrefix + transfer.Company + transfer.Id)
+	if err != nil {
+		return shim.Error("delete transfer failed: " + err.Error())
+	}
+	err = common.SetEvent(stub, EventTransferRejected, transfer)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(nil)
+}
+
+// legacyOwners returns the owners with a list of policies stored under
+// user_ + owner
+func legacyOwners(stub shim.ChaincodeStubInterface) ([]string, error) {
+	usersIterator, err := stub.GetStateByRange(userPrefix, userPrefix+string(utf8.MaxRune))
+	if err != nil {
+		return nil, err
+	}
+	defer usersIterator.Close()
+
+	var owners []string
+	for usersIterator.HasNext() {
+		key, _, err := usersIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+		owners = append(owners, strings.TrimPrefix(key, userPrefix))
+	}
+	return owners, nil
+}
+
+// migratePolicies moves the policies of owners from the list stored under
+// user_ + owner to one key per policy, recording their owners in
+// policyOwners, and deletes the lists. A policy held by another owner fails
+// the migration.
+func migratePolicies(stub shim.ChaincodeStubInterface, owners []string, policyOwners policyOwners) (*PolicyMigration, error) {
+	migration := &PolicyMigration{Owners: []string{}}
+	for _, owner := range owners {
+		var policies []Policy
+		found, err := common.GetJSON(stub, userPrefix+owner, &policies)
+		if err != nil {
+			return nil, err
+		}
+		if !found {
+			continue
+		}
+		for i := range policies {
+			policies[i].Owner = owner
+			_, err = policyOwners.add(stub, owner, policies[i].Company, policies[i].Id)
+			if err != nil {
+				return nil, err
+			}
+			err = putPolicy(stub, &policies[i])
+			if err != nil {
+				return nil, err
+			}
+		}
+		err = stub.DelState(userPrefix + owner)
+		if err != nil {
+			return nil, fmt.Errorf("delete policies failed: %s", err)
+		}
+		migration.Owners = append(migration.Owners, owner)
+		migration.Policies += len(policies)
+	}
+	return migration, nil
+}
+
+// migrate moves the policies of owners from the list stored under user_ +
+// owner to one key per policy, and deletes the list. A policy held by
+// another owner fails the migration. The owners are the ones given, or all
+// the owners with a list when none is given.
+// Init already migrates all the owners when the chaincode is upgraded, this
+// function migrates the owners again. Only an admin can call it.
+// args: [owner...]
+func (t *InsuranceChaincode) migrate(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	owners := args
+	if len(owners) == 0 {
+		var err error
+		owners, err = legacyOwners(stub)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+	}
+
+	migration, err := migratePolicies(stub, owners, make(policyOwners))
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	migrationJSONasBytes, err := json.Marshal(migration)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	err = common.SetEvent(stub, EventPoliciesMigrated, migration)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(migrationJSONasBytes)
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+func TestPolicyLifecycleFollowsItsOwner(t *testing.T) {
+	stub := newInsuranceStub(t)
+	invoke(t, stub, "tx1", "assign", id("alice"), "p1", "100")
+	as(t, stub, "alice", "tx2", "requestTransfer", idIns, "p1", "owner", id("bob"))
+	as(t, stub, "ins", "tx3", "approveTransfer", "p1")
+	if results := queryUser(t, stub, id("alice")); len(results) != 0 {
+		t.Fatalf("policies of the former owner %+v", results)
+	}
+
+	// the former owner can not act on the policy any more, even with a stale
+	// record of the policy
+	staleKey, err := stub.CreateCompositeKey(policyIndex, []string{id("alice"), idIns, "p1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	stub.State[staleKey] = []byte(`{"owner":"` + id("alice") + `","id":"p1","company":"` + idIns + `","balance":"100"}`)
+	stub.SetCreator("OrgMSP", []byte("alice"))
+	for _, args := range [][]string{
+		{"requestTransfer", idIns, "p1", "beneficiary", id("carol")},
+		{"apply", idIns, "p1", idBank},
+	} {
+		if message := invokeFails(t, stub, "tx4", args...); !strings.HasPrefix(message, "user did not have this insurance") {
+			t.Fatalf("%v: %s", args, message)
+		}
+	}
+	stub.SetCreator("OrgMSP", []byte("ins"))
+	invokeFails(t, stub, "tx5", "setPolicyState", id("alice"), "p1", "surrendered")
+	invoke(t, stub, "tx6", "setPolicyState", id("bob"), "p1", "surrendered")
+	if results := queryUser(t, stub, id("bob")); len(results) != 1 || results[0].Insurance.State != PolicySurrendered {
+		t.Fatalf("policies of the owner %+v", results)
+	}
+
+	// a pledged policy is neither transferred nor ended
+	invoke(t, stub, "tx7", "assign", id("carol"), "p2", "100")
+	as(t, stub, "bank", "tx8", "credit", idIns, "p2", "0", "50", "500")
+	as(t, stub, "carol", "tx9", "apply", idIns, "p2", idBank)
+	if message := invokeFails(t, stub, "tx10", "requestTransfer", idIns, "p2", "owner", id("dave")); message != "the insurance is pledged to a bank, the pledge is applied" {
+		t.Fatal(message)
+	}
+	stub.SetCreator("OrgMSP", []byte("ins"))
+	invokeFails(t, stub, "tx11", "setPolicyState", id("carol"), "p2", "matured")
+}
+
+func TestUpgradeMigratesThePolicyLists(t *testing.T) {
+	stub := newInsuranceStub(t)
+	// the layout before the upgrade: a list of policies under user_ + owner
+	stub.State[userPrefix+id("alice")] = []byte(`[{"id":"p1","company":"` + idIns + `","balance":100},{"id":"p2","company":"` + idIns + `","balance":50}]`)
+	stub.State[userPrefix+id("bob")] = []byte(`[{"id":"p1","company":"` + idIns + `","balance":100}]`)
+	stub.SetCreator("AdminMSP", []byte("admin"))
+	if response := stub.MockInit("upgrade1", []string{"init"}); response.Status == shim.OK || !strings.Contains(response.Message, "is held by") {
+		t.Fatalf("upgrade %d %s", response.Status, response.Message)
+	}
+
+	delete(stub.State, userPrefix+id("bob"))
+	if response := stub.MockInit("upgrade2", []string{"init"}); response.Status != shim.OK {
+		t.Fatal(response.Message)
+	}
+	if stub.State[userPrefix+id("alice")] != nil {
+		t.Fatal("list not migrated")
+	}
+	if results := queryUser(t, stub, id("alice")); len(results) != 2 || results[0].Insurance.Owner != id("alice") {
+		t.Fatalf("policies %+v", results)
+	}
+	as(t, stub, "ins", "tx1", "assign", id("bob"), "p3", "10")
+	invokeFails(t, stub, "tx2", "assign", id("bob"), "p1", "10")
+	as(t, stub, "alice", "tx3", "requestTransfer", idIns, "p2", "owner", id("bob"))
+}
@@ -0,0 +1,284 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+const (
+	// pledgeIndex lists the pledges of a bank by section:
+	// bank~section~company~id
+	pledgeIndex = "bank~section~company~id"
+
+	defaultPortfolioPageSize = 20
+	maxPortfolioPageSize     = 200
+)
+
+// The sections of the portfolio of a bank
+const (
+	// SectionCredits lists the credit lines of the bank
+	SectionCredits = "credits"
+	// SectionApplications lists the pledges applied and approved
+	SectionApplications = "applications"
+	// SectionLoans lists the pledges disbursed and repaid, until the bank
+	// confirms them
+	SectionLoans = "loans"
+	// SectionDefaults lists the pledges defaulted, until the bank confirms
+	// them
+	SectionDefaults = "defaults"
+)
+
+// pledgeSections are the sections of the pledges by state. The closed
+// pledges are not listed.
+var pledgeSections = map[string]string{
+	PledgeApplied:   SectionApplications,
+	PledgeApproved:  SectionApplications,
+	PledgeDisbursed: SectionLoans,
+	PledgeRepaid:    SectionLoans,
+	PledgeDefaulted: SectionDefaults,
+}
+
+// PortfolioPledge is a pledge of the portfolio of a bank, with the balance of
+// its loan at the date of the query once disbursed
+type PortfolioPledge struct {
+	Pledge
+	Balance *Balance `json:"balance,omitempty"`
+}
+
+// PortfolioPage is one page of a section of the portfolio of a bank.
+// Bookmark is empty on the last page, otherwise it is passed back to get the
+// next page.
+type PortfolioPage struct {
+	Section  string            `json:"section"`
+	Credits  []Credit          `json:"credits,omitempty"`
+	Pledges  []PortfolioPledge `json:"pledges,omitempty"`
+	Bookmark string            `json:"bookmark,omitempty"`
+}
+
+// indexPledge moves the pledge of a bank from the section of the state from
+// to the section of its state
+func indexPledge(stub shim.ChaincodeStubInterface, pledge *Pledge, from string) error {
+	oldSection, newSection := pledgeSections[from], pledgeSections[pledge.State]
+	if oldSection == newSection {
+		return nil
+	}
+	if oldSection != "" {
+		oldKey, err := stub.CreateCompositeKey(pledgeIndex, []string{pledge.Bank, oldSection, pledge.Company, pledge.Id})
+		if err != nil {
+			return err
+		}
+		err = stub.DelState(oldKey)
+		if err != nil {
+			return err
+		}
+	}
+	if newSection != "" {
+		newKey, err := stub.CreateCompositeKey(pledgeIndex, []string{pledge.Bank, newSection, pledge.Company, pledge.Id})
+		if err != nil {
+			return err
+		}
+		return stub.PutState(newKey, []byte{0x00})
+	}
+	return nil
+}
+
+// indexPledges adds the pledges stored before the pledge index to the index,
+// the pledges recorded as apply_ keys included, and returns the number of
+// pledges indexed
+func indexPledges(stub shim.ChaincodeStubInterface) (int, error) {
+	indexed := make(map[string]bool)
+	for _, prefix := range []string{pledgePrefix, applyPrefix} {
+		err := indexPledgesOf(stub, prefix, indexed)
+		if err != nil {
+			return len(indexed), err
+		}
+	}
+	return len(indexed), nil
+}
+
+// indexPledgesOf adds the pledges of the policies with a prefix key to the
+// index, and their index keys to indexed as a transaction can not read its
+// own writes. The apply_ and pledge_ values both have the company and the id
+// of the policy.
+func indexPledgesOf(stub shim.ChaincodeStubInterface, prefix string, indexed map[string]bool) error {
+	pledgesIterator, err := stub.GetStateByRange(prefix, prefix+string(utf8.MaxRune))
+	if err != nil {
+		return err
+	}
+	defer pledgesIterator.Close()
+
+	for pledgesIterator.HasNext() {
+		_, value, err := pledgesIterator.Next()
+		if err != nil {
+			return err
+		}
+		var apply Apply
+		err = json.Unmarshal(value, &apply)
+		if err != nil {
+			return err
+		}
+		pledge, err := getPledge(stub, apply.Company, apply.Id)
+		if err != nil {
+			return err
+		}
+		if pledge == nil {
+			return fmt.Errorf("the pledge of %s %s can not be read", apply.Company, apply.Id)
+		}
+		section := pledgeSections[pledge.State]
+		if section == "" {
+			continue
+		}
+		indexKey, err := stub.CreateCompositeKey(pledgeIndex, []string{pledge.Bank, section, pledge.Company, pledge.Id})
+		if err != nil {
+			return err
+		}
+		indexValue, err := stub.GetState(indexKey)
+		if err != nil {
+			return err
+		}
+		if indexValue != nil || indexed[indexKey] {
+			continue
+		}
+		err = stub.PutState(indexKey, []byte{0x00})
+		if err != nil {
+			return err
+		}
+		indexed[indexKey] = true
+	}
+	return nil
+}
+
+// queryBank pages through a section of the portfolio of the calling bank:
+// its credits, the applications, the loans or the defaults of its pledges
+// args: section, [pageSize], [bookmark]
+func (t *InsuranceChaincode) queryBank(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	bank, err := getCallerBank(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	section := args[0]
+	pageSize := defaultPortfolioPageSize
+	if len(args) > 1 && args[1] != "" {
+		size, err := strconv.Atoi(args[1])
+		if err != nil || size <= 0 {
+			return shim.Error("page size must be a positive integer")
+		}
+		pageSize = size
+	}
+	if pageSize > maxPortfolioPageSize {
+		pageSize = maxPortfolioPageSize
+	}
+	bookmark := ""
+	if len(args) > 2 {
+		bookmark = args[2]
+	}
+
+	var prefix string
+	switch section {
+	case SectionCredits:
+		prefix, err = stub.CreateCompositeKey(creditIndex, []string{bank})
+	case SectionApplications, SectionLoans, SectionDefaults:
+		prefix, err = stub.CreateCompositeKey(pledgeIndex, []string{bank, section})
+	default:
+		return shim.Error("section argument is incorrect, expecting credits, applications, loans or defaults")
+	}
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	startKey := prefix
+	if bookmark != "" {
+		if !strings.HasPrefix(bookmark, prefix) {
+			return shim.Error("bookmark does not belong to this section")
+		}
+		startKey = bookmark
+	}
+	date, err := txDate(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	resultsIterator, err := stub.GetStateByRange(startKey, prefix+string(utf8.MaxRune))
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	defer resultsIterator.Close()
+
+	page := PortfolioPage{Section: section}
+	count := 0
+	lastKey := ""
+	for resultsIterator.HasNext() {
+		key, _, err := resultsIterator.Next()
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		if key == bookmark {
+			continue
+		}
+		if count == pageSize {
+			page.Bookmark = lastKey
+			break
+		}
+		_, keyParts, err := stub.SplitCompositeKey(key)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		company, id := keyParts[len(keyParts)-2], keyParts[len(keyParts)-1]
+		if section == SectionCredits {
+			err = appendCredit(stub, &page, bank, company, id)
+		} else {
+			err = appendPledge(stub, &page, date, company, id)
+		}
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		count++
+		lastKey = key
+	}
+
+	pageJSONasBytes, err := json.Marshal(page)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(pageJSONasBytes)
+}
+
+// appendCredit adds the credit of bank on a policy to the page
+func appendCredit(stub shim.ChaincodeStubInterface, page *PortfolioPage, bank, company, id string) error {
+	credits, err := getCredits(stub, company, id)
+	if err != nil {
+		return err
+	}
+	i := findCredit(credits, bank)
+	if i < 0 {
+		return fmt.Errorf("the credit index of %s %s is out of date", company, id)
+	}
+	page.Credits = append(page.Credits, credits[i])
+	return nil
+}
+
+// appendPledge adds the pledge of a policy to the page, with the balance of
+// its loan at date
+func appendPledge(stub shim.ChaincodeStubInterface, page *PortfolioPage, date int, company, id string) error {
+	pledge, err := getPledge(stub, company, id)
+	if err != nil {
+		return err
+	}
+	if pledge == nil {
+		return fmt.Errorf("the pledge index of %s %s is out of date", company, id)
+	}
+	entry := PortfolioPledge{Pledge: *pledge}
+	if pledge.Loan != nil {
+		entry.Balance, err = pledge.Loan.balance(date)
+		if err != nil {
+			return err
+		}
+	}
+	page.Pledges = append(page.Pledges, entry)
+	return nil
+}
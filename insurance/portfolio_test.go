@@ -0,0 +1,63 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	"github.com/chaincode/mockstub"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+// queryPortfolio returns the ids and the states of the pledges of a section
+// of the portfolio of "bank"
+func queryPortfolio(t *testing.T, stub *mockstub.MockStub, section string) []string {
+	t.Helper()
+	var page PortfolioPage
+	if err := json.Unmarshal(as(t, stub, "bank", "portfolio", "queryBank", section), &page); err != nil {
+		t.Fatal(err)
+	}
+	ids := []string{}
+	for _, pledge := range page.Pledges {
+		ids = append(ids, pledge.Id+" "+pledge.State)
+	}
+	return ids
+}
+
+func TestPortfolioOfThePledgesBeforeTheUpgrade(t *testing.T) {
+	stub := newInsuranceStub(t)
+	apply := `{"id":"%s","company":"` + idIns + `","bank":"` + idBank + `"}`
+	for _, id := range []string{"p1", "p2", "p3"} {
+		stub.State[applyPrefix+idIns+id] = []byte(fmt.Sprintf(apply, id))
+	}
+	stub.State[loanPrefix+idIns+"p1"] = []byte(fmt.Sprintf(apply, "p1"))
+	stub.State[confirmPrefix+idIns+"p3"] = []byte(fmt.Sprintf(apply, "p3"))
+	// a pledge stored before the pledge index, and pledged again since the
+	// apply_ key
+	stub.State[pledgePrefix+idIns+"p3"] = []byte(`{"id":"p3","company":"` + idIns + `","bank":"` + idBank +
+		`","state":"approved","history":[{"action":"apply","to":"applied"},{"action":"approve","from":"applied","to":"approved"}]}`)
+	if ids := queryPortfolio(t, stub, SectionApplications); len(ids) != 0 {
+		t.Fatalf("applications %v", ids)
+	}
+
+	stub.SetCreator("AdminMSP", []byte("upgrader"))
+	if response := stub.MockInit("upgrade", []string{"init"}); response.Status != shim.OK {
+		t.Fatal(response.Message)
+	}
+	if ids := queryPortfolio(t, stub, SectionApplications); len(ids) != 2 || ids[0] != "p2 applied" || ids[1] != "p3 approved" {
+		t.Fatalf("applications %v", ids)
+	}
+	if ids := queryPortfolio(t, stub, SectionLoans); len(ids) != 1 || ids[0] != "p1 disbursed" {
+		t.Fatalf("loans %v", ids)
+	}
+}
+
+func TestUpgradeFailsOnAnUnresolvedPledge(t *testing.T) {
+	stub := newInsuranceStub(t)
+	// the company and the id of the value do not match its key
+	stub.State[pledgePrefix+idIns+"p1"] = []byte(`{"id":"p2","company":"` + idIns + `","bank":"` + idBank + `","state":"applied"}`)
+	stub.SetCreator("AdminMSP", []byte("upgrader"))
+	if response := stub.MockInit("upgrade", []string{"init"}); response.Status == shim.OK || response.Message != "the pledge of "+idIns+" p2 can not be read" {
+		t.Fatalf("upgrade %d %s", response.Status, response.Message)
+	}
+}
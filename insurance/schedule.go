@@ -0,0 +1,234 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/chaincode/decimal"
+)
+
+const (
+	// defaultTerm is the number of monthly installments of a loan when the
+	// bank does not give it
+	defaultTerm = 12
+	// maxTerm is the largest number of monthly installments of a loan
+	maxTerm = 360
+	// rateDenominator converts an annual rate in basis points into a daily
+	// rate
+	rateDenominator = 10000 * 365
+)
+
+// Installment is a monthly payment of the principal of a loan. Due is a date
+// as yyyymmdd.
+type Installment struct {
+	Number        int            `json:"number"`
+	Due           int            `json:"due"`
+	Principal     decimal.Amount `json:"principal"`
+	PaidPrincipal decimal.Amount `json:"paidPrincipal"`
+}
+
+// Repayment is a payment of the holder, allocated to the interest accrued
+// then to the outstanding principal, which covers the installments the
+// earliest first
+type Repayment struct {
+	TxID      string         `json:"txId"`
+	Timestamp int64          `json:"timestamp"`
+	Amount    decimal.Amount `json:"amount"`
+	Interest  decimal.Amount `json:"interest"`
+	Principal decimal.Amount `json:"principal"`
+}
+
+// Loan is the loan disbursed on a pledge: Principal lent at the annual Rate
+// of the credit, in basis points, and repaid in Term monthly installments of
+// equal principal. The interest accrues daily on the Outstanding principal;
+// Interest is the interest accrued until the date Accrued and not paid yet.
+// Disbursed and Accrued are dates as yyyymmdd.
+type Loan struct {
+	Principal   decimal.Amount `json:"principal"`
+	Rate        int            `json:"rate"`
+	Term        int            `json:"term"`
+	Disbursed   int            `json:"disbursed"`
+	Outstanding decimal.Amount `json:"outstanding"`
+	Interest    decimal.Amount `json:"interest"`
+	Accrued     int            `json:"accrued"`
+	Schedule    []Installment  `json:"schedule"`
+	Repayments  []Repayment    `json:"repayments,omitempty"`
+}
+
+// Balance is what remains to be repaid of a loan at Date, and the part of it
+// overdue
+type Balance struct {
+	Date                int            `json:"date"`
+	Principal           decimal.Amount `json:"principal"`
+	Interest            decimal.Amount `json:"interest"`
+	Outstanding         decimal.Amount `json:"outstanding"`
+	Overdue             decimal.Amount `json:"overdue"`
+	OverdueInstallments []int          `json:"overdueInstallments,omitempty"`
+}
+
+// dateOf returns the date of t as yyyymmdd
+func dateOf(t time.Time) int {
+	year, month, day := t.UTC().Date()
+	return year*10000 + int(month)*100 + day
+}
+
+// timeOf returns the midnight UTC of the date as yyyymmdd
+func timeOf(date int) time.Time {
+	return time.Date(date/10000, time.Month(date/100%100), date%100, 0, 0, 0, 0, time.UTC)
+}
+
+// dueDate returns the date months after disbursed, on the last day of the
+// month when the month is shorter than the day of disbursed
+func dueDate(disbursed time.Time, months int) int {
+	year, month, day := disbursed.UTC().Date()
+	last := time.Date(year, month+time.Month(months)+1, 0, 0, 0, 0, 0, time.UTC)
+	if day > last.Day() {
+		day = last.Day()
+	}
+	return dateOf(time.Date(last.Year(), last.Month(), day, 0, 0, 0, 0, time.UTC))
+}
+
+// newLoan computes the schedule of a loan disbursed at the time disbursed
+func newLoan(principal decimal.Amount, rate, term int, disbursed time.Time) (*Loan, error) {
+	if principal.Sign() <= 0 {
+		return nil, errors.New("the pledge has no amount to lend")
+	}
+	if rate < 0 {
+		return nil, errors.New("the rate of the credit must not negative")
+	}
+	zero := decimal.Zero(currencyScale)
+	loan := &Loan{Principal: principal, Rate: rate, Term: term, Disbursed: dateOf(disbursed),
+		Outstanding: principal, Interest: zero, Accrued: dateOf(disbursed)}
+
+	installmentPrincipal, err := principal.MulDiv(1, int64(term), currencyScale, decimal.RoundDown)
+	if err != nil {
+		return nil, err
+	}
+	remaining := principal
+	for number := 1; number <= term; number++ {
+		installment := Installment{
+			Number:        number,
+			Due:           dueDate(disbursed, number),
+			Principal:     installmentPrincipal,
+			PaidPrincipal: zero,
+		}
+		if number == term {
+			installment.Principal = remaining
+		}
+		remaining, err = remaining.Sub(installment.Principal)
+		if err != nil {
+			return nil, err
+		}
+		loan.Schedule = append(loan.Schedule, installment)
+	}
+	return loan, nil
+}
+
+// accrue returns the interest due at date: the interest unpaid at the last
+// accrual, and the interest of the outstanding principal since
+func (loan *Loan) accrue(date int) (decimal.Amount, error) {
+	days := int64(timeOf(date).Sub(timeOf(loan.Accrued)).Hours() / 24)
+	if days <= 0 {
+		return loan.Interest, nil
+	}
+	interest, err := loan.Outstanding.MulDiv(int64(loan.Rate)*days, rateDenominator, currencyScale, decimal.RoundHalfUp)
+	if err != nil {
+		return interest, err
+	}
+	return loan.Interest.Add(interest)
+}
+
+// allocate adds to paid the part of amount which is still due, and returns
+// that part
+func allocate(due decimal.Amount, paid *decimal.Amount, amount decimal.Amount) (decimal.Amount, error) {
+	part, err := due.Sub(*paid)
+	if err != nil {
+		return part, err
+	}
+	if part.Cmp(amount) > 0 {
+		part = amount
+	}
+	*paid, err = paid.Add(part)
+	return part, err
+}
+
+// repay allocates a repayment made at timestamp to the interest accrued at
+// its date, then to the outstanding principal. The principal covers the
+// installments, the earliest first. The amount can not be more than the
+// outstanding balance.
+func (loan *Loan) repay(amount decimal.Amount, txID string, timestamp int64) (*Repayment, error) {
+	date := dateOf(time.Unix(timestamp, 0))
+	balance, err := loan.balance(date)
+	if err != nil {
+		return nil, err
+	}
+	if amount.Sign() <= 0 || amount.Cmp(balance.Outstanding) > 0 {
+		return nil, fmt.Errorf("the amount is more than the outstanding %s", balance.Outstanding)
+	}
+
+	repayment := &Repayment{TxID: txID, Timestamp: timestamp, Amount: amount, Interest: balance.Interest}
+	if amount.Cmp(balance.Interest) < 0 {
+		repayment.Interest = amount
+	}
+	if repayment.Principal, err = amount.Sub(repayment.Interest); err != nil {
+		return nil, err
+	}
+	if loan.Interest, err = balance.Interest.Sub(repayment.Interest); err != nil {
+		return nil, err
+	}
+	if loan.Outstanding, err = loan.Outstanding.Sub(repayment.Principal); err != nil {
+		return nil, err
+	}
+	if date > loan.Accrued {
+		loan.Accrued = date
+	}
+
+	left := repayment.Principal
+	for i := range loan.Schedule {
+		installment := &loan.Schedule[i]
+		principal, err := allocate(installment.Principal, &installment.PaidPrincipal, left)
+		if err != nil {
+			return nil, err
+		}
+		if left, err = left.Sub(principal); err != nil {
+			return nil, err
+		}
+	}
+	loan.Repayments = append(loan.Repayments, *repayment)
+	return repayment, nil
+}
+
+// balance sums what remains to be repaid at date, with the interest accrued
+// until date, and what is overdue: the principal of the installments due
+// before date and not covered, and the interest accrued when one is
+func (loan *Loan) balance(date int) (*Balance, error) {
+	interest, err := loan.accrue(date)
+	if err != nil {
+		return nil, err
+	}
+	outstanding, err := loan.Outstanding.Add(interest)
+	if err != nil {
+		return nil, err
+	}
+	balance := &Balance{Date: date, Principal: loan.Outstanding, Interest: interest,
+		Outstanding: outstanding, Overdue: decimal.Zero(currencyScale)}
+	for _, installment := range loan.Schedule {
+		left, err := installment.Principal.Sub(installment.PaidPrincipal)
+		if err != nil {
+			return nil, err
+		}
+		if installment.Due < date && left.Sign() > 0 {
+			if balance.Overdue, err = balance.Overdue.Add(left); err != nil {
+				return nil, err
+			}
+			balance.OverdueInstallments = append(balance.OverdueInstallments, installment.Number)
+		}
+	}
+	if len(balance.OverdueInstallments) > 0 {
+		if balance.Overdue, err = balance.Overdue.Add(interest); err != nil {
+			return nil, err
+		}
+	}
+	return balance, nil
+}
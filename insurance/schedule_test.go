@@ -0,0 +1,63 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestDueDatesStayInTheirMonth(t *testing.T) {
+	disbursed := time.Date(2016, 1, 31, 10, 0, 0, 0, time.UTC)
+	for months, due := range []int{20160131, 20160229, 20160331, 20160430, 20160531, 20160630} {
+		if date := dueDate(disbursed, months); date != due {
+			t.Fatalf("due date %d months after %s: %d, expecting %d", months, disbursed, date, due)
+		}
+	}
+}
+
+func TestLoanAccruesInterestOnTheOutstandingPrincipal(t *testing.T) {
+	stub := newInsuranceStub(t)
+	invoke(t, stub, "tx1", "assign", id("alice"), "p1", "5000")
+	as(t, stub, "bank", "tx2", "credit", idIns, "p1", "0", "2000", "1200")
+	as(t, stub, "alice", "tx3", "apply", idIns, "p1", idBank, "1200")
+	stub.SetCreator("OrgMSP", []byte("bank"))
+	invoke(t, stub, "tx4", "approve", idIns, "p1", idBank)
+	stub.SetTxTimestamp(time.Date(2017, 7, 31, 12, 0, 0, 0, time.UTC))
+	invoke(t, stub, "tx5", "loan", idIns, "p1", idBank)
+
+	results := queryUser(t, stub, id("alice"))
+	loan := results[0].Pledge.Loan
+	if len(loan.Schedule) != 12 || loan.Schedule[0].Due != 20170831 || loan.Schedule[1].Due != 20170930 ||
+		loan.Schedule[0].Principal.String() != "100.00" || results[0].Balance.Outstanding.String() != "1200.00" {
+		t.Fatalf("loan %+v, balance %+v", loan, results[0].Balance)
+	}
+
+	// 30 days of interest at 12% on 1200.00 are paid first
+	stub.SetTxTimestamp(time.Date(2017, 8, 30, 12, 0, 0, 0, time.UTC))
+	as(t, stub, "alice", "tx6", "pay", idIns, "p1", idBank, "50")
+	stub.SetCreator("OrgMSP", []byte("bank"))
+	if message := invokeFails(t, stub, "tx7", "break", idIns, "p1", idBank); message != "the loan has no overdue installment" {
+		t.Fatal(message)
+	}
+	results = queryUser(t, stub, id("alice"))
+	repayment := results[0].Pledge.Loan.Repayments[0]
+	if results[0].Pledge.State != PledgeDisbursed || repayment.Interest.String() != "11.84" || repayment.Principal.String() != "38.16" {
+		t.Fatalf("pledge %+v", results[0].Pledge)
+	}
+
+	// the first installment is overdue with 3 days of interest on 1161.84
+	stub.SetTxTimestamp(time.Date(2017, 9, 2, 12, 0, 0, 0, time.UTC))
+	balance := queryUser(t, stub, id("alice"))[0].Balance
+	if balance.Principal.String() != "1161.84" || balance.Interest.String() != "1.15" || balance.Outstanding.String() != "1162.99" ||
+		balance.Overdue.String() != "62.99" || len(balance.OverdueInstallments) != 1 || balance.OverdueInstallments[0] != 1 {
+		t.Fatalf("balance %+v", balance)
+	}
+
+	// paying early does not pay the interest of the remaining term
+	as(t, stub, "alice", "tx8", "pay", idIns, "p1", idBank)
+	results = queryUser(t, stub, id("alice"))
+	repayment = results[0].Pledge.Loan.Repayments[1]
+	if results[0].Pledge.State != PledgeRepaid || repayment.Amount.String() != "1162.99" || repayment.Interest.String() != "1.15" ||
+		!results[0].Balance.Outstanding.IsZero() {
+		t.Fatalf("pledge %+v, balance %+v", results[0].Pledge, results[0].Balance)
+	}
+}
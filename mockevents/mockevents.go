@@ -0,0 +1,264 @@
+// Package mockevents is an in-process event hub of a peer, serving the
+// Events service over gRPC on a local port, used to test the consumers of
+// block events from plain `go test`, without a network.
+//
+// The server acknowledges the registrations and sends the blocks given to
+// SendBlock to all the registered consumers. NewBlock builds the blocks of
+// transactions carrying chaincode events.
+//
+//	server, err := mockevents.NewMockEventServer()
+//	defer server.Stop()
+//	events := services.NewEventServices(server.Address())
+//	ch, err := events.Start()
+//	server.SendBlock(mockevents.NewBlock(1, mockevents.Tx{TxID: "tx1", ChaincodeID: "bonus",
+//		EventName: "BonusTransferred", Payload: payload}))
+//	event := <-ch
+package mockevents
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	cb "github.com/hyperledger/fabric/protos/common"
+	pb "github.com/hyperledger/fabric/protos/peer"
+	"google.golang.org/grpc"
+)
+
+// consumerBuffer is the number of blocks queued for a slow consumer
+const consumerBuffer = 100
+
+// Tx is a transaction of a block built by NewBlock. The transaction sets the
+// chaincode event EventName unless EventName is empty.
+type Tx struct {
+	ChannelID   string
+	TxID        string
+	ChaincodeID string
+	EventName   string
+	Payload     []byte
+	// Invalid marks the transaction as rejected by the validation
+	Invalid bool
+}
+
+// NewBlock builds a committed block of endorser transactions
+func NewBlock(number uint64, txs ...Tx) *cb.Block {
+	filter := make([]byte, len(txs))
+	data := make([][]byte, len(txs))
+	for i, tx := range txs {
+		if tx.Invalid {
+			filter[i] = byte(pb.TxValidationCode_INVALID_OTHER_REASON)
+		}
+		data[i] = envelope(tx)
+	}
+	metadata := make([][]byte, len(cb.BlockMetadataIndex_name))
+	metadata[cb.BlockMetadataIndex_TRANSACTIONS_FILTER] = filter
+	return &cb.Block{
+		Header:   &cb.BlockHeader{Number: number},
+		Data:     &cb.BlockData{Data: data},
+		Metadata: &cb.BlockMetadata{Metadata: metadata},
+	}
+}
+
+func envelope(tx Tx) []byte {
+	var events []byte
+	if tx.EventName != "" {
+		events = marshal(&pb.ChaincodeEvent{
+			ChaincodeId: tx.ChaincodeID,
+			TxId:        tx.TxID,
+			EventName:   tx.EventName,
+			Payload:     tx.Payload,
+		})
+	}
+	action := marshal(&pb.ChaincodeAction{Events: events})
+	responsePayload := marshal(&pb.ProposalResponsePayload{Extension: action})
+	actionPayload := marshal(&pb.ChaincodeActionPayload{
+		Action: &pb.ChaincodeEndorsedAction{ProposalResponsePayload: responsePayload},
+	})
+	transaction := marshal(&pb.Transaction{
+		Actions: []*pb.TransactionAction{{Payload: actionPayload}},
+	})
+	channelHeader := marshal(&cb.ChannelHeader{
+		Type:      int32(cb.HeaderType_ENDORSER_TRANSACTION),
+		ChannelId: tx.ChannelID,
+		TxId:      tx.TxID,
+	})
+	payload := marshal(&cb.Payload{
+		Header: &cb.Header{ChannelHeader: channelHeader},
+		Data:   transaction,
+	})
+	return marshal(&cb.Envelope{Payload: payload})
+}
+
+func marshal(msg proto.Message) []byte {
+	msgBytes, err := proto.Marshal(msg)
+	if err != nil {
+		panic(fmt.Sprintf("Error marshalling %T: %s", msg, err))
+	}
+	return msgBytes
+}
+
+// consumer is a registered event stream
+type consumer struct {
+	blocks chan *cb.Block
+	closed chan struct{}
+}
+
+// MockEventServer is an in-process event hub
+type MockEventServer struct {
+	listener net.Listener
+	server   *grpc.Server
+
+	mutex     sync.Mutex
+	consumers map[*consumer]bool
+	changed   *sync.Cond
+}
+
+// NewMockEventServer starts an event hub on a free local port
+func NewMockEventServer() (*MockEventServer, error) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, fmt.Errorf("Error listening: %s", err)
+	}
+	s := &MockEventServer{
+		listener:  listener,
+		server:    grpc.NewServer(),
+		consumers: make(map[*consumer]bool),
+	}
+	s.changed = sync.NewCond(&s.mutex)
+	pb.RegisterEventsServer(s.server, s)
+	go s.server.Serve(listener)
+	return s, nil
+}
+
+// Address is the host:port of the event hub
+func (s *MockEventServer) Address() string {
+	return s.listener.Addr().String()
+}
+
+// Chat serves an event stream: it acknowledges the registration, then sends
+// the blocks until the consumer or the server disconnects
+func (s *MockEventServer) Chat(stream pb.Events_ChatServer) error {
+	event, err := stream.Recv()
+	if err != nil {
+		return err
+	}
+	register := event.GetRegister()
+	if register == nil {
+		return errors.New("the first event of a stream must be a registration")
+	}
+	err = stream.Send(&pb.Event{Event: &pb.Event_Register{Register: register}})
+	if err != nil {
+		return err
+	}
+
+	c := &consumer{
+		blocks: make(chan *cb.Block, consumerBuffer),
+		closed: make(chan struct{}),
+	}
+	s.mutex.Lock()
+	s.consumers[c] = true
+	s.changed.Broadcast()
+	s.mutex.Unlock()
+	defer s.remove(c)
+
+	// the consumer may close the stream or send an unregister event
+	received := make(chan error, 1)
+	go func() {
+		for {
+			event, err := stream.Recv()
+			if err == nil && event.GetUnregister() == nil {
+				continue
+			}
+			received <- err
+			return
+		}
+	}()
+
+	for {
+		select {
+		case block := <-c.blocks:
+			err = stream.Send(&pb.Event{Event: &pb.Event_Block{Block: block}})
+			if err != nil {
+				return err
+			}
+		case err = <-received:
+			return err
+		case <-c.closed:
+			return errors.New("disconnected by the event hub")
+		}
+	}
+}
+
+func (s *MockEventServer) remove(c *consumer) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.consumers[c] {
+		delete(s.consumers, c)
+		close(c.closed)
+		s.changed.Broadcast()
+	}
+}
+
+// Consumers returns the number of registered consumers
+func (s *MockEventServer) Consumers() int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return len(s.consumers)
+}
+
+// WaitForConsumers waits until n consumers are registered
+func (s *MockEventServer) WaitForConsumers(n int, timeout time.Duration) error {
+	timer := time.AfterFunc(timeout, func() {
+		s.mutex.Lock()
+		s.changed.Broadcast()
+		s.mutex.Unlock()
+	})
+	defer timer.Stop()
+	deadline := time.Now().Add(timeout)
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for len(s.consumers) != n {
+		if !time.Now().Before(deadline) {
+			return fmt.Errorf("%d consumers registered, expecting %d", len(s.consumers), n)
+		}
+		s.changed.Wait()
+	}
+	return nil
+}
+
+// SendBlock sends a block to the registered consumers
+func (s *MockEventServer) SendBlock(block *cb.Block) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for c := range s.consumers {
+		select {
+		case c.blocks <- block:
+		default:
+			// the consumer is too slow, like a peer the event hub drops it
+			delete(s.consumers, c)
+			close(c.closed)
+			s.changed.Broadcast()
+		}
+	}
+}
+
+// Disconnect closes the streams of all the consumers, as a peer restart does
+func (s *MockEventServer) Disconnect() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for c := range s.consumers {
+		delete(s.consumers, c)
+		close(c.closed)
+	}
+	s.changed.Broadcast()
+}
+
+// Stop disconnects the consumers and stops the server
+func (s *MockEventServer) Stop() {
+	s.Disconnect()
+	s.server.Stop()
+}
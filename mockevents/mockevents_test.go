@@ -0,0 +1,186 @@
+package mockevents_test
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/chaincode/common"
+	"github.com/chaincode/mockevents"
+	"github.com/chaincode/services"
+	cb "github.com/hyperledger/fabric/protos/common"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+type transfer struct {
+	From   string `json:"from"`
+	Amount string `json:"amount"`
+}
+
+func eventPayload(t *testing.T, eventType, txID string, data interface{}) []byte {
+	t.Helper()
+	payload, err := json.Marshal(common.Event{Type: eventType, Version: common.EventVersion, TxID: txID, Data: data})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return payload
+}
+
+func next(t *testing.T, events <-chan *services.ChaincodeEvent) *services.ChaincodeEvent {
+	t.Helper()
+	select {
+	case event, ok := <-events:
+		if !ok {
+			t.Fatal("events closed")
+		}
+		return event
+	case <-time.After(3 * time.Second):
+		t.Fatal("timeout waiting for an event")
+	}
+	return nil
+}
+
+func newServer(t *testing.T) *mockevents.MockEventServer {
+	t.Helper()
+	server, err := mockevents.NewMockEventServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return server
+}
+
+func TestEventServicesDeliverTheMatchingEvents(t *testing.T) {
+	server := newServer(t)
+	defer server.Stop()
+	es := services.NewEventServices(server.Address(), services.EventFilter{ChaincodeID: "bonus"})
+	es.RegisterType("BonusTransferred", transfer{})
+	events, err := es.Start()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer es.Stop()
+	if err = server.WaitForConsumers(1, time.Second); err != nil {
+		t.Fatal(err)
+	}
+	watcher := es.WatchTx("c")
+
+	server.SendBlock(mockevents.NewBlock(1,
+		mockevents.Tx{TxID: "a", ChaincodeID: "bonus", EventName: "BonusTransferred",
+			Payload: eventPayload(t, "BonusTransferred", "a", transfer{"alice", "10"})},
+		mockevents.Tx{TxID: "b", ChaincodeID: "other", EventName: "BonusTransferred", Payload: []byte("{}")},
+		mockevents.Tx{TxID: "c", ChaincodeID: "bonus", EventName: "BonusTransferred", Invalid: true},
+		mockevents.Tx{TxID: "d", ChaincodeID: "bonus", EventName: "Raw", Payload: []byte("raw")},
+	))
+	event := next(t, events)
+	if event.TxID != "a" || event.BlockNumber != 1 || event.Event == nil || event.Event.Data.(*transfer).From != "alice" {
+		t.Fatalf("event %+v", event)
+	}
+	// the payloads which are not a common.Event are delivered undecoded
+	if event = next(t, events); event.TxID != "d" || event.Event != nil || string(event.Payload) != "raw" {
+		t.Fatalf("event %+v", event)
+	}
+	select {
+	case status := <-watcher:
+		if status.TxID != "c" || status.BlockNumber != 1 || status.Code == pb.TxValidationCode_VALID {
+			t.Fatalf("status %+v", status)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("transaction c not notified")
+	}
+}
+
+func TestEventServicesResumeAfterTheLastBlock(t *testing.T) {
+	server := newServer(t)
+	defer server.Stop()
+	dir, err := ioutil.TempDir("", "events")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	checkpoint := services.FileCheckpoint(filepath.Join(dir, "checkpoint"))
+
+	errs := make(chan error, 10)
+	fetched := make(map[uint64]*cb.Block)
+	es := services.NewEventServices(server.Address())
+	es.Checkpoint = checkpoint
+	es.RetryInterval = 50 * time.Millisecond
+	es.OnError = func(err error) {
+		select {
+		case errs <- err:
+		default:
+		}
+	}
+	es.Fetch = func(number uint64) (*cb.Block, error) { return fetched[number], nil }
+	events, err := es.Start()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = server.WaitForConsumers(1, time.Second); err != nil {
+		t.Fatal(err)
+	}
+	server.SendBlock(mockevents.NewBlock(1, mockevents.Tx{TxID: "a", ChaincodeID: "bonus", EventName: "E"}))
+	next(t, events)
+
+	// the lost stream is reported and reconnected, the blocks sent again
+	// are skipped and the missed ones fetched
+	server.Disconnect()
+	select {
+	case err = <-errs:
+		if !strings.Contains(err.Error(), "lost") {
+			t.Fatal(err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("lost stream not reported")
+	}
+	if err = server.WaitForConsumers(1, 2*time.Second); err != nil {
+		t.Fatal(err)
+	}
+	fetched[2] = mockevents.NewBlock(2, mockevents.Tx{TxID: "b", ChaincodeID: "bonus", EventName: "E"})
+	server.SendBlock(mockevents.NewBlock(1, mockevents.Tx{TxID: "a", ChaincodeID: "bonus", EventName: "E"}))
+	server.SendBlock(mockevents.NewBlock(3, mockevents.Tx{TxID: "c", ChaincodeID: "bonus", EventName: "E"}))
+	if event := next(t, events); event.TxID != "b" || event.BlockNumber != 2 {
+		t.Fatalf("event %+v", event)
+	}
+	if event := next(t, events); event.TxID != "c" {
+		t.Fatalf("event %+v", event)
+	}
+	es.Stop()
+	if _, ok := <-events; ok {
+		t.Fatal("events not closed")
+	}
+	if err = es.Err(); err != nil {
+		t.Fatalf("error after the reconnection %s", err)
+	}
+	if number, ok, err := checkpoint.Load(); err != nil || !ok || number != 3 {
+		t.Fatalf("checkpoint %d %v %v", number, ok, err)
+	}
+
+	// a restart resumes after the checkpoint
+	es = services.NewEventServices(server.Address())
+	es.Checkpoint = checkpoint
+	events, err = es.Start()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer es.Stop()
+	if err = server.WaitForConsumers(1, time.Second); err != nil {
+		t.Fatal(err)
+	}
+	server.SendBlock(mockevents.NewBlock(3, mockevents.Tx{TxID: "c", ChaincodeID: "bonus", EventName: "E"}))
+	server.SendBlock(mockevents.NewBlock(4, mockevents.Tx{TxID: "d", ChaincodeID: "bonus", EventName: "E"}))
+	if event := next(t, events); event.TxID != "d" {
+		t.Fatalf("event %+v", event)
+	}
+}
+
+func TestEventServicesFailToStartWithoutAPeer(t *testing.T) {
+	es := services.NewEventServices("127.0.0.1:1")
+	es.RegTimeout = 200 * time.Millisecond
+	if _, err := es.Start(); err == nil {
+		t.Fatal("started without a peer")
+	}
+}
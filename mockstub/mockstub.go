@@ -0,0 +1,415 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package mockstub is an in-memory implementation of shim.ChaincodeStubInterface
+// used to drive the Init and Invoke of a chaincode from plain `go test`,
+// without a peer.
+//
+// Writes made during a transaction are buffered and only committed to the
+// world state when the chaincode returns a successful response, and reads
+// always see the committed state, the same way a peer simulates a proposal.
+//
+//	stub := mockstub.NewMockStub("bonus", new(BonusManagementChaincode))
+//	stub.SetCreator("DEFAULT", adminCert)
+//	stub.MockInit("tx0", []string{"init"})
+//	res := stub.MockInvoke("tx1", []string{"issue", "points", owner, "1000"})
+//	if res.Status != shim.OK {
+//		t.Fatal(res.Message)
+//	}
+package mockstub
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/golang/protobuf/ptypes/timestamp"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	mspprotos "github.com/hyperledger/fabric/protos/msp"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+const (
+	minUnicodeRuneValue = 0            //U+0000
+	maxUnicodeRuneValue = utf8.MaxRune //U+10FFFF - maximum (and unallocated) code point
+)
+
+// HistoryEntry is one committed modification of a key
+type HistoryEntry struct {
+	TxID     string
+	Value    []byte
+	IsDelete bool
+}
+
+// Event is a chaincode event set by a committed transaction
+type Event struct {
+	TxID    string
+	Name    string
+	Payload []byte
+}
+
+// MockStub is an in-memory shim.ChaincodeStubInterface
+type MockStub struct {
+	// Name is the chaincode name, only used in error messages
+	Name string
+	// State is the committed world state
+	State map[string][]byte
+	// History holds every committed write per key, oldest first
+	History map[string][]HistoryEntry
+	// Events holds the events of committed transactions, oldest first
+	Events []Event
+
+	cc        shim.Chaincode
+	args      [][]byte
+	txID      string
+	creator   []byte
+	transient map[string][]byte
+	txTime    time.Time
+	now       func() time.Time
+
+	writes map[string][]byte
+	event  *Event
+}
+
+// NewMockStub creates a stub for the chaincode with an empty world state
+func NewMockStub(name string, cc shim.Chaincode) *MockStub {
+	return &MockStub{
+		Name:    name,
+		State:   make(map[string][]byte),
+		History: make(map[string][]HistoryEntry),
+		cc:      cc,
+		now:     time.Now,
+	}
+}
+
+// SetCreator sets the identity returned by GetCreator for the following
+// transactions, serialized the same way the peer does
+func (stub *MockStub) SetCreator(mspID string, idBytes []byte) error {
+	sid := &mspprotos.SerializedIdentity{Mspid: mspID, IdBytes: idBytes}
+	creator, err := proto.Marshal(sid)
+	if err != nil {
+		return fmt.Errorf("Failed marshalling creator: %s", err)
+	}
+	stub.creator = creator
+	return nil
+}
+
+// SetRawCreator sets the raw bytes returned by GetCreator
+func (stub *MockStub) SetRawCreator(creator []byte) {
+	stub.creator = creator
+}
+
+// SetTxTimestamp fixes the timestamp of the following transactions. A zero
+// time restores the wall clock.
+func (stub *MockStub) SetTxTimestamp(t time.Time) {
+	stub.txTime = t
+}
+
+// SetTransient sets the transient map of the following transactions
+func (stub *MockStub) SetTransient(transient map[string][]byte) {
+	stub.transient = transient
+}
+
+// MockInit calls the chaincode Init as the transaction txID
+func (stub *MockStub) MockInit(txID string, args []string) pb.Response {
+	return stub.mockTransaction(txID, args, stub.cc.Init)
+}
+
+// MockInvoke calls the chaincode Invoke as the transaction txID
+func (stub *MockStub) MockInvoke(txID string, args []string) pb.Response {
+	return stub.mockTransaction(txID, args, stub.cc.Invoke)
+}
+
+func (stub *MockStub) mockTransaction(txID string, args []string, fn func(shim.ChaincodeStubInterface) pb.Response) pb.Response {
+	stub.txID = txID
+	stub.args = make([][]byte, len(args))
+	for i, arg := range args {
+		stub.args[i] = []byte(arg)
+	}
+	stub.writes = make(map[string][]byte)
+	stub.event = nil
+
+	response := fn(stub)
+	if response.Status == shim.OK {
+		stub.commit()
+	}
+
+	stub.writes = nil
+	stub.event = nil
+	return response
+}
+
+func (stub *MockStub) commit() {
+	for key, value := range stub.writes {
+		if value == nil {
+			delete(stub.State, key)
+		} else {
+			stub.State[key] = value
+		}
+		stub.History[key] = append(stub.History[key], HistoryEntry{stub.txID, value, value == nil})
+	}
+	if stub.event != nil {
+		stub.Events = append(stub.Events, *stub.event)
+	}
+}
+
+// LastEvent returns the event of the last committed transaction which set one
+func (stub *MockStub) LastEvent() *Event {
+	if len(stub.Events) == 0 {
+		return nil
+	}
+	return &stub.Events[len(stub.Events)-1]
+}
+
+func (stub *MockStub) GetArgs() [][]byte {
+	return stub.args
+}
+
+func (stub *MockStub) GetStringArgs() []string {
+	strargs := make([]string, 0, len(stub.args))
+	for _, barg := range stub.args {
+		strargs = append(strargs, string(barg))
+	}
+	return strargs
+}
+
+func (stub *MockStub) GetFunctionAndParameters() (function string, params []string) {
+	allargs := stub.GetStringArgs()
+	function = ""
+	params = []string{}
+	if len(allargs) >= 1 {
+		function = allargs[0]
+		params = allargs[1:]
+	}
+	return
+}
+
+func (stub *MockStub) GetTxID() string {
+	return stub.txID
+}
+
+func (stub *MockStub) InvokeChaincode(chaincodeName string, args [][]byte, channel string) pb.Response {
+	return shim.Error("InvokeChaincode is not supported by the mock stub of " + stub.Name)
+}
+
+func (stub *MockStub) GetState(key string) ([]byte, error) {
+	value := stub.State[key]
+	if value == nil {
+		return nil, nil
+	}
+	result := make([]byte, len(value))
+	copy(result, value)
+	return result, nil
+}
+
+func (stub *MockStub) PutState(key string, value []byte) error {
+	if stub.writes == nil {
+		return errors.New("PutState called outside of a transaction")
+	}
+	if key == "" {
+		return errors.New("key must not be an empty string")
+	}
+	if value == nil {
+		// the peer treats a nil value as a delete
+		stub.writes[key] = nil
+		return nil
+	}
+	stored := make([]byte, len(value))
+	copy(stored, value)
+	stub.writes[key] = stored
+	return nil
+}
+
+func (stub *MockStub) DelState(key string) error {
+	if stub.writes == nil {
+		return errors.New("DelState called outside of a transaction")
+	}
+	stub.writes[key] = nil
+	return nil
+}
+
+func (stub *MockStub) GetStateByRange(startKey, endKey string) (shim.StateQueryIteratorInterface, error) {
+	var keys []string
+	for key := range stub.State {
+		if key >= startKey && (endKey == "" || key < endKey) {
+			keys = append(keys, key)
+		}
+	}
+	return stub.newIterator(keys), nil
+}
+
+func (stub *MockStub) GetStateByPartialCompositeKey(objectType string, attributes []string) (shim.StateQueryIteratorInterface, error) {
+	partialCompositeKey, err := stub.CreateCompositeKey(objectType, attributes)
+	if err != nil {
+		return nil, err
+	}
+	return stub.GetStateByRange(partialCompositeKey, partialCompositeKey+string(maxUnicodeRuneValue))
+}
+
+func (stub *MockStub) CreateCompositeKey(objectType string, attributes []string) (string, error) {
+	if err := validateCompositeKeyAttribute(objectType); err != nil {
+		return "", err
+	}
+	ck := objectType + string(rune(minUnicodeRuneValue))
+	for _, att := range attributes {
+		if err := validateCompositeKeyAttribute(att); err != nil {
+			return "", err
+		}
+		ck += att + string(rune(minUnicodeRuneValue))
+	}
+	return ck, nil
+}
+
+func (stub *MockStub) SplitCompositeKey(compositeKey string) (string, []string, error) {
+	components := []string{}
+	componentIndex := 0
+	for i := 0; i < len(compositeKey); i++ {
+		if compositeKey[i] == minUnicodeRuneValue {
+			components = append(components, compositeKey[componentIndex:i])
+			componentIndex = i + 1
+		}
+	}
+	if len(components) == 0 {
+		return "", nil, fmt.Errorf("%s is not a composite key", compositeKey)
+	}
+	return components[0], components[1:], nil
+}
+
+func validateCompositeKeyAttribute(str string) error {
+	if !utf8.ValidString(str) {
+		return fmt.Errorf("Not a valid utf8 string: [%x]", str)
+	}
+	for index, runeValue := range str {
+		if runeValue == minUnicodeRuneValue || runeValue == maxUnicodeRuneValue {
+			return fmt.Errorf(`Input contain unicode %#U starting at position [%d]. %#U and %#U are not allowed in the input attribute of a composite key`,
+				runeValue, index, minUnicodeRuneValue, maxUnicodeRuneValue)
+		}
+	}
+	return nil
+}
+
+func (stub *MockStub) GetQueryResult(query string) (shim.StateQueryIteratorInterface, error) {
+	return nil, errors.New("GetQueryResult is not supported by the mock stub")
+}
+
+// GetHistoryForKey iterates the committed writes of key, using the tx ID as
+// the iterator key
+func (stub *MockStub) GetHistoryForKey(key string) (shim.StateQueryIteratorInterface, error) {
+	entries := stub.History[key]
+	iter := &mockIterator{}
+	for _, entry := range entries {
+		iter.keys = append(iter.keys, entry.TxID)
+		iter.values = append(iter.values, entry.Value)
+	}
+	return iter, nil
+}
+
+func (stub *MockStub) GetCreator() ([]byte, error) {
+	return stub.creator, nil
+}
+
+func (stub *MockStub) GetTransient() (map[string][]byte, error) {
+	return stub.transient, nil
+}
+
+func (stub *MockStub) GetBinding() ([]byte, error) {
+	return nil, nil
+}
+
+func (stub *MockStub) GetSignedProposal() (*pb.SignedProposal, error) {
+	return nil, nil
+}
+
+func (stub *MockStub) GetArgsSlice() ([]byte, error) {
+	res := []byte{}
+	for _, barg := range stub.args {
+		res = append(res, barg...)
+	}
+	return res, nil
+}
+
+func (stub *MockStub) GetTxTimestamp() (*timestamp.Timestamp, error) {
+	t := stub.txTime
+	if t.IsZero() {
+		t = stub.now()
+	}
+	return &timestamp.Timestamp{Seconds: t.Unix(), Nanos: int32(t.Nanosecond())}, nil
+}
+
+// SetEvent keeps the last event of the transaction, as the peer only
+// delivers one event per transaction
+func (stub *MockStub) SetEvent(name string, payload []byte) error {
+	if name == "" {
+		return errors.New("Event name can not be nil string")
+	}
+	stub.event = &Event{stub.txID, name, payload}
+	return nil
+}
+
+func (stub *MockStub) newIterator(keys []string) *mockIterator {
+	sort.Strings(keys)
+	iter := &mockIterator{keys: keys}
+	for _, key := range keys {
+		iter.values = append(iter.values, stub.State[key])
+	}
+	return iter
+}
+
+// mockIterator iterates a snapshot of the committed state
+type mockIterator struct {
+	keys   []string
+	values [][]byte
+	index  int
+	closed bool
+}
+
+func (iter *mockIterator) HasNext() bool {
+	return !iter.closed && iter.index < len(iter.keys)
+}
+
+func (iter *mockIterator) Next() (string, []byte, error) {
+	if iter.closed {
+		return "", nil, errors.New("iterator is closed")
+	}
+	if iter.index >= len(iter.keys) {
+		return "", nil, errors.New("no more entries in the iterator")
+	}
+	key, value := iter.keys[iter.index], iter.values[iter.index]
+	iter.index++
+	return key, value, nil
+}
+
+func (iter *mockIterator) Close() error {
+	iter.closed = true
+	return nil
+}
+
+// KeysWithPrefix lists the committed keys starting with prefix, sorted, to
+// make assertions on state easier
+func (stub *MockStub) KeysWithPrefix(prefix string) []string {
+	var keys []string
+	for key := range stub.State {
+		if strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,170 @@
+package mockstub
+
+import (
+	"testing"
+	"time"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+// kvChaincode is a key value store exercising the stub: "put" key value,
+// "del" key, "get" key, "fail" key value which writes then fails, "list"
+// the kv composite keys of an attribute, "creator" and "time"
+type kvChaincode struct{}
+
+func (cc *kvChaincode) Init(stub shim.ChaincodeStubInterface) pb.Response {
+	err := stub.PutState("init", []byte("done"))
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(nil)
+}
+
+func (cc *kvChaincode) Invoke(stub shim.ChaincodeStubInterface) pb.Response {
+	function, args := stub.GetFunctionAndParameters()
+	switch function {
+	case "put":
+		if err := stub.PutState(args[0], []byte(args[1])); err != nil {
+			return shim.Error(err.Error())
+		}
+		if err := stub.SetEvent("Put", []byte(args[0])); err != nil {
+			return shim.Error(err.Error())
+		}
+		// the writes of the transaction are not visible to its reads
+		value, _ := stub.GetState(args[0])
+		return shim.Success(value)
+	case "del":
+		if err := stub.DelState(args[0]); err != nil {
+			return shim.Error(err.Error())
+		}
+		return shim.Success(nil)
+	case "get":
+		value, err := stub.GetState(args[0])
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		return shim.Success(value)
+	case "fail":
+		stub.PutState(args[0], []byte(args[1]))
+		stub.SetEvent("Failed", nil)
+		return shim.Error("failed")
+	case "list":
+		iterator, err := stub.GetStateByPartialCompositeKey("kv", []string{args[0]})
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		defer iterator.Close()
+		var keys []byte
+		for iterator.HasNext() {
+			key, _, err := iterator.Next()
+			if err != nil {
+				return shim.Error(err.Error())
+			}
+			_, parts, err := stub.SplitCompositeKey(key)
+			if err != nil {
+				return shim.Error(err.Error())
+			}
+			keys = append(keys, parts[1]...)
+		}
+		return shim.Success(keys)
+	case "creator":
+		creator, _ := stub.GetCreator()
+		return shim.Success(creator)
+	case "time":
+		timestamp, _ := stub.GetTxTimestamp()
+		return shim.Success([]byte(time.Unix(timestamp.Seconds, 0).UTC().Format("2006-01-02")))
+	}
+	return shim.Error("unknown function " + function)
+}
+
+func invoke(t *testing.T, stub *MockStub, txID string, args ...string) []byte {
+	t.Helper()
+	response := stub.MockInvoke(txID, args)
+	if response.Status != shim.OK {
+		t.Fatalf("%s %v: %s", txID, args, response.Message)
+	}
+	return response.Payload
+}
+
+func TestMockStubCommitsSuccessfulTransactions(t *testing.T) {
+	stub := NewMockStub("kv", new(kvChaincode))
+	if response := stub.MockInit("tx0", nil); response.Status != shim.OK {
+		t.Fatal(response.Message)
+	}
+	if string(stub.State["init"]) != "done" {
+		t.Fatalf("init state %q", stub.State["init"])
+	}
+
+	if value := invoke(t, stub, "tx1", "put", "a", "1"); value != nil {
+		t.Fatalf("read its own write %q", value)
+	}
+	if value := invoke(t, stub, "tx2", "get", "a"); string(value) != "1" {
+		t.Fatalf("get a = %q", value)
+	}
+	if event := stub.LastEvent(); event == nil || event.TxID != "tx1" || event.Name != "Put" || string(event.Payload) != "a" {
+		t.Fatalf("last event %+v", event)
+	}
+
+	if response := stub.MockInvoke("tx3", []string{"fail", "a", "2"}); response.Status == shim.OK {
+		t.Fatal("fail succeeded")
+	}
+	if string(stub.State["a"]) != "1" {
+		t.Fatalf("failed transaction committed %q", stub.State["a"])
+	}
+	if event := stub.LastEvent(); event.TxID != "tx1" {
+		t.Fatalf("event of a failed transaction %+v", event)
+	}
+
+	invoke(t, stub, "tx4", "del", "a")
+	if _, found := stub.State["a"]; found {
+		t.Fatal("a not deleted")
+	}
+	history := stub.History["a"]
+	if len(history) != 2 || history[0].TxID != "tx1" || !history[1].IsDelete {
+		t.Fatalf("history %+v", history)
+	}
+}
+
+func TestMockStubCompositeKeys(t *testing.T) {
+	stub := NewMockStub("kv", new(kvChaincode))
+	for _, parts := range [][]string{{"x", "b"}, {"x", "a"}, {"y", "c"}, {"xx", "d"}} {
+		key, err := stub.CreateCompositeKey("kv", parts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		stub.State[key] = []byte{0x00}
+	}
+	if keys := invoke(t, stub, "tx1", "list", "x"); string(keys) != "ab" {
+		t.Fatalf("list x = %q", keys)
+	}
+	if _, err := stub.CreateCompositeKey("kv", []string{"a\x00b"}); err == nil {
+		t.Fatal("accepted U+0000 in an attribute")
+	}
+	key, _ := stub.CreateCompositeKey("kv", []string{"x", "a"})
+	objectType, parts, err := stub.SplitCompositeKey(key)
+	if err != nil || objectType != "kv" || len(parts) != 2 || parts[1] != "a" {
+		t.Fatal(objectType, parts, err)
+	}
+	if keys := stub.KeysWithPrefix("kv\x00x\x00"); len(keys) != 2 {
+		t.Fatalf("keys %q", keys)
+	}
+}
+
+func TestMockStubCreatorAndTimestamp(t *testing.T) {
+	stub := NewMockStub("kv", new(kvChaincode))
+	if err := stub.SetCreator("Org1MSP", []byte("alice")); err != nil {
+		t.Fatal(err)
+	}
+	if creator := invoke(t, stub, "tx1", "creator"); len(creator) == 0 {
+		t.Fatal("empty creator")
+	}
+	stub.SetRawCreator([]byte("raw"))
+	if creator := invoke(t, stub, "tx2", "creator"); string(creator) != "raw" {
+		t.Fatalf("creator %q", creator)
+	}
+	stub.SetTxTimestamp(time.Date(2017, 11, 1, 12, 0, 0, 0, time.UTC))
+	if date := invoke(t, stub, "tx3", "time"); string(date) != "2017-11-01" {
+		t.Fatalf("date %s", date)
+	}
+}
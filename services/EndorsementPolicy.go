@@ -0,0 +1,289 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/golang/protobuf/proto"
+	cb "github.com/hyperledger/fabric/protos/common"
+	mspprotos "github.com/hyperledger/fabric/protos/msp"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+// EndorsementPolicy is a policy over the MSPs of the endorsers, in the way of
+// the cauthdsl policies: a leaf requires the endorsement of a member of the
+// MSP MspID, a node requires N of its Policies. An endorsement satisfies a
+// single leaf.
+//
+//	// one of org1 and org2, and org3
+//	policy := services.AllOf(services.AnyOf(services.SignedByMsp("Org1MSP"),
+//		services.SignedByMsp("Org2MSP")), services.SignedByMsp("Org3MSP"))
+type EndorsementPolicy struct {
+	MspID    string
+	N        int
+	Policies []*EndorsementPolicy
+}
+
+// SignedByMsp requires the endorsement of a member of the MSP mspID
+func SignedByMsp(mspID string) *EndorsementPolicy {
+	return &EndorsementPolicy{MspID: mspID}
+}
+
+// NOutOf requires n of the policies
+func NOutOf(n int, policies ...*EndorsementPolicy) *EndorsementPolicy {
+	return &EndorsementPolicy{N: n, Policies: policies}
+}
+
+// AnyOf requires one of the policies
+func AnyOf(policies ...*EndorsementPolicy) *EndorsementPolicy {
+	return NOutOf(1, policies...)
+}
+
+// AllOf requires all the policies
+func AllOf(policies ...*EndorsementPolicy) *EndorsementPolicy {
+	return NOutOf(len(policies), policies...)
+}
+
+// Satisfied reports whether the endorsements of the proposal responses
+// satisfy the policy. Only the MSPs of the endorsers are checked, their
+// signatures are verified by the committing peers.
+func (policy *EndorsementPolicy) Satisfied(responses []*pb.ProposalResponse) (bool, error) {
+	selected, err := policy.Select(responses)
+	return selected != nil, err
+}
+
+// Select returns the proposal responses whose endorsements satisfy the
+// policy, without the endorsements it does not need, nil when the policy is
+// not satisfied
+func (policy *EndorsementPolicy) Select(responses []*pb.ProposalResponse) ([]*pb.ProposalResponse, error) {
+	msps, err := endorserMsps(responses)
+	if err != nil {
+		return nil, err
+	}
+	used := make([]bool, len(msps))
+	if !policy.evaluate(msps, used) {
+		return nil, nil
+	}
+	selected := []*pb.ProposalResponse{}
+	for i, response := range responses {
+		if used[i] {
+			selected = append(selected, response)
+		}
+	}
+	return selected, nil
+}
+
+// endorserMsps returns the MSPs of the endorsers of the proposal responses
+func endorserMsps(responses []*pb.ProposalResponse) ([]string, error) {
+	msps := make([]string, len(responses))
+	for i, response := range responses {
+		if response.Endorsement == nil {
+			return nil, fmt.Errorf("Proposal response %d is not endorsed", i)
+		}
+		endorser := &mspprotos.SerializedIdentity{}
+		err := proto.Unmarshal(response.Endorsement.Endorser, endorser)
+		if err != nil {
+			return nil, fmt.Errorf("Error decoding endorser %d: %s", i, err)
+		}
+		msps[i] = endorser.Mspid
+	}
+	return msps, nil
+}
+
+// evaluate marks the endorsements used to satisfy the policy, a node
+// stopping once N of its policies are satisfied
+func (policy *EndorsementPolicy) evaluate(msps []string, used []bool) bool {
+	if len(policy.Policies) == 0 && policy.MspID != "" {
+		for i, msp := range msps {
+			if !used[i] && msp == policy.MspID {
+				used[i] = true
+				return true
+			}
+		}
+		return false
+	}
+
+	satisfied := 0
+	for _, sub := range policy.Policies {
+		if satisfied >= policy.N {
+			break
+		}
+		subUsed := make([]bool, len(used))
+		copy(subUsed, used)
+		if sub.evaluate(msps, subUsed) {
+			satisfied++
+			copy(used, subUsed)
+		}
+	}
+	return satisfied >= policy.N
+}
+
+// String formats the policy in the syntax of ParsePolicy
+func (policy *EndorsementPolicy) String() string {
+	if len(policy.Policies) == 0 && policy.MspID != "" {
+		return fmt.Sprintf("'%s.member'", policy.MspID)
+	}
+	var subs []string
+	for _, sub := range policy.Policies {
+		subs = append(subs, sub.String())
+	}
+	switch policy.N {
+	case len(policy.Policies):
+		return "AND(" + strings.Join(subs, ", ") + ")"
+	case 1:
+		return "OR(" + strings.Join(subs, ", ") + ")"
+	}
+	return fmt.Sprintf("OutOf(%d, %s)", policy.N, strings.Join(subs, ", "))
+}
+
+// PolicyFromEnvelope converts a signature policy of cauthdsl, as stored by
+// lscc with the chaincode data, to an endorsement policy. The principals
+// must be MSP roles, the role is not distinguished from member.
+func PolicyFromEnvelope(envelope *cb.SignaturePolicyEnvelope) (*EndorsementPolicy, error) {
+	msps := make([]string, len(envelope.Identities))
+	for i, principal := range envelope.Identities {
+		if principal.PrincipalClassification != mspprotos.MSPPrincipal_ROLE {
+			return nil, fmt.Errorf("Unsupported principal %d, expecting an MSP role", i)
+		}
+		role := &mspprotos.MSPRole{}
+		err := proto.Unmarshal(principal.Principal, role)
+		if err != nil {
+			return nil, fmt.Errorf("Error decoding principal %d: %s", i, err)
+		}
+		msps[i] = role.MspIdentifier
+	}
+	return policyFromRule(envelope.Policy, msps)
+}
+
+func policyFromRule(rule *cb.SignaturePolicy, msps []string) (*EndorsementPolicy, error) {
+	if rule == nil || rule.Type == nil {
+		return nil, errors.New("Empty signature policy")
+	}
+	if nOutOf := rule.GetNOutOf(); nOutOf != nil {
+		var policies []*EndorsementPolicy
+		for _, subRule := range nOutOf.Rules {
+			sub, err := policyFromRule(subRule, msps)
+			if err != nil {
+				return nil, err
+			}
+			policies = append(policies, sub)
+		}
+		return NOutOf(int(nOutOf.N), policies...), nil
+	}
+	signedBy := rule.GetSignedBy()
+	if signedBy < 0 || int(signedBy) >= len(msps) {
+		return nil, fmt.Errorf("Signature policy signed by unknown principal %d", signedBy)
+	}
+	return SignedByMsp(msps[signedBy]), nil
+}
+
+// ParsePolicy parses a policy in the syntax of the cauthdsl policies, as
+// OR(AND('Org1MSP.member', 'Org2MSP.member'), 'Org3MSP.admin'), with
+// OutOf(n, ...) for n of the policies. The role of a principal is one of
+// member, admin, client and peer, and is not distinguished from member.
+func ParsePolicy(policy string) (*EndorsementPolicy, error) {
+	parser := &policyParser{input: policy}
+	parsed, err := parser.parse()
+	if err == nil && parser.skipSpaces() < len(parser.input) {
+		err = fmt.Errorf("unexpected %q", parser.input[parser.pos:])
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Invalid policy %s: %s", policy, err)
+	}
+	return parsed, nil
+}
+
+var policyRoles = map[string]bool{"member": true, "admin": true, "client": true, "peer": true}
+
+type policyParser struct {
+	input string
+	pos   int
+}
+
+func (parser *policyParser) skipSpaces() int {
+	for parser.pos < len(parser.input) && strings.ContainsRune(" \t\n", rune(parser.input[parser.pos])) {
+		parser.pos++
+	}
+	return parser.pos
+}
+
+// expect consumes the character c
+func (parser *policyParser) expect(c byte) error {
+	if parser.skipSpaces() >= len(parser.input) || parser.input[parser.pos] != c {
+		return fmt.Errorf("expecting %q at %d", c, parser.pos)
+	}
+	parser.pos++
+	return nil
+}
+
+func (parser *policyParser) parse() (*EndorsementPolicy, error) {
+	start := parser.skipSpaces()
+	if start < len(parser.input) && parser.input[start] == '\'' {
+		end := strings.IndexByte(parser.input[start+1:], '\'')
+		if end < 0 {
+			return nil, fmt.Errorf("unterminated principal at %d", start)
+		}
+		principal := parser.input[start+1 : start+1+end]
+		parser.pos = start + end + 2
+		dot := strings.LastIndexByte(principal, '.')
+		if dot <= 0 || !policyRoles[principal[dot+1:]] {
+			return nil, fmt.Errorf("invalid principal '%s'", principal)
+		}
+		return SignedByMsp(principal[:dot]), nil
+	}
+
+	for parser.pos < len(parser.input) && parser.input[parser.pos] != '(' && parser.input[parser.pos] != ' ' {
+		parser.pos++
+	}
+	operator := parser.input[start:parser.pos]
+	if err := parser.expect('('); err != nil {
+		return nil, err
+	}
+	n := -1
+	if operator == "OutOf" {
+		parser.skipSpaces()
+		numberStart := parser.pos
+		for parser.pos < len(parser.input) && parser.input[parser.pos] >= '0' && parser.input[parser.pos] <= '9' {
+			parser.pos++
+		}
+		var err error
+		n, err = strconv.Atoi(parser.input[numberStart:parser.pos])
+		if err != nil {
+			return nil, fmt.Errorf("expecting a number at %d", numberStart)
+		}
+		if err = parser.expect(','); err != nil {
+			return nil, err
+		}
+	} else if operator != "AND" && operator != "OR" {
+		return nil, fmt.Errorf("unknown operator %q at %d", operator, start)
+	}
+
+	var policies []*EndorsementPolicy
+	for {
+		sub, err := parser.parse()
+		if err != nil {
+			return nil, err
+		}
+		policies = append(policies, sub)
+		if parser.skipSpaces() < len(parser.input) && parser.input[parser.pos] == ',' {
+			parser.pos++
+			continue
+		}
+		if err = parser.expect(')'); err != nil {
+			return nil, err
+		}
+		break
+	}
+	switch operator {
+	case "AND":
+		return AllOf(policies...), nil
+	case "OR":
+		return AnyOf(policies...), nil
+	}
+	if n > len(policies) {
+		return nil, fmt.Errorf("OutOf(%d) of %d policies", n, len(policies))
+	}
+	return NOutOf(n, policies...), nil
+}
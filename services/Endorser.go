@@ -0,0 +1,261 @@
+package services
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	pb "github.com/hyperledger/fabric/protos/peer"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+)
+
+const defaultEndorserTimeout = 10 * time.Second
+
+// Endorser is an endorsing peer
+type Endorser struct {
+	// Address is the endpoint of the peer, host:port
+	Address string
+	Client  pb.EndorserClient
+	// Timeout bounds the processing of a proposal by the peer,
+	// defaultEndorserTimeout when it is not set
+	Timeout time.Duration
+
+	conn *grpc.ClientConn
+}
+
+// ChaincodeError is the error response of a chaincode to a proposal
+type ChaincodeError struct {
+	Chaincode string
+	// Endorser is the address of the peer which simulated the proposal
+	Endorser string
+	Status   int32
+	// Message is the message of the shim.Error of the chaincode
+	Message string
+}
+
+func (err *ChaincodeError) Error() string {
+	return fmt.Sprintf("Error endorsing %s: %s: %s", err.Chaincode, err.Endorser, err.Message)
+}
+
+// NewEndorser connects to the endorsing peer at address, with an insecure
+// connection when there is no option
+func NewEndorser(address string, opts ...grpc.DialOption) (*Endorser, error) {
+	if len(opts) == 0 {
+		opts = []grpc.DialOption{grpc.WithInsecure()}
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), defaultEndorserTimeout)
+	defer cancel()
+	conn, err := grpc.DialContext(ctx, address, append(opts, grpc.WithBlock())...)
+	if err != nil {
+		return nil, fmt.Errorf("Error connecting to endorser %s: %s", address, err)
+	}
+	return &Endorser{
+		Address: address,
+		Client:  pb.NewEndorserClient(conn),
+		Timeout: defaultEndorserTimeout,
+		conn:    conn,
+	}, nil
+}
+
+// Close closes the connection opened by NewEndorser
+func (endorser *Endorser) Close() {
+	if endorser.conn != nil {
+		endorser.conn.Close()
+	}
+}
+
+// process sends a signed proposal to the endorser and checks its response,
+// which is not necessarily endorsed
+func (endorser *Endorser) process(chaincode string, signedProp *pb.SignedProposal) (*pb.ProposalResponse, error) {
+	timeout := endorser.Timeout
+	if timeout <= 0 {
+		timeout = defaultEndorserTimeout
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	proposalResponse, err := endorser.Client.ProcessProposal(ctx, signedProp)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", endorser.Address, err)
+	}
+	if proposalResponse == nil || proposalResponse.Response == nil {
+		return nil, fmt.Errorf("%s: empty proposal response", endorser.Address)
+	}
+	if proposalResponse.Response.Status != shimOK {
+		return nil, &ChaincodeError{
+			Chaincode: chaincode,
+			Endorser:  endorser.Address,
+			Status:    proposalResponse.Response.Status,
+			Message:   proposalResponse.Response.Message,
+		}
+	}
+	return proposalResponse, nil
+}
+
+// query sends a signed proposal to the endorsers one after the other, and
+// returns the first successful response. When the chaincode rejected the
+// proposal the *ChaincodeError is returned at once, the other errors move on
+// to the next endorser.
+func (peer *PeerServices) query(chaincode string, signedProp *pb.SignedProposal) (*pb.ProposalResponse, error) {
+	if len(peer.Endorsers) == 0 {
+		return nil, fmt.Errorf("Error querying %s: no endorser configured", chaincode)
+	}
+	var failures []string
+	for _, endorser := range peer.Endorsers {
+		response, err := endorser.process(chaincode, signedProp)
+		if err == nil {
+			return response, nil
+		}
+		if _, ok := err.(*ChaincodeError); ok {
+			return nil, err
+		}
+		failures = append(failures, err.Error())
+	}
+	return nil, fmt.Errorf("Error querying %s: %s", chaincode, strings.Join(failures, "; "))
+}
+
+// endorse sends a signed proposal to all the endorsers concurrently and
+// returns the endorsed responses, once checked that they agree. Without
+// policy every endorser must succeed. With a policy the responses are grouped
+// by read write set and chaincode response, and the endorsements the policy
+// needs are selected from the first group satisfying it. When the chaincode
+// rejected the proposal, the error is the *ChaincodeError.
+func (peer *PeerServices) endorse(chaincode string, signedProp *pb.SignedProposal, policy *EndorsementPolicy) ([]*pb.ProposalResponse, error) {
+	if len(peer.Endorsers) == 0 {
+		return nil, fmt.Errorf("Error endorsing %s: no endorser configured", chaincode)
+	}
+	responses := make([]*pb.ProposalResponse, len(peer.Endorsers))
+	errs := make([]error, len(peer.Endorsers))
+	var wg sync.WaitGroup
+	for i, endorser := range peer.Endorsers {
+		wg.Add(1)
+		go func(i int, endorser *Endorser) {
+			defer wg.Done()
+			responses[i], errs[i] = endorser.process(chaincode, signedProp)
+			if errs[i] == nil && responses[i].Endorsement == nil {
+				responses[i], errs[i] = nil, fmt.Errorf("%s: the proposal response is not endorsed", endorser.Address)
+			}
+		}(i, endorser)
+	}
+	wg.Wait()
+
+	var endorsed []*pb.ProposalResponse
+	var failures []string
+	var rejected *ChaincodeError
+	for i, response := range responses {
+		if errs[i] != nil {
+			chaincodeErr, ok := errs[i].(*ChaincodeError)
+			if !ok {
+				failures = append(failures, errs[i].Error())
+				continue
+			}
+			if rejected == nil {
+				rejected = chaincodeErr
+			}
+			failures = append(failures, chaincodeErr.Endorser+": "+chaincodeErr.Message)
+			continue
+		}
+		endorsed = append(endorsed, response)
+	}
+	if len(endorsed) == 0 || (policy == nil && len(failures) > 0) {
+		if rejected != nil {
+			return nil, rejected
+		}
+		return nil, fmt.Errorf("Error endorsing %s: %s", chaincode, strings.Join(failures, "; "))
+	}
+
+	if policy == nil {
+		err := checkConsistency(endorsed)
+		if err != nil {
+			return nil, fmt.Errorf("Error endorsing %s: %s", chaincode, err)
+		}
+		return endorsed, nil
+	}
+
+	groups, err := groupConsistent(endorsed)
+	if err != nil {
+		return nil, fmt.Errorf("Error endorsing %s: %s", chaincode, err)
+	}
+	for _, group := range groups {
+		selected, err := policy.Select(group)
+		if err != nil {
+			return nil, fmt.Errorf("Error endorsing %s: %s", chaincode, err)
+		}
+		if selected != nil {
+			return selected, nil
+		}
+	}
+	if len(groups) > 1 {
+		failures = append(failures, "the responses of the endorsers differ")
+	}
+	if len(failures) > 0 {
+		return nil, fmt.Errorf("Error endorsing %s: the endorsements do not satisfy the policy %s: %s",
+			chaincode, policy, strings.Join(failures, "; "))
+	}
+	return nil, fmt.Errorf("Error endorsing %s: the endorsements do not satisfy the policy %s", chaincode, policy)
+}
+
+// groupConsistent groups the proposal responses which carry the same read
+// write set and the same chaincode response, in the order of their first
+// response
+func groupConsistent(responses []*pb.ProposalResponse) ([][]*pb.ProposalResponse, error) {
+	var groups [][]*pb.ProposalResponse
+	for i, response := range responses {
+		if _, err := chaincodeAction(response); err != nil {
+			return nil, fmt.Errorf("Error decoding proposal response %d: %s", i, err)
+		}
+		found := false
+		for j, group := range groups {
+			if checkConsistency([]*pb.ProposalResponse{group[0], response}) == nil {
+				groups[j] = append(group, response)
+				found = true
+				break
+			}
+		}
+		if !found {
+			groups = append(groups, []*pb.ProposalResponse{response})
+		}
+	}
+	return groups, nil
+}
+
+// checkConsistency checks that the proposal responses carry the same read
+// write set and the same chaincode response
+func checkConsistency(responses []*pb.ProposalResponse) error {
+	var first *pb.ChaincodeAction
+	for i, response := range responses {
+		action, err := chaincodeAction(response)
+		if err != nil {
+			return fmt.Errorf("Error decoding proposal response %d: %s", i, err)
+		}
+		if first == nil {
+			first = action
+			continue
+		}
+		if !bytes.Equal(first.Results, action.Results) {
+			return errors.New("the read write sets of the endorsers differ")
+		}
+		if !bytes.Equal(responses[0].Response.Payload, response.Response.Payload) ||
+			!bytes.Equal(first.Events, action.Events) {
+			return errors.New("the responses of the endorsers differ")
+		}
+	}
+	return nil
+}
+
+// chaincodeAction decodes the chaincode action of a proposal response
+func chaincodeAction(response *pb.ProposalResponse) (*pb.ChaincodeAction, error) {
+	responsePayload := &pb.ProposalResponsePayload{}
+	if err := proto.Unmarshal(response.Payload, responsePayload); err != nil {
+		return nil, err
+	}
+	action := &pb.ChaincodeAction{}
+	if err := proto.Unmarshal(responsePayload.Extension, action); err != nil {
+		return nil, fmt.Errorf("Error decoding chaincode action: %s", err)
+	}
+	return action, nil
+}
@@ -0,0 +1,645 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	cb "github.com/hyperledger/fabric/protos/common"
+	pb "github.com/hyperledger/fabric/protos/peer"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+
+	"github.com/chaincode/common"
+)
+
+const (
+	defaultRegTimeout    = 10 * time.Second
+	defaultRetryInterval = 5 * time.Second
+)
+
+var errEventsStopped = errors.New("event services stopped")
+
+// EventFilter selects the chaincode events by chaincode name and event name,
+// an empty field matching any name
+type EventFilter struct {
+	ChaincodeID string
+	EventName   string
+}
+
+func (filter EventFilter) matches(event *ChaincodeEvent) bool {
+	return (filter.ChaincodeID == "" || filter.ChaincodeID == event.ChaincodeID) &&
+		(filter.EventName == "" || filter.EventName == event.EventName)
+}
+
+// ChaincodeEvent is the event of a valid transaction of a committed block
+type ChaincodeEvent struct {
+	BlockNumber uint64
+	ChannelID   string
+	TxID        string
+	ChaincodeID string
+	EventName   string
+	Payload     []byte
+	// Event is the payload decoded as a common.Event, nil when it is not one.
+	// Its Data is a pointer to the type registered for the event name, or the
+	// raw JSON when no type is registered.
+	Event *common.Event
+}
+
+// TxStatus is the validation of a transaction committed in a block
+type TxStatus struct {
+	BlockNumber uint64
+	ChannelID   string
+	TxID        string
+	Code        pb.TxValidationCode
+}
+
+// Checkpoint stores the number of the last processed block, so that a new
+// EventServices resumes after it
+type Checkpoint interface {
+	// Load returns the number of the last processed block, false when no
+	// block was processed yet
+	Load() (uint64, bool, error)
+	// Save records the number of the last processed block
+	Save(number uint64) error
+}
+
+// FileCheckpoint is a Checkpoint kept in the file at its path
+type FileCheckpoint string
+
+// Load reads the number of the last processed block from the file
+func (path FileCheckpoint) Load() (uint64, bool, error) {
+	numberBytes, err := ioutil.ReadFile(string(path))
+	if os.IsNotExist(err) {
+		return 0, false, nil
+	}
+	if err != nil {
+		return 0, false, err
+	}
+	number, err := strconv.ParseUint(strings.TrimSpace(string(numberBytes)), 10, 64)
+	if err != nil {
+		return 0, false, fmt.Errorf("Invalid checkpoint %s: %s", path, err)
+	}
+	return number, true, nil
+}
+
+// Save writes the number of the last processed block to the file
+func (path FileCheckpoint) Save(number uint64) error {
+	tmp := string(path) + ".tmp"
+	err := ioutil.WriteFile(tmp, []byte(strconv.FormatUint(number, 10)), 0644)
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp, string(path))
+}
+
+// BlockFetcher returns a committed block by number
+type BlockFetcher func(number uint64) (*cb.Block, error)
+
+// EventServices listens to the block events of a peer and delivers the
+// chaincode events matching its filters on a channel, in block order.
+//
+// The peer only sends the blocks committed while connected. The number of
+// the last processed block is kept, in Checkpoint when set, and the blocks
+// up to it are skipped after a reconnection or a restart. When Fetch is set,
+// the blocks missed in between are fetched with it before the next block is
+// processed. A block counts as processed once all its events were received
+// from the channel, so that a restart delivers them at least once.
+//
+// WatchTx notifies the commit of a transaction, whether valid or not.
+//
+//	events := services.NewEventServices("localhost:7053", services.EventFilter{ChaincodeID: "bonus"})
+//	events.RegisterType("BonusTransferred", TransferRecord{})
+//	ch, err := events.Start()
+//	for event := range ch {
+//		record := event.Event.Data.(*TransferRecord)
+//	}
+type EventServices struct {
+	// Address is the event endpoint of the peer, host:port
+	Address string
+	// DialOptions are the options of the connection to the peer, an insecure
+	// connection when empty
+	DialOptions []grpc.DialOption
+	// RegTimeout bounds the connection and registration to the peer
+	RegTimeout time.Duration
+	// RetryInterval is the delay before reconnecting after the connection
+	// to the peer is lost
+	RetryInterval time.Duration
+	// Checkpoint persists the last processed block, nil keeping it in memory
+	Checkpoint Checkpoint
+	// Fetch gets the blocks missed while disconnected, nil skipping them
+	Fetch BlockFetcher
+	// CommitsOnly skips the delivery of the chaincode events, for the event
+	// services only used to wait for transactions with WatchTx
+	CommitsOnly bool
+	// OnError is called with the errors of the connection to the peer, the
+	// loss of the stream and each failed reconnection, from the goroutine
+	// receiving the blocks. Nil ignores them, Err still returning the last.
+	OnError func(err error)
+
+	filters []EventFilter
+	types   map[string]reflect.Type
+
+	mutex     sync.Mutex
+	lastBlock uint64
+	hasLast   bool
+	err       error
+	cancel    context.CancelFunc
+	stop      chan struct{}
+	done      chan struct{}
+	events    chan *ChaincodeEvent
+	watchers  map[string]chan *TxStatus
+}
+
+// NewEventServices creates the event services of the peer event endpoint at
+// address, delivering the events matching any of the filters, or all the
+// events when there is no filter
+func NewEventServices(address string, filters ...EventFilter) *EventServices {
+	return &EventServices{
+		Address:       address,
+		RegTimeout:    defaultRegTimeout,
+		RetryInterval: defaultRetryInterval,
+		filters:       filters,
+		types:         make(map[string]reflect.Type),
+	}
+}
+
+// RegisterType decodes the data of the events named eventType into a new
+// value of the type of data
+func (es *EventServices) RegisterType(eventType string, data interface{}) {
+	t := reflect.TypeOf(data)
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	es.types[eventType] = t
+}
+
+// LastBlock returns the number of the last processed block, false when no
+// block was processed yet
+func (es *EventServices) LastBlock() (uint64, bool) {
+	es.mutex.Lock()
+	defer es.mutex.Unlock()
+	return es.lastBlock, es.hasLast
+}
+
+// Err returns the last error of the connection to the peer, nil while
+// connected
+func (es *EventServices) Err() error {
+	es.mutex.Lock()
+	defer es.mutex.Unlock()
+	return es.err
+}
+
+// WatchTx returns a channel receiving the status of the transaction txID
+// when a block commits it. Watch a transaction before submitting it, so
+// that its commit is not missed.
+func (es *EventServices) WatchTx(txID string) <-chan *TxStatus {
+	es.mutex.Lock()
+	defer es.mutex.Unlock()
+	if es.watchers == nil {
+		es.watchers = make(map[string]chan *TxStatus)
+	}
+	watcher, ok := es.watchers[txID]
+	if !ok {
+		watcher = make(chan *TxStatus, 1)
+		es.watchers[txID] = watcher
+	}
+	return watcher
+}
+
+// UnwatchTx stops watching the transaction txID
+func (es *EventServices) UnwatchTx(txID string) {
+	es.mutex.Lock()
+	defer es.mutex.Unlock()
+	delete(es.watchers, txID)
+}
+
+// notify sends the status of the watched transactions of a block
+func (es *EventServices) notify(block *cb.Block) {
+	es.mutex.Lock()
+	defer es.mutex.Unlock()
+	if len(es.watchers) == 0 {
+		return
+	}
+	for _, status := range txStatuses(block) {
+		watcher, ok := es.watchers[status.TxID]
+		if !ok {
+			continue
+		}
+		watcher <- status
+		delete(es.watchers, status.TxID)
+	}
+}
+
+// Start connects to the peer and returns the channel of the events. The
+// channel is closed by Stop. A lost connection is retried every
+// RetryInterval until Stop is called.
+func (es *EventServices) Start() (<-chan *ChaincodeEvent, error) {
+	if es.stop != nil {
+		return nil, errors.New("event services already started")
+	}
+	if es.Checkpoint != nil {
+		number, ok, err := es.Checkpoint.Load()
+		if err != nil {
+			return nil, fmt.Errorf("Error loading checkpoint: %s", err)
+		}
+		es.lastBlock, es.hasLast = number, ok
+	}
+	conn, stream, err := es.connect()
+	if err != nil {
+		return nil, err
+	}
+	es.stop = make(chan struct{})
+	es.done = make(chan struct{})
+	es.events = make(chan *ChaincodeEvent)
+	go es.run(conn, stream)
+	return es.events, nil
+}
+
+// Stop disconnects from the peer and closes the channel of the events
+func (es *EventServices) Stop() {
+	if es.stop == nil {
+		return
+	}
+	select {
+	case <-es.stop:
+	default:
+		close(es.stop)
+		es.mutex.Lock()
+		if es.cancel != nil {
+			es.cancel()
+		}
+		es.mutex.Unlock()
+	}
+	<-es.done
+}
+
+func (es *EventServices) stopped() bool {
+	select {
+	case <-es.stop:
+		return true
+	default:
+		return false
+	}
+}
+
+// connect opens a stream to the peer and registers for the block events
+func (es *EventServices) connect() (*grpc.ClientConn, pb.Events_ChatClient, error) {
+	opts := es.DialOptions
+	if len(opts) == 0 {
+		opts = []grpc.DialOption{grpc.WithInsecure()}
+	}
+	regTimeout := es.RegTimeout
+	if regTimeout <= 0 {
+		regTimeout = defaultRegTimeout
+	}
+	dialCtx, dialCancel := context.WithTimeout(context.Background(), regTimeout)
+	defer dialCancel()
+	conn, err := grpc.DialContext(dialCtx, es.Address, append(opts, grpc.WithBlock())...)
+	if err != nil {
+		return nil, nil, fmt.Errorf("Error connecting to event hub %s: %s", es.Address, err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	stream, err := pb.NewEventsClient(conn).Chat(ctx)
+	if err != nil {
+		cancel()
+		conn.Close()
+		return nil, nil, fmt.Errorf("Error opening event stream to %s: %s", es.Address, err)
+	}
+	register := &pb.Event{Event: &pb.Event_Register{Register: &pb.Register{
+		Events: []*pb.Interest{{EventType: pb.EventType_BLOCK}},
+	}}}
+	err = stream.Send(register)
+	if err != nil {
+		cancel()
+		conn.Close()
+		return nil, nil, fmt.Errorf("Error registering to event hub %s: %s", es.Address, err)
+	}
+
+	// the peer acknowledges the registration with a register event
+	ack := make(chan error, 1)
+	go func() {
+		reply, err := stream.Recv()
+		if err == nil && reply.GetRegister() == nil {
+			err = fmt.Errorf("unexpected reply to the registration: %v", reply)
+		}
+		ack <- err
+	}()
+	select {
+	case err = <-ack:
+	case <-time.After(regTimeout):
+		err = errors.New("timeout waiting for the registration")
+	}
+	if err != nil {
+		cancel()
+		conn.Close()
+		return nil, nil, fmt.Errorf("Error registering to event hub %s: %s", es.Address, err)
+	}
+
+	es.mutex.Lock()
+	if es.cancel != nil {
+		es.cancel()
+	}
+	es.cancel = cancel
+	es.err = nil
+	if es.stopped() {
+		// Stop was called while connecting
+		cancel()
+	}
+	es.mutex.Unlock()
+	return conn, stream, nil
+}
+
+// run receives the blocks until Stop, reconnecting when the stream fails
+func (es *EventServices) run(conn *grpc.ClientConn, stream pb.Events_ChatClient) {
+	defer close(es.done)
+	defer close(es.events)
+	retryInterval := es.RetryInterval
+	if retryInterval <= 0 {
+		retryInterval = defaultRetryInterval
+	}
+	for {
+		err := es.receive(stream)
+		conn.Close()
+		if es.stopped() {
+			return
+		}
+		es.fail(fmt.Errorf("Event stream from %s lost: %s", es.Address, err))
+
+		for {
+			select {
+			case <-es.stop:
+				return
+			case <-time.After(retryInterval):
+			}
+			conn, stream, err = es.connect()
+			if err == nil {
+				break
+			}
+			es.fail(err)
+		}
+	}
+}
+
+// fail records the error of the connection and reports it to OnError
+func (es *EventServices) fail(err error) {
+	es.mutex.Lock()
+	es.err = err
+	es.mutex.Unlock()
+	if es.OnError != nil {
+		es.OnError(err)
+	}
+}
+
+func (es *EventServices) receive(stream pb.Events_ChatClient) error {
+	for {
+		event, err := stream.Recv()
+		if err != nil {
+			return err
+		}
+		block := event.GetBlock()
+		if block == nil || block.Header == nil {
+			continue
+		}
+		err = es.catchUp(block.Header.Number)
+		if err != nil {
+			return err
+		}
+		err = es.process(block)
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// catchUp fetches and processes the blocks between the last processed block
+// and the block number
+func (es *EventServices) catchUp(number uint64) error {
+	if es.Fetch == nil {
+		return nil
+	}
+	for {
+		last, ok := es.LastBlock()
+		if !ok || last+1 >= number {
+			return nil
+		}
+		block, err := es.Fetch(last + 1)
+		if err != nil {
+			return fmt.Errorf("Error fetching block %d: %s", last+1, err)
+		}
+		if block == nil || block.Header == nil || block.Header.Number != last+1 {
+			return fmt.Errorf("Error fetching block %d: unexpected block", last+1)
+		}
+		err = es.process(block)
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// process delivers the events of a block not processed yet, and records it
+// as the last processed block
+func (es *EventServices) process(block *cb.Block) error {
+	number := block.Header.Number
+	last, ok := es.LastBlock()
+	if ok && number <= last {
+		return nil
+	}
+	es.notify(block)
+	var events []*ChaincodeEvent
+	var err error
+	if !es.CommitsOnly {
+		events, err = chaincodeEvents(block)
+		if err != nil {
+			return fmt.Errorf("Error decoding block %d: %s", number, err)
+		}
+	}
+	for _, event := range events {
+		if !es.matches(event) {
+			continue
+		}
+		es.decode(event)
+		select {
+		case es.events <- event:
+		case <-es.stop:
+			return errEventsStopped
+		}
+	}
+
+	if es.Checkpoint != nil {
+		err = es.Checkpoint.Save(number)
+		if err != nil {
+			return fmt.Errorf("Error saving checkpoint: %s", err)
+		}
+	}
+	es.mutex.Lock()
+	es.lastBlock, es.hasLast = number, true
+	es.mutex.Unlock()
+	return nil
+}
+
+func (es *EventServices) matches(event *ChaincodeEvent) bool {
+	if len(es.filters) == 0 {
+		return true
+	}
+	for _, filter := range es.filters {
+		if filter.matches(event) {
+			return true
+		}
+	}
+	return false
+}
+
+// decode sets the Event of a chaincode event whose payload is a common.Event
+func (es *EventServices) decode(event *ChaincodeEvent) {
+	var data interface{}
+	if t, ok := es.types[event.EventName]; ok {
+		data = reflect.New(t).Interface()
+	}
+	decoded, err := common.DecodeEvent(event.Payload, data)
+	if err != nil || decoded.Type != event.EventName {
+		return
+	}
+	event.Event = decoded
+}
+
+// txCode returns the validation code of the transaction i of a block
+func txCode(block *cb.Block, i int) pb.TxValidationCode {
+	if block.Metadata == nil || len(block.Metadata.Metadata) <= int(cb.BlockMetadataIndex_TRANSACTIONS_FILTER) {
+		return pb.TxValidationCode_VALID
+	}
+	filter := block.Metadata.Metadata[cb.BlockMetadataIndex_TRANSACTIONS_FILTER]
+	if i >= len(filter) {
+		return pb.TxValidationCode_VALID
+	}
+	return pb.TxValidationCode(filter[i])
+}
+
+// decodeEnvelope returns the payload and the channel header of a transaction
+// envelope, a nil header when the payload has none
+func decodeEnvelope(envelopeBytes []byte) (*cb.Payload, *cb.ChannelHeader, error) {
+	envelope := &cb.Envelope{}
+	if err := proto.Unmarshal(envelopeBytes, envelope); err != nil {
+		return nil, nil, fmt.Errorf("Error decoding envelope: %s", err)
+	}
+	payload := &cb.Payload{}
+	if err := proto.Unmarshal(envelope.Payload, payload); err != nil {
+		return nil, nil, fmt.Errorf("Error decoding payload: %s", err)
+	}
+	if payload.Header == nil {
+		return payload, nil, nil
+	}
+	channelHeader := &cb.ChannelHeader{}
+	if err := proto.Unmarshal(payload.Header.ChannelHeader, channelHeader); err != nil {
+		return nil, nil, fmt.Errorf("Error decoding channel header: %s", err)
+	}
+	return payload, channelHeader, nil
+}
+
+// txStatuses returns the status of the transactions of a block. The
+// transactions rejected for a malformed envelope are left out.
+func txStatuses(block *cb.Block) []*TxStatus {
+	if block.Data == nil {
+		return nil
+	}
+	var statuses []*TxStatus
+	for i, envelopeBytes := range block.Data.Data {
+		_, channelHeader, err := decodeEnvelope(envelopeBytes)
+		if err != nil || channelHeader == nil || channelHeader.TxId == "" {
+			continue
+		}
+		statuses = append(statuses, &TxStatus{
+			BlockNumber: block.Header.Number,
+			ChannelID:   channelHeader.ChannelId,
+			TxID:        channelHeader.TxId,
+			Code:        txCode(block, i),
+		})
+	}
+	return statuses
+}
+
+// chaincodeEvents returns the chaincode events of the valid transactions of
+// a block
+func chaincodeEvents(block *cb.Block) ([]*ChaincodeEvent, error) {
+	if block.Data == nil {
+		return nil, nil
+	}
+
+	var events []*ChaincodeEvent
+	for i, envelopeBytes := range block.Data.Data {
+		if txCode(block, i) != pb.TxValidationCode_VALID {
+			continue
+		}
+		payload, channelHeader, err := decodeEnvelope(envelopeBytes)
+		if err != nil {
+			return nil, fmt.Errorf("Error decoding transaction %d: %s", i, err)
+		}
+		if channelHeader == nil {
+			continue
+		}
+		if cb.HeaderType(channelHeader.Type) != cb.HeaderType_ENDORSER_TRANSACTION {
+			continue
+		}
+		tx := &pb.Transaction{}
+		if err := proto.Unmarshal(payload.Data, tx); err != nil {
+			return nil, fmt.Errorf("Error decoding transaction %s: %s", channelHeader.TxId, err)
+		}
+		for _, action := range tx.Actions {
+			ccEvent, err := actionEvent(action)
+			if err != nil {
+				return nil, fmt.Errorf("Error decoding transaction %s: %s", channelHeader.TxId, err)
+			}
+			if ccEvent == nil {
+				continue
+			}
+			events = append(events, &ChaincodeEvent{
+				BlockNumber: block.Header.Number,
+				ChannelID:   channelHeader.ChannelId,
+				TxID:        channelHeader.TxId,
+				ChaincodeID: ccEvent.ChaincodeId,
+				EventName:   ccEvent.EventName,
+				Payload:     ccEvent.Payload,
+			})
+		}
+	}
+	return events, nil
+}
+
+// actionEvent returns the chaincode event set by a transaction action, nil
+// when there is none
+func actionEvent(action *pb.TransactionAction) (*pb.ChaincodeEvent, error) {
+	actionPayload := &pb.ChaincodeActionPayload{}
+	if err := proto.Unmarshal(action.Payload, actionPayload); err != nil {
+		return nil, err
+	}
+	if actionPayload.Action == nil {
+		return nil, nil
+	}
+	responsePayload := &pb.ProposalResponsePayload{}
+	if err := proto.Unmarshal(actionPayload.Action.ProposalResponsePayload, responsePayload); err != nil {
+		return nil, err
+	}
+	chaincodeAction := &pb.ChaincodeAction{}
+	if err := proto.Unmarshal(responsePayload.Extension, chaincodeAction); err != nil {
+		return nil, err
+	}
+	if len(chaincodeAction.Events) == 0 {
+		return nil, nil
+	}
+	ccEvent := &pb.ChaincodeEvent{}
+	if err := proto.Unmarshal(chaincodeAction.Events, ccEvent); err != nil {
+		return nil, err
+	}
+	if ccEvent.EventName == "" {
+		return nil, nil
+	}
+	return ccEvent, nil
+}
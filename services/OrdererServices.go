@@ -0,0 +1,70 @@
+package services
+
+import (
+	"fmt"
+	"time"
+
+	cb "github.com/hyperledger/fabric/protos/common"
+	ab "github.com/hyperledger/fabric/protos/orderer"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+)
+
+const defaultBroadcastTimeout = 10 * time.Second
+
+// OrdererServices broadcasts the transactions to an orderer
+type OrdererServices struct {
+	// Address is the endpoint of the orderer, host:port
+	Address string
+	// DialOptions are the options of the connection to the orderer, an
+	// insecure connection when empty
+	DialOptions []grpc.DialOption
+	// Timeout bounds the connection to the orderer and its acknowledgement
+	Timeout time.Duration
+}
+
+// NewOrdererServices creates the orderer services of the orderer at address
+func NewOrdererServices(address string) *OrdererServices {
+	return &OrdererServices{
+		Address: address,
+		Timeout: defaultBroadcastTimeout,
+	}
+}
+
+// Broadcast sends a transaction envelope to the orderer and waits until the
+// orderer accepts it. The transaction is not committed yet when it returns.
+func (orderer *OrdererServices) Broadcast(env *cb.Envelope) error {
+	opts := orderer.DialOptions
+	if len(opts) == 0 {
+		opts = []grpc.DialOption{grpc.WithInsecure()}
+	}
+	timeout := orderer.Timeout
+	if timeout <= 0 {
+		timeout = defaultBroadcastTimeout
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	conn, err := grpc.DialContext(ctx, orderer.Address, append(opts, grpc.WithBlock())...)
+	if err != nil {
+		return fmt.Errorf("Error connecting to orderer %s: %s", orderer.Address, err)
+	}
+	defer conn.Close()
+
+	stream, err := ab.NewAtomicBroadcastClient(conn).Broadcast(ctx)
+	if err != nil {
+		return fmt.Errorf("Error opening broadcast stream to %s: %s", orderer.Address, err)
+	}
+	err = stream.Send(env)
+	if err != nil {
+		return fmt.Errorf("Error broadcasting to %s: %s", orderer.Address, err)
+	}
+	reply, err := stream.Recv()
+	if err != nil {
+		return fmt.Errorf("Error receiving broadcast reply from %s: %s", orderer.Address, err)
+	}
+	stream.CloseSend()
+	if reply.Status != cb.Status_SUCCESS {
+		return fmt.Errorf("Broadcast to %s rejected: %s", orderer.Address, reply.Status)
+	}
+	return nil
+}
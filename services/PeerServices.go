@@ -1,34 +1,71 @@
 package services
 
 import (
-	pb "github.com/hyperledger/fabric/protos/peer"
-	"fmt"
 	"errors"
+	"fmt"
+	"github.com/golang/protobuf/proto"
+	"github.com/hyperledger/fabric/common/cauthdsl"
+	"github.com/hyperledger/fabric/common/util"
 	"github.com/hyperledger/fabric/core/chaincode/platforms"
+	"github.com/hyperledger/fabric/core/common/ccprovider"
 	"github.com/hyperledger/fabric/core/container"
 	"github.com/hyperledger/fabric/msp"
-	"github.com/hyperledger/fabric/common/util"
+	"github.com/hyperledger/fabric/peer/common"
+	cb "github.com/hyperledger/fabric/protos/common"
+	pb "github.com/hyperledger/fabric/protos/peer"
 	"github.com/hyperledger/fabric/protos/utils"
 	putils "github.com/hyperledger/fabric/protos/utils"
-	"github.com/hyperledger/fabric/common/cauthdsl"
-	"golang.org/x/net/context"
-	"encoding/json"
-	"github.com/hyperledger/fabric/peer/common"
+	"github.com/spf13/viper"
+	"sync"
+	"time"
 )
 
 const (
 	chainFuncName = "chaincode"
+	// shimOK is the status of a successful chaincode response
+	shimOK = 200
+
+	defaultCommitTimeout = 30 * time.Second
+	defaultPollInterval  = time.Second
 )
 
 type PeerServices struct {
-	Signer          msp.SigningIdentity
-	EndorserClient  pb.EndorserClient
+	Signer msp.SigningIdentity
+	// Endorsers are the endorsing peers the proposals are sent to
+	Endorsers []*Endorser
+	// Policies are the endorsement policies by chaincode name. The policy
+	// of a chaincode missing is loaded from lscc by its first invocation.
+	Policies map[string]*EndorsementPolicy
+	// Orderer broadcasts the endorsed transactions
+	Orderer *OrdererServices
+	// Events notifies the commit of the transactions. When nil the status
+	// of a transaction is queried from the endorsers every PollInterval.
+	Events *EventServices
+	// CommitTimeout bounds the wait for the commit of a transaction
+	CommitTimeout time.Duration
+	// PollInterval is the interval between the queries of the status of a
+	// transaction without Events, a second when not positive
+	PollInterval time.Duration
+
+	// mutex guards Policies
+	mutex sync.Mutex
 }
 
-func NewPeerServices()(*PeerServices, error)  {
-	endorserClient, err := common.GetEndorserClient()
-	if err != nil {
-		return nil, fmt.Errorf("Error getting endorser client %s: %s", chainFuncName, err)
+func NewPeerServices() (*PeerServices, error) {
+	var endorsers []*Endorser
+	for _, address := range viper.GetStringSlice("peer.endorsers") {
+		endorser, err := NewEndorser(address)
+		if err != nil {
+			return nil, err
+		}
+		endorsers = append(endorsers, endorser)
+	}
+	if len(endorsers) == 0 {
+		endorserClient, err := common.GetEndorserClient()
+		if err != nil {
+			return nil, fmt.Errorf("Error getting endorser client %s: %s", chainFuncName, err)
+		}
+		endorsers = append(endorsers, &Endorser{Address: viper.GetString("peer.address"), Client: endorserClient})
 	}
 	signer, err := common.GetDefaultSigner()
 	if err != nil {
@@ -38,29 +75,95 @@ func NewPeerServices()(*PeerServices, error)  {
 		return nil, fmt.Errorf("Error default signer is nil")
 	}
 	fmt.Println(signer)
-	return &PeerServices{
-		Signer:          signer,
-		EndorserClient:  endorserClient,
-	}, nil
+	peer := &PeerServices{
+		Signer:        signer,
+		Endorsers:     endorsers,
+		Policies:      make(map[string]*EndorsementPolicy),
+		CommitTimeout: defaultCommitTimeout,
+		PollInterval:  defaultPollInterval,
+	}
+	if address := viper.GetString("orderer.address"); address != "" {
+		peer.Orderer = NewOrdererServices(address)
+	}
+	if address := viper.GetString("peer.events.address"); address != "" {
+		peer.Events = NewEventServices(address)
+		peer.Events.CommitsOnly = true
+		if _, err := peer.Events.Start(); err != nil {
+			return nil, fmt.Errorf("Error starting event services: %s", err)
+		}
+	}
+	return peer, nil
+}
+
+// Close stops the event services of the peer and disconnects the endorsers
+func (peer *PeerServices) Close() {
+	if peer.Events != nil {
+		peer.Events.Stop()
+	}
+	for _, endorser := range peer.Endorsers {
+		endorser.Close()
+	}
+}
+
+// DeploySpec describes the deployment of a version of a chaincode
+type DeploySpec struct {
+	// Channel is the channel the chaincode is deployed on
+	Channel string
+	Name    string
+	// Path is the import path of the source of the chaincode
+	Path    string
+	Version string
+	// Args are the arguments of Init, the function name first, "init" when
+	// empty
+	Args []string
+	// Policy is the endorsement policy, as AND('Org1MSP.member', 'Org2MSP.member').
+	// When empty a member of the MSP of the Signer endorses the transactions.
+	Policy string
+	// Escc and Vscc are the endorsement and validation system chaincodes,
+	// escc and vscc when empty
+	Escc string
+	Vscc string
+}
+
+// proposalFromCDS creates a deploy or an upgrade proposal
+type proposalFromCDS func(txid string, chainID string, cds *pb.ChaincodeDeploymentSpec, creator []byte,
+	policy []byte, escc []byte, vscc []byte) (*pb.Proposal, error)
+
+// Deploy deploys a chaincode, and returns the transaction ID once committed
+func (peer *PeerServices) Deploy(spec *DeploySpec) (string, error) {
+	return peer.deploy(spec, utils.CreateDeployProposalFromCDS)
+}
+
+// Upgrade deploys a new version of a deployed chaincode, and returns the
+// transaction ID once committed. Init is called again with the Args.
+func (peer *PeerServices) Upgrade(spec *DeploySpec) (string, error) {
+	return peer.deploy(spec, utils.CreateUpgradeProposalFromCDS)
 }
 
-func (peer *PeerServices) Deploy(chaincodeName, chaincodePath string) (string, error) {
+func (peer *PeerServices) deploy(spec *DeploySpec, createProposal proposalFromCDS) (string, error) {
+	if spec.Channel == "" || spec.Name == "" || spec.Path == "" || spec.Version == "" {
+		return "", errors.New("Chaincode channel, name, path and version are required")
+	}
+	args := spec.Args
+	if len(args) == 0 {
+		args = []string{"init"}
+	}
 	input := &pb.ChaincodeInput{}
-	if err := json.Unmarshal([]byte("{\"args\":[\"111\"]}"), &input); err != nil {
-		return "", fmt.Errorf("Chaincode argument error: %s", err)
+	for _, arg := range args {
+		input.Args = append(input.Args, []byte(arg))
 	}
 
 	chaincodeLang := "GOLANG"
-	spec := &pb.ChaincodeSpec{
+	chaincodeSpec := &pb.ChaincodeSpec{
 		Type:        pb.ChaincodeSpec_Type(pb.ChaincodeSpec_Type_value[chaincodeLang]),
-		ChaincodeId: &pb.ChaincodeID{Path: chaincodePath, Name: chaincodeName},
+		ChaincodeId: &pb.ChaincodeID{Path: spec.Path, Name: spec.Name, Version: spec.Version},
 		Input:       input,
 	}
 
-	cds, err := getChaincodeBytes(spec)
+	cds, err := getChaincodeBytes(chaincodeSpec)
 
 	if err != nil {
-		return "", fmt.Errorf("Error getting chaincode code %s: %s", chainFuncName, err)
+		return "", fmt.Errorf("Error getting chaincode code %s: %s", spec.Name, err)
 	}
 
 	creator, err := peer.Signer.Serialize()
@@ -70,41 +173,296 @@ func (peer *PeerServices) Deploy(chaincodeName, chaincodePath string) (string, e
 
 	uuid := util.GenerateUUID()
 
-	p := cauthdsl.SignedByMspMember("DEFAULT")
+	var policy *EndorsementPolicy
+	var p *cb.SignaturePolicyEnvelope
+	if spec.Policy != "" {
+		policy, err = ParsePolicy(spec.Policy)
+		if err != nil {
+			return "", err
+		}
+		p, err = cauthdsl.FromString(spec.Policy)
+		if err != nil {
+			return "", fmt.Errorf("Invalid policy %s: %s", spec.Policy, err)
+		}
+	} else {
+		policy = SignedByMsp(peer.Signer.GetMSPIdentifier())
+		p = cauthdsl.SignedByMspMember(peer.Signer.GetMSPIdentifier())
+	}
 	policyMarhsalled := putils.MarshalOrPanic(p)
 
-	escc := "escc"
-	vscc := "vscc"
+	escc := spec.Escc
+	if escc == "" {
+		escc = "escc"
+	}
+	vscc := spec.Vscc
+	if vscc == "" {
+		vscc = "vscc"
+	}
 
-	prop, err := utils.CreateDeployProposalFromCDS(uuid, "testchainid", cds, creator, policyMarhsalled, []byte(escc), []byte(vscc))
+	prop, err := createProposal(uuid, spec.Channel, cds, creator, policyMarhsalled, []byte(escc), []byte(vscc))
 	if err != nil {
-		return "", fmt.Errorf("Error creating proposal  %s: %s\n", chainFuncName, err)
+		return "", fmt.Errorf("Error creating proposal  %s: %s\n", spec.Name, err)
 	}
 
 	var signedProp *pb.SignedProposal
 	signedProp, err = utils.GetSignedProposal(prop, peer.Signer)
 	if err != nil {
-		return "", fmt.Errorf("Error creating signed proposal  %s: %s\n", chainFuncName, err)
+		return "", fmt.Errorf("Error creating signed proposal  %s: %s\n", spec.Name, err)
+	}
+
+	proposalResponses, err := peer.endorse(spec.Name, signedProp, nil)
+	if err != nil {
+		return "", err
+	}
+
+	response := &Response{
+		TxID:      uuid,
+		Channel:   spec.Channel,
+		Proposal:  prop,
+		Responses: proposalResponses,
+	}
+	err = peer.Submit(response)
+	if err != nil {
+		return uuid, err
+	}
+	peer.mutex.Lock()
+	if peer.Policies == nil {
+		peer.Policies = make(map[string]*EndorsementPolicy)
+	}
+	peer.Policies[spec.Name] = policy
+	peer.mutex.Unlock()
+	return uuid, nil
+}
+
+// Response is the endorsed response of a proposal
+type Response struct {
+	// TxID is the id of the transaction of the proposal
+	TxID string
+	// Channel is the channel of the transaction
+	Channel string
+	// Payload is the payload returned by the chaincode
+	Payload []byte
+	// Proposal is the proposal sent to the endorsers
+	Proposal *pb.Proposal
+	// Responses are the proposal responses of the endorsers
+	Responses []*pb.ProposalResponse
+	// Committed is set once the transaction is committed in the block
+	// BlockNumber, with the validation ValidationCode
+	Committed      bool
+	BlockNumber    uint64
+	ValidationCode pb.TxValidationCode
+}
+
+// Invoke sends a proposal calling fn of a chaincode with args on a channel
+// to the endorsers, submits the transaction with the endorsements the policy
+// of the chaincode needs and waits for its commit. When the transaction was
+// submitted but is not committed as valid, the response is returned with the
+// error.
+func (peer *PeerServices) Invoke(channel, chaincode, fn string, args []string) (*Response, error) {
+	policy, err := peer.policy(channel, chaincode)
+	if err != nil {
+		return nil, err
+	}
+	response, signedProp, err := peer.propose(channel, chaincode, fn, args)
+	if err != nil {
+		return nil, err
+	}
+	response.Responses, err = peer.endorse(chaincode, signedProp, policy)
+	if err != nil {
+		return nil, err
+	}
+	response.Payload = response.Responses[0].Response.Payload
+	err = peer.Submit(response)
+	return response, err
+}
+
+// policy returns the endorsement policy of a chaincode from Policies, or
+// loaded from its chaincode data in lscc on the channel
+func (peer *PeerServices) policy(channel, chaincode string) (*EndorsementPolicy, error) {
+	peer.mutex.Lock()
+	policy, ok := peer.Policies[chaincode]
+	peer.mutex.Unlock()
+	if ok {
+		return policy, nil
+	}
+
+	response, err := peer.Query(channel, "lscc", "getccdata", []string{channel, chaincode})
+	if err != nil {
+		return nil, fmt.Errorf("Error getting the endorsement policy of %s: %s", chaincode, err)
+	}
+	data := &ccprovider.ChaincodeData{}
+	err = proto.Unmarshal(response.Payload, data)
+	if err != nil {
+		return nil, fmt.Errorf("Error decoding the chaincode data of %s: %s", chaincode, err)
+	}
+	envelope := &cb.SignaturePolicyEnvelope{}
+	err = proto.Unmarshal(data.Policy, envelope)
+	if err != nil {
+		return nil, fmt.Errorf("Error decoding the endorsement policy of %s: %s", chaincode, err)
+	}
+	policy, err = PolicyFromEnvelope(envelope)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid endorsement policy of %s: %s", chaincode, err)
+	}
+
+	peer.mutex.Lock()
+	if peer.Policies == nil {
+		peer.Policies = make(map[string]*EndorsementPolicy)
+	}
+	peer.Policies[chaincode] = policy
+	peer.mutex.Unlock()
+	return policy, nil
+}
+
+// Submit assembles the signed transaction of an endorsed response, broadcasts
+// it to the orderer and waits for its commit, for CommitTimeout. The commit
+// is notified by Events, or polled from the endorsers without Events.
+func (peer *PeerServices) Submit(response *Response) error {
+	if peer.Orderer == nil {
+		return fmt.Errorf("Error submitting %s: no orderer configured", response.TxID)
+	}
+	env, err := utils.CreateSignedTx(response.Proposal, peer.Signer, response.Responses...)
+	if err != nil {
+		return fmt.Errorf("Could not assemble transaction, err %s", err)
 	}
 
-	proposalResponse, err := peer.EndorserClient.ProcessProposal(context.Background(), signedProp)
+	var committed <-chan *TxStatus
+	if peer.Events != nil {
+		committed = peer.Events.WatchTx(response.TxID)
+		defer peer.Events.UnwatchTx(response.TxID)
+	}
+	err = peer.Orderer.Broadcast(env)
 	if err != nil {
-		return "", fmt.Errorf("Error endorsing %s: %s\n", chainFuncName, err)
+		return err
 	}
 
-	if proposalResponse != nil {
-		// assemble a signed transaction (it's an Envelope message)
-		env, err := utils.CreateSignedTx(prop, peer.Signer, proposalResponse)
+	var status *TxStatus
+	if committed != nil {
+		select {
+		case status = <-committed:
+		case <-time.After(peer.commitTimeout()):
+			return fmt.Errorf("Timeout waiting for the commit of transaction %s", response.TxID)
+		}
+	} else {
+		status, err = peer.pollCommit(response)
 		if err != nil {
-			return "", fmt.Errorf("Could not assemble transaction, err %s", err)
+			return err
+		}
+	}
+	response.Committed = true
+	response.BlockNumber = status.BlockNumber
+	response.ValidationCode = status.Code
+	if response.ValidationCode != pb.TxValidationCode_VALID {
+		return fmt.Errorf("Transaction %s committed as invalid: %s", response.TxID, response.ValidationCode)
+	}
+	return nil
+}
+
+// commitTimeout is CommitTimeout, or defaultCommitTimeout when it is not set
+func (peer *PeerServices) commitTimeout() time.Duration {
+	if peer.CommitTimeout <= 0 {
+		return defaultCommitTimeout
+	}
+	return peer.CommitTimeout
+}
+
+// pollCommit queries the status of the transaction of a response every
+// PollInterval until it is committed, for CommitTimeout
+func (peer *PeerServices) pollCommit(response *Response) (*TxStatus, error) {
+	interval := peer.PollInterval
+	if interval <= 0 {
+		interval = defaultPollInterval
+	}
+	deadline := time.Now().Add(peer.commitTimeout())
+	for {
+		status, err := peer.txStatus(response.Channel, response.TxID)
+		if err == nil {
+			return status, nil
+		}
+		if time.Now().Add(interval).After(deadline) {
+			return nil, fmt.Errorf("Timeout waiting for the commit of transaction %s: %s", response.TxID, err)
+		}
+		time.Sleep(interval)
+	}
+}
+
+// txStatus returns the status of a committed transaction, from its block
+// queried with GetBlockByTxID of qscc. It fails until the transaction is
+// committed.
+func (peer *PeerServices) txStatus(channel, txID string) (*TxStatus, error) {
+	response, err := peer.Query(channel, "qscc", "GetBlockByTxID", []string{channel, txID})
+	if err != nil {
+		return nil, err
+	}
+	block := &cb.Block{}
+	err = proto.Unmarshal(response.Payload, block)
+	if err != nil {
+		return nil, fmt.Errorf("Error decoding the block of transaction %s: %s", txID, err)
+	}
+	if block.Header == nil {
+		return nil, fmt.Errorf("Error decoding the block of transaction %s: no header", txID)
+	}
+	for _, status := range txStatuses(block) {
+		if status.TxID == txID {
+			return status, nil
 		}
-		fmt.Print(env)
+	}
+	return nil, fmt.Errorf("Transaction %s not found in block %d", txID, block.Header.Number)
+}
 
-		return "", nil
+// Query sends a proposal calling fn of a chaincode with args on a channel
+// to the endorsers one after the other, and returns the response of the
+// first which answers. The proposal is only simulated, it is meant for the
+// functions which read the state.
+func (peer *PeerServices) Query(channel, chaincode, fn string, args []string) (*Response, error) {
+	response, signedProp, err := peer.propose(channel, chaincode, fn, args)
+	if err != nil {
+		return nil, err
+	}
+	proposalResponse, err := peer.query(chaincode, signedProp)
+	if err != nil {
+		return nil, err
 	}
-	return "", nil
+	response.Payload = proposalResponse.Response.Payload
+	response.Responses = []*pb.ProposalResponse{proposalResponse}
+	return response, nil
 }
 
+// propose builds a proposal calling fn of a chaincode and signs it with the
+// Signer, the response waiting for the proposal responses
+func (peer *PeerServices) propose(channel, chaincode, fn string, args []string) (*Response, *pb.SignedProposal, error) {
+	input := &pb.ChaincodeInput{Args: [][]byte{[]byte(fn)}}
+	for _, arg := range args {
+		input.Args = append(input.Args, []byte(arg))
+	}
+	spec := &pb.ChaincodeSpec{
+		Type:        pb.ChaincodeSpec_GOLANG,
+		ChaincodeId: &pb.ChaincodeID{Name: chaincode},
+		Input:       input,
+	}
+	invocation := &pb.ChaincodeInvocationSpec{ChaincodeSpec: spec}
+
+	creator, err := peer.Signer.Serialize()
+	if err != nil {
+		return nil, nil, fmt.Errorf("Error serializing identity for %s: %s", peer.Signer.GetIdentifier(), err)
+	}
+
+	txID := util.GenerateUUID()
+	prop, err := utils.CreateChaincodeProposal(txID, cb.HeaderType_ENDORSER_TRANSACTION, channel, invocation, creator)
+	if err != nil {
+		return nil, nil, fmt.Errorf("Error creating proposal for %s: %s", chaincode, err)
+	}
+	signedProp, err := utils.GetSignedProposal(prop, peer.Signer)
+	if err != nil {
+		return nil, nil, fmt.Errorf("Error creating signed proposal for %s: %s", chaincode, err)
+	}
+
+	return &Response{
+		TxID:     txID,
+		Channel:  channel,
+		Proposal: prop,
+	}, signedProp, nil
+}
 
 // getChaincodeBytes get chaincode deployment spec given the chaincode spec
 func getChaincodeBytes(spec *pb.ChaincodeSpec) (*pb.ChaincodeDeploymentSpec, error) {
@@ -136,4 +494,4 @@ func checkSpec(spec *pb.ChaincodeSpec) error {
 	}
 
 	return platform.ValidateSpec(spec)
-}
\ No newline at end of file
+}
@@ -0,0 +1,464 @@
+package services
+
+import (
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/hyperledger/fabric/common/cauthdsl"
+	"github.com/hyperledger/fabric/core/common/ccprovider"
+	"github.com/hyperledger/fabric/msp"
+	cb "github.com/hyperledger/fabric/protos/common"
+	mspproto "github.com/hyperledger/fabric/protos/msp"
+	ab "github.com/hyperledger/fabric/protos/orderer"
+	pb "github.com/hyperledger/fabric/protos/peer"
+	"github.com/hyperledger/fabric/protos/utils"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+)
+
+// testSigner signs with the identity name of an MSP, the signature being
+// the message itself
+type testSigner struct {
+	msp.SigningIdentity
+	mspID string
+	name  string
+}
+
+func (signer *testSigner) GetIdentifier() *msp.IdentityIdentifier {
+	return &msp.IdentityIdentifier{Mspid: signer.mspID, Id: signer.name}
+}
+
+func (signer *testSigner) GetMSPIdentifier() string {
+	return signer.mspID
+}
+
+func (signer *testSigner) Serialize() ([]byte, error) {
+	return proto.Marshal(&mspproto.SerializedIdentity{Mspid: signer.mspID, IdBytes: []byte(signer.name)})
+}
+
+func (signer *testSigner) Sign(msg []byte) ([]byte, error) {
+	return msg, nil
+}
+
+// testNetwork is a channel of endorsers and an orderer, which commits the
+// broadcast transactions in blocks of one transaction
+type testNetwork struct {
+	// commitDelay delays the commits, the transactions are never committed
+	// when negative
+	commitDelay time.Duration
+	// code is the validation code of the committed transactions
+	code pb.TxValidationCode
+	// policy is the endorsement policy of the chaincodes in lscc
+	policy *cb.SignaturePolicyEnvelope
+
+	mu      sync.Mutex
+	blocks  map[string]*cb.Block
+	number  uint64
+	queries int
+}
+
+func newTestNetwork() *testNetwork {
+	return &testNetwork{blocks: make(map[string]*cb.Block)}
+}
+
+func (network *testNetwork) commit(envelope *cb.Envelope) {
+	envelopeBytes, err := proto.Marshal(envelope)
+	if err != nil {
+		return
+	}
+	_, channelHeader, err := decodeEnvelope(envelopeBytes)
+	if err != nil || channelHeader == nil {
+		return
+	}
+	network.mu.Lock()
+	defer network.mu.Unlock()
+	network.number++
+	metadata := make([][]byte, cb.BlockMetadataIndex_TRANSACTIONS_FILTER+1)
+	metadata[cb.BlockMetadataIndex_TRANSACTIONS_FILTER] = []byte{byte(network.code)}
+	network.blocks[channelHeader.TxId] = &cb.Block{
+		Header:   &cb.BlockHeader{Number: network.number},
+		Data:     &cb.BlockData{Data: [][]byte{envelopeBytes}},
+		Metadata: &cb.BlockMetadata{Metadata: metadata},
+	}
+}
+
+// block answers GetBlockByTxID of qscc
+func (network *testNetwork) block(txID string) *pb.Response {
+	network.mu.Lock()
+	defer network.mu.Unlock()
+	network.queries++
+	block, ok := network.blocks[txID]
+	if !ok {
+		return &pb.Response{Status: 500, Message: "transaction " + txID + " not found"}
+	}
+	payload, err := proto.Marshal(block)
+	if err != nil {
+		return &pb.Response{Status: 500, Message: err.Error()}
+	}
+	return &pb.Response{Status: shimOK, Payload: payload}
+}
+
+// chaincodeData answers getccdata of lscc
+func (network *testNetwork) chaincodeData(chaincode string) *pb.Response {
+	if network.policy == nil {
+		return &pb.Response{Status: 500, Message: "chaincode " + chaincode + " not found"}
+	}
+	policy, err := proto.Marshal(network.policy)
+	if err != nil {
+		return &pb.Response{Status: 500, Message: err.Error()}
+	}
+	payload, err := proto.Marshal(&ccprovider.ChaincodeData{Name: chaincode, Version: "1.0", Policy: policy})
+	if err != nil {
+		return &pb.Response{Status: 500, Message: err.Error()}
+	}
+	return &pb.Response{Status: shimOK, Payload: payload}
+}
+
+// Broadcast accepts the transactions and commits them after commitDelay
+func (network *testNetwork) Broadcast(stream ab.AtomicBroadcast_BroadcastServer) error {
+	for {
+		envelope, err := stream.Recv()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if network.commitDelay >= 0 {
+			time.AfterFunc(network.commitDelay, func() { network.commit(envelope) })
+		}
+		err = stream.Send(&ab.BroadcastResponse{Status: cb.Status_SUCCESS})
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func (network *testNetwork) Deliver(stream ab.AtomicBroadcast_DeliverServer) error {
+	return errors.New("deliver is not supported")
+}
+
+// startOrderer serves the orderer of the network, until stopped
+func (network *testNetwork) startOrderer(t *testing.T) (*OrdererServices, func()) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := grpc.NewServer()
+	ab.RegisterAtomicBroadcastServer(server, network)
+	go server.Serve(listener)
+	return NewOrdererServices(listener.Addr().String()), server.Stop
+}
+
+// testEndorser endorses the proposals as a peer of an MSP. The chaincode
+// functions return their name and write it, "fail" failing, and qscc and
+// lscc answer from the network.
+type testEndorser struct {
+	network *testNetwork
+	mspID   string
+	// results replaces the read write set of the chaincodes when not empty
+	results string
+	// down fails the connection to the peer
+	down bool
+	// hung blocks the proposals until their deadline
+	hung bool
+	// proposals counts the proposals received
+	proposals int
+}
+
+func (endorser *testEndorser) ProcessProposal(ctx context.Context, signedProp *pb.SignedProposal, opts ...grpc.CallOption) (*pb.ProposalResponse, error) {
+	endorser.proposals++
+	if endorser.down {
+		return nil, errors.New("connection refused")
+	}
+	if endorser.hung {
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}
+	prop, err := utils.GetProposal(signedProp.ProposalBytes)
+	if err != nil {
+		return nil, err
+	}
+	invocation, err := utils.GetChaincodeInvocationSpec(prop)
+	if err != nil {
+		return nil, err
+	}
+	spec := invocation.ChaincodeSpec
+	results := spec.Input.Args[0]
+	var response *pb.Response
+	switch {
+	case spec.ChaincodeId.Name == "qscc":
+		response = endorser.network.block(string(spec.Input.Args[2]))
+	case spec.ChaincodeId.Name == "lscc":
+		response = endorser.network.chaincodeData(string(spec.Input.Args[2]))
+	case string(spec.Input.Args[0]) == "fail":
+		response = &pb.Response{Status: 500, Message: "failed by " + endorser.mspID}
+	default:
+		response = &pb.Response{Status: shimOK, Payload: spec.Input.Args[0]}
+		if endorser.results != "" {
+			results = []byte(endorser.results)
+		}
+	}
+	return endorser.endorse(response, results)
+}
+
+func (endorser *testEndorser) endorse(response *pb.Response, results []byte) (*pb.ProposalResponse, error) {
+	extension, err := proto.Marshal(&pb.ChaincodeAction{Results: results, Response: response})
+	if err != nil {
+		return nil, err
+	}
+	payload, err := proto.Marshal(&pb.ProposalResponsePayload{Extension: extension})
+	if err != nil {
+		return nil, err
+	}
+	identity, err := proto.Marshal(&mspproto.SerializedIdentity{Mspid: endorser.mspID})
+	if err != nil {
+		return nil, err
+	}
+	return &pb.ProposalResponse{
+		Response:    response,
+		Payload:     payload,
+		Endorsement: &pb.Endorsement{Endorser: identity, Signature: payload},
+	}, nil
+}
+
+// newTestPeer returns the peer services of the endorsers, the policy of
+// bonus requiring an endorsement of Org1MSP
+func newTestPeer(orderer *OrdererServices, endorsers ...*testEndorser) *PeerServices {
+	peer := &PeerServices{
+		Signer:        &testSigner{mspID: "Org1MSP", name: "client"},
+		Policies:      map[string]*EndorsementPolicy{"bonus": SignedByMsp("Org1MSP")},
+		Orderer:       orderer,
+		CommitTimeout: 2 * time.Second,
+		PollInterval:  10 * time.Millisecond,
+	}
+	for _, endorser := range endorsers {
+		peer.Endorsers = append(peer.Endorsers, &Endorser{Address: "peer." + endorser.mspID, Client: endorser})
+	}
+	return peer
+}
+
+// endorserMsp returns the MSP of the endorser of a proposal response
+func endorserMsp(t *testing.T, response *pb.ProposalResponse) string {
+	t.Helper()
+	msps, err := endorserMsps([]*pb.ProposalResponse{response})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return msps[0]
+}
+
+func TestInvokePollsTheCommitWithoutEvents(t *testing.T) {
+	network := newTestNetwork()
+	network.commitDelay = 50 * time.Millisecond
+	orderer, stop := network.startOrderer(t)
+	defer stop()
+	peer := newTestPeer(orderer, &testEndorser{network: network, mspID: "Org1MSP"})
+
+	response, err := peer.Invoke("mychannel", "bonus", "transfer", []string{"bob", "10"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !response.Committed || response.BlockNumber != 1 || response.ValidationCode != pb.TxValidationCode_VALID ||
+		response.Channel != "mychannel" || string(response.Payload) != "transfer" {
+		t.Fatalf("response %+v", response)
+	}
+	if network.queries < 2 {
+		t.Fatalf("commit found after %d queries", network.queries)
+	}
+
+	network.code = pb.TxValidationCode(11)
+	response, err = peer.Invoke("mychannel", "bonus", "transfer", []string{"bob", "10"})
+	if err == nil || !strings.Contains(err.Error(), "committed as invalid") {
+		t.Fatalf("invalid transaction: %v", err)
+	}
+	if !response.Committed || response.BlockNumber != 2 {
+		t.Fatalf("response %+v", response)
+	}
+}
+
+func TestInvokeTimesOutWhenTheTransactionIsNotCommitted(t *testing.T) {
+	network := newTestNetwork()
+	network.commitDelay = -1
+	orderer, stop := network.startOrderer(t)
+	defer stop()
+	peer := newTestPeer(orderer, &testEndorser{network: network, mspID: "Org1MSP"})
+	peer.CommitTimeout = 100 * time.Millisecond
+
+	response, err := peer.Invoke("mychannel", "bonus", "transfer", []string{"bob", "10"})
+	if err == nil || !strings.Contains(err.Error(), "Timeout waiting for the commit") || !strings.Contains(err.Error(), "not found") {
+		t.Fatalf("uncommitted transaction: %v", err)
+	}
+	if response == nil || response.Committed {
+		t.Fatalf("response %+v", response)
+	}
+}
+
+func TestInvokeWithTheDefaultTimeouts(t *testing.T) {
+	network := newTestNetwork()
+	orderer, stop := network.startOrderer(t)
+	defer stop()
+	peer := newTestPeer(&OrdererServices{Address: orderer.Address}, &testEndorser{network: network, mspID: "Org1MSP"})
+	peer.CommitTimeout = 0
+
+	response, err := peer.Invoke("mychannel", "bonus", "transfer", []string{"bob", "10"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !response.Committed {
+		t.Fatalf("response %+v", response)
+	}
+}
+
+func TestInvokeGivesUpOnAHungEndorser(t *testing.T) {
+	network := newTestNetwork()
+	orderer, stop := network.startOrderer(t)
+	defer stop()
+	hung := &testEndorser{network: network, mspID: "Org2MSP", hung: true}
+	peer := newTestPeer(orderer, &testEndorser{network: network, mspID: "Org1MSP"}, hung)
+	peer.Endorsers[1].Timeout = 50 * time.Millisecond
+
+	response, err := peer.Invoke("mychannel", "bonus", "transfer", []string{"bob", "10"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !response.Committed || hung.proposals != 1 {
+		t.Fatalf("response %+v", response)
+	}
+}
+
+func TestQueryStopsAtTheFirstAnswer(t *testing.T) {
+	network := newTestNetwork()
+	down := &testEndorser{network: network, mspID: "Org1MSP", down: true}
+	org2 := &testEndorser{network: network, mspID: "Org2MSP"}
+	org3 := &testEndorser{network: network, mspID: "Org3MSP"}
+	peer := newTestPeer(nil, down, org2, org3)
+
+	response, err := peer.Query("mychannel", "bonus", "query", []string{"alice"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(response.Payload) != "query" || len(response.Responses) != 1 || endorserMsp(t, response.Responses[0]) != "Org2MSP" {
+		t.Fatalf("response %+v", response)
+	}
+	if down.proposals != 1 || org2.proposals != 1 || org3.proposals != 0 {
+		t.Fatalf("proposals %d %d %d", down.proposals, org2.proposals, org3.proposals)
+	}
+
+	// the rejection of the chaincode is not asked again
+	_, err = peer.Query("mychannel", "bonus", "fail", nil)
+	chaincodeErr, ok := err.(*ChaincodeError)
+	if !ok || chaincodeErr.Endorser != "peer.Org2MSP" || chaincodeErr.Message != "failed by Org2MSP" || org3.proposals != 0 {
+		t.Fatalf("error %v", err)
+	}
+
+	org2.down, org3.down = true, true
+	if _, err = peer.Query("mychannel", "bonus", "query", nil); err == nil || !strings.Contains(err.Error(), "connection refused") {
+		t.Fatalf("error %v", err)
+	}
+}
+
+func TestInvokeSubmitsTheEndorsementsThePolicyNeeds(t *testing.T) {
+	network := newTestNetwork()
+	network.commitDelay = 0
+	// AND(OR('Org1MSP.member', 'Org2MSP.member'), 'Org3MSP.member')
+	network.policy = cauthdsl.SignedByMspMember("Org1MSP")
+	for _, mspID := range []string{"Org2MSP", "Org3MSP"} {
+		network.policy.Identities = append(network.policy.Identities, cauthdsl.SignedByMspMember(mspID).Identities...)
+	}
+	network.policy.Policy = cauthdsl.And(cauthdsl.Or(cauthdsl.SignedBy(0), cauthdsl.SignedBy(1)), cauthdsl.SignedBy(2))
+	orderer, stop := network.startOrderer(t)
+	defer stop()
+	// Org1MSP disagrees and Org4MSP is down, the endorsements of Org2MSP and
+	// Org3MSP are enough, those of the second peer of Org3MSP are not needed
+	peer := newTestPeer(orderer,
+		&testEndorser{network: network, mspID: "Org1MSP", results: "other"},
+		&testEndorser{network: network, mspID: "Org2MSP"},
+		&testEndorser{network: network, mspID: "Org3MSP"},
+		&testEndorser{network: network, mspID: "Org3MSP"},
+		&testEndorser{network: network, mspID: "Org4MSP", down: true})
+	delete(peer.Policies, "bonus")
+
+	response, err := peer.Invoke("mychannel", "bonus", "transfer", []string{"bob", "10"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !response.Committed || len(response.Responses) != 2 ||
+		endorserMsp(t, response.Responses[0]) != "Org2MSP" || endorserMsp(t, response.Responses[1]) != "Org3MSP" {
+		t.Fatalf("response %+v", response)
+	}
+	if policy := peer.Policies["bonus"]; policy == nil || policy.String() != "AND(OR('Org1MSP.member', 'Org2MSP.member'), 'Org3MSP.member')" {
+		t.Fatalf("policy %v", policy)
+	}
+
+	// the responses of Org3MSP differ from those of Org1MSP and Org2MSP
+	peer = newTestPeer(orderer,
+		&testEndorser{network: network, mspID: "Org1MSP"},
+		&testEndorser{network: network, mspID: "Org2MSP"},
+		&testEndorser{network: network, mspID: "Org3MSP", results: "other"})
+	delete(peer.Policies, "bonus")
+	if _, err = peer.Invoke("mychannel", "bonus", "transfer", nil); err == nil || !strings.Contains(err.Error(), "differ") {
+		t.Fatalf("error %v", err)
+	}
+
+	// without chaincode data in lscc nothing is endorsed
+	network.policy = nil
+	delete(peer.Policies, "bonus")
+	if _, err = peer.Invoke("mychannel", "bonus", "transfer", nil); err == nil || !strings.Contains(err.Error(), "not found") {
+		t.Fatalf("error %v", err)
+	}
+}
+
+func TestPolicySelectsTheEndorsementsItNeeds(t *testing.T) {
+	var responses []*pb.ProposalResponse
+	for _, mspID := range []string{"Org1MSP", "Org2MSP", "Org1MSP", "Org3MSP"} {
+		response, err := (&testEndorser{mspID: mspID}).endorse(&pb.Response{Status: shimOK}, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		responses = append(responses, response)
+	}
+	for _, c := range []struct {
+		policy   *EndorsementPolicy
+		selected []int
+	}{
+		{AnyOf(SignedByMsp("Org1MSP"), SignedByMsp("Org2MSP")), []int{0}},
+		{AllOf(SignedByMsp("Org1MSP"), SignedByMsp("Org1MSP")), []int{0, 2}},
+		{NOutOf(2, SignedByMsp("Org3MSP"), SignedByMsp("Org2MSP"), SignedByMsp("Org1MSP")), []int{1, 3}},
+		{AllOf(SignedByMsp("Org2MSP"), SignedByMsp("Org2MSP")), nil},
+	} {
+		selected, err := c.policy.Select(responses)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(selected) != len(c.selected) || (c.selected == nil) != (selected == nil) {
+			t.Errorf("%s selected %d endorsements", c.policy, len(selected))
+			continue
+		}
+		for i, index := range c.selected {
+			if selected[i] != responses[index] {
+				t.Errorf("%s selected %v", c.policy, selected)
+			}
+		}
+	}
+}
+
+func TestPolicyFromEnvelope(t *testing.T) {
+	envelope := cauthdsl.SignedByMspMember("Org1MSP")
+	envelope.Identities = append(envelope.Identities, cauthdsl.SignedByMspMember("Org2MSP").Identities...)
+	envelope.Policy = cauthdsl.NOutOf(1, []*cb.SignaturePolicy{cauthdsl.SignedBy(1), cauthdsl.SignedBy(0)})
+	policy, err := PolicyFromEnvelope(envelope)
+	if err != nil || policy.String() != "OR('Org2MSP.member', 'Org1MSP.member')" {
+		t.Fatalf("policy %v, %v", policy, err)
+	}
+	envelope.Policy = cauthdsl.SignedBy(2)
+	if _, err = PolicyFromEnvelope(envelope); err == nil {
+		t.Fatal("converted a policy signed by an unknown principal")
+	}
+}